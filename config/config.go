@@ -1,11 +1,19 @@
 package config
 
 import (
+	"fmt"
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
 	"github.com/zhinan22/DPLabsDemo/services"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// DefaultTransactionLimit 未配置TRANSACTION_LIMIT时默认获取的交易数量
+const DefaultTransactionLimit = 100
+
 // Config 应用配置
 type Config struct {
 	SolanaRPCUrl     string
@@ -13,19 +21,107 @@ type Config struct {
 	ServerPort       string
 	TransactionLimit int
 	OKXClient        services.OKXClient
+	// ZeroChangeEpsilon 买卖两边余额变化均不超过该值的订单会被忽略
+	ZeroChangeEpsilon float64
+	// PriceRefreshInterval 当前价格后台刷新间隔，0表示不启用
+	PriceRefreshInterval time.Duration
+	// PriceCacheTTL OKX价格查询结果（按代币和秒）的缓存时间，0表示不缓存
+	PriceCacheTTL time.Duration
+	// PriceWatchlist 需要后台刷新当前价格的代币列表
+	PriceWatchlist []string
+	// SignatureFetchBudget 签名分页查询的最长耗时，0表示不限制
+	SignatureFetchBudget time.Duration
+	// StablecoinMints 按1:1美元计价的稳定币mint，为空时使用services.DefaultStablecoinMints
+	StablecoinMints []string
+	// PprofAddr pprof管理端口监听地址（如127.0.0.1:6060），为空表示不启用
+	PprofAddr string
+	// LogLevel 日志级别（debug/info/warn/error），默认info
+	LogLevel string
+	// DecimalsCacheTTL 代币精度缓存过期时间，0表示不过期
+	DecimalsCacheTTL time.Duration
+	// LongTermHoldingDays 税务批次导出中长期持有的天数阈值
+	LongTermHoldingDays int
+	// MaxConcurrentPnL 同时进行的PnL计算请求上限，0表示不限制
+	MaxConcurrentPnL int
+	// PnLRequestTimeout 单次PnL请求（获取交易和计算）的超时时间，0表示不限制
+	PnLRequestTimeout time.Duration
+	// MaxInstructionDepth 指令树最大深度，<=0表示不限制
+	MaxInstructionDepth int
+	// TransactionFetch getTransaction请求参数（RPC_TX_ENCODING、RPC_TX_COMMITMENT、RPC_MAX_TX_VERSION）
+	TransactionFetch services.TransactionFetchOptions
+	// ProbeBuyFraction 小于最大买入该比例的建仓前买入视为试探性买入并忽略，0表示不过滤
+	ProbeBuyFraction float64
+	// SOLReconcileTolerance SOL腿余额变化与事件数量核对的相对容差，0表示不核对
+	SOLReconcileTolerance float64
+	// AdminToken /admin/*接口的Bearer token，为空表示不接受token认证
+	AdminToken string
+	// AdminHMACSecret /admin/*接口HMAC签名密钥，为空表示不接受签名认证
+	AdminHMACSecret string
+	// TokenListURL 启动时加载的代币列表地址（Jupiter格式），为空表示不加载
+	TokenListURL string
+	// TokenListRefreshInterval 代币列表刷新间隔，0表示只在启动时加载
+	TokenListRefreshInterval time.Duration
+	// ConfirmedCacheTTL 非finalized交易的缓存时间，0表示不缓存
+	ConfirmedCacheTTL time.Duration
+	// TransactionCacheSize 进程内交易缓存的最大条目数，超过时淘汰最久未使用的交易
+	TransactionCacheSize int
+	// RedisURL 交易缓存使用的Redis地址（redis://...），为空时使用进程内缓存
+	RedisURL string
+	// RedisCacheTTL finalized交易在Redis中的保存时间，0表示不过期
+	RedisCacheTTL time.Duration
+	// MaxRequestRPCCalls 单次请求预计RPC调用上限，超过时直接拒绝，0表示不限制
+	MaxRequestRPCCalls int
+	// MaxRequestOKXCalls 单次请求预计OKX调用上限，超过时直接拒绝，0表示不限制
+	MaxRequestOKXCalls int
+	// RPCConcurrency 同时进行的getTransaction请求上限
+	RPCConcurrency int
+	// RPCMaxAttempts 单笔getTransaction遇到限流、超时等暂时性错误时的最大尝试次数，1表示不重试
+	RPCMaxAttempts int
+	// RPCRetryBaseDelay 第一次重试前的等待时间，之后每次翻倍
+	RPCRetryBaseDelay time.Duration
+	// RPCBatchAPI 使用JSON-RPC批量请求获取交易，节点不支持时回退为并发单笔请求
+	RPCBatchAPI bool
+	// MintDiscoveryConcurrency 钱包代币发现的并发数，0使用默认值
+	MintDiscoveryConcurrency int
+	// MintDiscoveryBatchSize 钱包代币发现每批解析的交易数量，0使用默认值
+	MintDiscoveryBatchSize int
+	// AllowedPriceProviders 允许请求指定的价格提供方（PRICE_PROVIDERS，逗号分隔），为空时允许全部
+	AllowedPriceProviders []string
+	// JupiterPriceURL Jupiter Price API地址
+	JupiterPriceURL string
+	// JupiterDiscriminators 在默认值之外追加的route指令和swap事件discriminator（hex，逗号分隔）
+	JupiterDiscriminators services.JupiterDiscriminators
 }
 
 // LoadConfig 从环境变量加载配置
 func LoadConfig() (Config, error) {
-	// 默认值
-	transactionLimit := 1000
+	// 默认值：/pnl未传limit时使用，与ReadMe中"默认取最近100条"保持一致
+	transactionLimit := DefaultTransactionLimit
 	if val, exists := os.LookupEnv("TRANSACTION_LIMIT"); exists {
 		parsed, err := strconv.Atoi(val)
-		if err == nil {
+		if err == nil && parsed > 0 {
 			transactionLimit = parsed
 		}
 	}
 
+	transactionFetch := services.TransactionFetchOptions{
+		Encoding:   solana.EncodingType(getEnv("RPC_TX_ENCODING", "")),
+		Commitment: rpc.CommitmentType(getEnv("RPC_TX_COMMITMENT", "")),
+	}
+	// 交易按二进制编码或json解析，jsonParsed等其他编码无法解码
+	switch transactionFetch.Encoding {
+	case "", solana.EncodingBase64, solana.EncodingBase58, solana.EncodingJSON:
+	default:
+		return Config{}, fmt.Errorf("RPC_TX_ENCODING只支持base64、base58或json: %s", transactionFetch.Encoding)
+	}
+	if val, exists := os.LookupEnv("RPC_MAX_TX_VERSION"); exists {
+		parsed, err := strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			return Config{}, fmt.Errorf("RPC_MAX_TX_VERSION必须是非负整数: %s", val)
+		}
+		transactionFetch.MaxSupportedTransactionVersion = &parsed
+	}
+
 	port := "8080"
 	if val, exists := os.LookupEnv("PORT"); exists {
 		port = val
@@ -39,13 +135,55 @@ func LoadConfig() (Config, error) {
 		ApiKey:               getEnv("API_KEY", ""),
 		PassPhrase:           getEnv("PASS_PHRASE", ""),
 		SecretKey:            getEnv("SECRET_KEY", ""),
+		MaxResponseBytes:     int64(getEnvInt("OKX_MAX_RESPONSE_BYTES", services.DefaultOKXMaxResponseBytes)),
+		RequestTimeout:       time.Duration(getEnvInt("OKX_REQUEST_TIMEOUT_SECONDS", int(services.DefaultOKXRequestTimeout/time.Second))) * time.Second,
+		HistoricalBar:        getEnv("OKX_HISTORICAL_BAR", "1s"),
+		Limiter:              services.NewOKXRateLimiter(getEnvFloat("OKX_RATE_LIMIT", services.DefaultOKXRateLimit), getEnvInt("OKX_RATE_BURST", services.DefaultOKXRateBurst)),
 	}
 	return Config{
-		SolanaRPCUrl:     getEnv("SOLANA_RPC_URL", "https://api.mainnet-beta.solana.com"),
-		JupiterProgramID: getEnv("JUPITER_PROGRAM_ID", "JUP6LkbZbjS1jKKwapdHNy74zcZ3tLUZoi5QNyVTaV4"),
-		ServerPort:       port,
-		OKXClient:        OKXClientInstance,
-		TransactionLimit: transactionLimit,
+		SolanaRPCUrl:             getEnv("SOLANA_RPC_URL", "https://api.mainnet-beta.solana.com"),
+		JupiterProgramID:         getEnv("JUPITER_PROGRAM_ID", "JUP6LkbZbjS1jKKwapdHNy74zcZ3tLUZoi5QNyVTaV4"),
+		ServerPort:               port,
+		OKXClient:                OKXClientInstance,
+		TransactionLimit:         transactionLimit,
+		ZeroChangeEpsilon:        getEnvFloat("ZERO_CHANGE_EPSILON", 0),
+		PriceRefreshInterval:     time.Duration(getEnvInt("PRICE_REFRESH_INTERVAL_SECONDS", 0)) * time.Second,
+		PriceCacheTTL:            time.Duration(getEnvInt("PRICE_CACHE_TTL_SECONDS", int(services.DefaultPriceCacheTTL/time.Second))) * time.Second,
+		PriceWatchlist:           getEnvList("PRICE_WATCHLIST"),
+		SignatureFetchBudget:     time.Duration(getEnvInt("SIGNATURE_FETCH_BUDGET_SECONDS", 0)) * time.Second,
+		StablecoinMints:          getEnvList("STABLECOIN_MINTS"),
+		PprofAddr:                getEnv("PPROF_ADDR", ""),
+		LogLevel:                 getEnv("LOG_LEVEL", "info"),
+		DecimalsCacheTTL:         time.Duration(getEnvInt("DECIMALS_CACHE_TTL_SECONDS", 0)) * time.Second,
+		LongTermHoldingDays:      getEnvInt("LONG_TERM_HOLDING_DAYS", 365),
+		MaxConcurrentPnL:         getEnvInt("MAX_CONCURRENT_PNL", 0),
+		PnLRequestTimeout:        time.Duration(getEnvInt("PNL_REQUEST_TIMEOUT_SECONDS", 30)) * time.Second,
+		MaxInstructionDepth:      getEnvInt("MAX_INSTRUCTION_DEPTH", services.DefaultMaxInstructionDepth),
+		TransactionFetch:         transactionFetch,
+		ProbeBuyFraction:         getEnvFloat("PROBE_BUY_FRACTION", 0),
+		SOLReconcileTolerance:    getEnvFloat("SOL_RECONCILE_TOLERANCE", 0),
+		AdminToken:               getEnv("ADMIN_TOKEN", ""),
+		AdminHMACSecret:          getEnv("ADMIN_HMAC_SECRET", ""),
+		TokenListURL:             getEnv("TOKEN_LIST_URL", services.DefaultTokenListURL),
+		TokenListRefreshInterval: time.Duration(getEnvInt("TOKEN_LIST_REFRESH_INTERVAL_SECONDS", 0)) * time.Second,
+		ConfirmedCacheTTL:        time.Duration(getEnvInt("CONFIRMED_CACHE_TTL_SECONDS", int(services.DefaultConfirmedCacheTTL/time.Second))) * time.Second,
+		TransactionCacheSize:     getEnvInt("TX_CACHE_MAX_ENTRIES", services.DefaultTransactionCacheSize),
+		RedisURL:                 getEnv("REDIS_URL", ""),
+		RedisCacheTTL:            time.Duration(getEnvInt("REDIS_CACHE_TTL_SECONDS", 0)) * time.Second,
+		MaxRequestRPCCalls:       getEnvInt("MAX_REQUEST_RPC_CALLS", 0),
+		MaxRequestOKXCalls:       getEnvInt("MAX_REQUEST_OKX_CALLS", 0),
+		RPCConcurrency:           getEnvInt("RPC_CONCURRENCY", services.DefaultRPCConcurrency),
+		RPCMaxAttempts:           getEnvInt("RPC_MAX_ATTEMPTS", services.DefaultRPCMaxAttempts),
+		RPCRetryBaseDelay:        time.Duration(getEnvInt("RPC_RETRY_BASE_DELAY_MS", int(services.DefaultRPCRetryBaseDelay/time.Millisecond))) * time.Millisecond,
+		RPCBatchAPI:              getEnvBool("RPC_BATCH_API", false),
+		MintDiscoveryConcurrency: getEnvInt("MINT_DISCOVERY_CONCURRENCY", services.DefaultMintDiscoveryConcurrency),
+		MintDiscoveryBatchSize:   getEnvInt("MINT_DISCOVERY_BATCH_SIZE", services.DefaultMintDiscoveryBatchSize),
+		AllowedPriceProviders:    getEnvList("PRICE_PROVIDERS"),
+		JupiterPriceURL:          getEnv("JUPITER_PRICE_URL", services.DefaultJupiterPriceURL),
+		JupiterDiscriminators: services.JupiterDiscriminators{
+			Route: getEnvList("JUPITER_ROUTE_DISCRIMINATORS"),
+			Event: getEnvList("JUPITER_EVENT_DISCRIMINATORS"),
+		},
 	}, nil
 }
 
@@ -56,3 +194,44 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvFloat 获取浮点型环境变量，解析失败时返回默认值
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if val, exists := os.LookupEnv(key); exists {
+		if parsed, err := strconv.ParseFloat(val, 64); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+// getEnvInt 获取整型环境变量，解析失败时返回默认值
+func getEnvInt(key string, defaultValue int) int {
+	if val, exists := os.LookupEnv(key); exists {
+		if parsed, err := strconv.Atoi(val); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+// getEnvBool 获取布尔型环境变量，解析失败时返回默认值
+func getEnvBool(key string, defaultValue bool) bool {
+	if val, exists := os.LookupEnv(key); exists {
+		if parsed, err := strconv.ParseBool(val); err == nil {
+			return parsed
+		}
+	}
+	return defaultValue
+}
+
+// getEnvList 获取逗号分隔的列表型环境变量，忽略空项
+func getEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zhinan22/DPLabsDemo/services"
+)
+
+// 管理接口HMAC签名请求头
+const (
+	AdminTimestampHeader = "X-Admin-Timestamp" // Unix秒级时间戳
+	AdminSignatureHeader = "X-Admin-Signature" // hex(HMAC-SHA256(secret, timestamp\nmethod\nrequestURI\nbody))
+)
+
+// MaxAdminBodyBytes HMAC校验时读取的管理请求体大小上限
+const MaxAdminBodyBytes = 1 << 20
+
+// AdminSignatureMaxSkew HMAC签名允许的最大时间偏差，超出视为重放
+const AdminSignatureMaxSkew = 5 * time.Minute
+
+// AdminResponse 管理接口响应
+type AdminResponse struct {
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// AdminAuth 校验/admin/*请求：Authorization: Bearer <token>或HMAC签名任一通过即可，否则返回401
+// token和hmacSecret都未配置时拒绝所有请求，管理接口默认关闭
+func AdminAuth(token, hmacSecret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token != "" && validBearerToken(c.GetHeader("Authorization"), token) {
+			c.Next()
+			return
+		}
+		if hmacSecret != "" && validAdminSignature(c, hmacSecret) {
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, AdminResponse{Error: "未授权的管理请求"})
+	}
+}
+
+// validBearerToken 常量时间比较Bearer token
+func validBearerToken(header, token string) bool {
+	provided, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
+
+// validAdminSignature 校验时间戳在允许偏差内且签名与请求内容（路径、query和请求体）匹配，读取后恢复请求体供后续处理
+func validAdminSignature(c *gin.Context, secret string) bool {
+	timestamp := c.GetHeader(AdminTimestampHeader)
+	signature, err := hex.DecodeString(c.GetHeader(AdminSignatureHeader))
+	if timestamp == "" || err != nil || len(signature) == 0 {
+		return false
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	skew := time.Since(time.Unix(seconds, 0))
+	if skew > AdminSignatureMaxSkew || skew < -AdminSignatureMaxSkew {
+		return false
+	}
+
+	var body []byte
+	if c.Request.Body != nil {
+		body, err = io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, MaxAdminBodyBytes))
+		if err != nil {
+			return false
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	return hmac.Equal(signature, AdminSignature(secret, timestamp, c.Request.Method, c.Request.URL.RequestURI(), body))
+}
+
+// AdminSignature 计算管理请求的HMAC-SHA256签名，客户端按同样方式签名
+// requestURI为路径加原始query（如/admin/okx/candles?mint=...），与请求中的编码完全一致
+func AdminSignature(secret, timestamp, method, requestURI string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + method + "\n" + requestURI + "\n"))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// ClearCache 清空交易缓存
+func (h *PnLHandler) ClearCache(c *gin.Context) {
+	cleared := h.PnlService.ClearTransactionCache(c.Request.Context())
+	c.JSON(http.StatusOK, AdminResponse{Message: "已清空" + strconv.Itoa(cleared) + "条交易缓存"})
+}
+
+// OKXCandlesResponse OKX K线调试响应
+type OKXCandlesResponse struct {
+	Candles *services.MarketCandles `json:"candles,omitempty"`
+	Error   string                  `json:"error,omitempty"`
+}
+
+// GetOKXCandles 返回OKX原始K线响应和解析结果，time为Unix秒，默认当前时间
+func (h *PnLHandler) GetOKXCandles(c *gin.Context) {
+	mint := c.Query("mint")
+	if mint == "" {
+		c.JSON(http.StatusBadRequest, OKXCandlesResponse{Error: "缺少必要参数: mint"})
+		return
+	}
+	at := time.Now()
+	if ts := c.Query("time"); ts != "" {
+		seconds, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, OKXCandlesResponse{Error: "time必须是Unix秒级时间戳"})
+			return
+		}
+		at = time.Unix(seconds, 0)
+	}
+
+	candles, err := h.PnlService.GetOKXCandles(c.Request.Context(), mint, at)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, OKXCandlesResponse{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, OKXCandlesResponse{Candles: candles})
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/zhinan22/DPLabsDemo/services"
+)
+
+// pnlETag 由请求参数和参与计算的交易计算ETag，包含新交易后必然变化
+// 注意：持仓中头寸的未实现盈亏依赖当前价格，ETag不随价格变化，需要实时价格的调用方不应发送If-None-Match
+func pnlETag(req PnLRequest, transactions []*services.Transaction, truncated bool) string {
+	latestSlot, _ := services.LatestSlotAndTime(transactions)
+
+	h := sha256.New()
+	params, _ := json.Marshal(req)
+	h.Write(params)
+	h.Write([]byte{0})
+	// 同一slot可能包含多笔交易，签名列表保证交易集合变化时ETag变化
+	for _, tx := range transactions {
+		h.Write([]byte(tx.Signature))
+		h.Write([]byte{','})
+	}
+	fmt.Fprintf(h, "|%d|%t", latestSlot, truncated)
+
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// etagMatches 判断If-None-Match请求头是否包含etag，支持逗号分隔的多个值、弱校验前缀W/和*
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExportTrades 以CSV导出PnL计算中的逐笔交易明细，参数与GET /pnl相同，format只支持csv（默认）
+func (h *PnLHandler) ExportTrades(c *gin.Context) {
+	if format := c.Query("format"); format != "" && format != FormatCSV {
+		c.JSON(http.StatusBadRequest, PnLResponse{Error: "导出只支持format=csv"})
+		return
+	}
+	req, ok := h.parsePnLQuery(c)
+	if !ok {
+		return
+	}
+	req.Format = FormatCSV
+
+	h.respondPnL(c, req)
+}
+
+// writeTradesCSV 以CSV格式输出各头寸的逐笔交易，多个代币时按请求顺序依次输出
+func writeTradesCSV(c *gin.Context, req PnLRequest, response PnLResponse) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="trades_%s.csv"`, req.UserAddress))
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write([]string{"signature", "time", "side", "token", "amount", "priceUSD", "valueUSD", "realizedPnL"})
+	writeRows := func(mint string, response PnLResponse) {
+		for _, result := range response.Results {
+			for _, trade := range result.Trades {
+				_ = w.Write([]string{
+					trade.Signature,
+					trade.BlockTime.UTC().Format(time.RFC3339),
+					trade.Side,
+					mint,
+					strconv.FormatFloat(trade.Quantity, 'f', -1, 64),
+					strconv.FormatFloat(trade.PriceUSD, 'f', -1, 64),
+					strconv.FormatFloat(trade.ValueUSD, 'f', 2, 64),
+					strconv.FormatFloat(trade.RealizedPnL, 'f', 2, 64),
+				})
+			}
+		}
+	}
+	if len(req.TokenMints) > 0 {
+		for _, mint := range req.TokenMints {
+			writeRows(mint, response.ByMint[mint])
+		}
+	} else {
+		writeRows(req.TokenMint, response)
+	}
+	w.Flush()
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReadyTimeout /ready检查依赖的超时时间
+const ReadyTimeout = 3 * time.Second
+
+// ReadyResponse /ready响应，checks为各依赖的检查结果（ok或错误信息）
+type ReadyResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Health 存活检查，进程能处理请求即返回200
+func (h *PnLHandler) Health(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Ready 就绪检查：Solana RPC的getHealth和OKX可达性，任一失败返回503
+func (h *PnLHandler) Ready(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), ReadyTimeout)
+	defer cancel()
+
+	resp := ReadyResponse{Status: "ok", Checks: make(map[string]string)}
+	for name, check := range map[string]func(context.Context) error{
+		"rpc": h.PnlService.CheckRPC,
+		"okx": h.PnlService.CheckOKX,
+	} {
+		if err := check(ctx); err != nil {
+			resp.Status = "unavailable"
+			resp.Checks[name] = err.Error()
+			continue
+		}
+		resp.Checks[name] = "ok"
+	}
+
+	if resp.Status != "ok" {
+		c.JSON(http.StatusServiceUnavailable, resp)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
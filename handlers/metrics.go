@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// pnlRequestDuration PnL相关请求耗时，按路由和状态码区分
+var pnlRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "dplabs_pnl_request_duration_seconds",
+	Help:    "PnL请求处理耗时（秒）",
+	Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+}, []string{"route", "status"})
+
+// MetricsCollectors 返回handlers包的Prometheus指标
+func MetricsCollectors() []prometheus.Collector {
+	return []prometheus.Collector{pnlRequestDuration}
+}
+
+// RequestDuration 记录请求耗时到pnlRequestDuration
+func RequestDuration() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		pnlRequestDuration.WithLabelValues(c.FullPath(), strconv.Itoa(c.Writer.Status())).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Metrics 以Prometheus文本格式输出gatherer中的指标
+func Metrics(gatherer prometheus.Gatherer) gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zhinan22/DPLabsDemo/services"
+)
+
+// LimitConcurrency 限制同时进行的PnL计算数量，已满时直接返回503并通过Retry-After提示客户端重试
+// max<=0表示不限制
+func LimitConcurrency(max int, retryAfterSeconds int) gin.HandlerFunc {
+	if max <= 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+	if retryAfterSeconds <= 0 {
+		retryAfterSeconds = 1
+	}
+
+	semaphore := make(chan struct{}, max)
+	return func(c *gin.Context) {
+		select {
+		case semaphore <- struct{}{}:
+			defer func() { <-semaphore }()
+			c.Next()
+		default:
+			c.Header("Retry-After", strconv.Itoa(retryAfterSeconds))
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, PnLResponse{
+				Error: "服务繁忙，当前PnL计算请求已达上限，请稍后重试",
+			})
+		}
+	}
+}
+
+// RequestIDHeader 请求关联ID的请求头和响应头
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength 接受的客户端关联ID最大长度，超过时重新生成
+const maxRequestIDLength = 128
+
+// RequestID 为每个请求设置关联ID：优先使用客户端的X-Request-ID，否则随机生成
+// 关联ID写入响应头和请求context，服务和处理器的日志都会带上requestId字段
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = newRequestID()
+		}
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(services.ContextWithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// newRequestID 生成16字节随机十六进制ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
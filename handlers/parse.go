@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/gin-gonic/gin"
+	"github.com/zhinan22/DPLabsDemo/services"
+)
+
+// MaxParseBodyBytes /parse请求体大小上限
+const MaxParseBodyBytes = 4 << 20
+
+// ParseResponse 离线解析交易响应
+type ParseResponse struct {
+	Transaction *services.ParsedTransaction `json:"transaction,omitempty"`
+	Error       string                      `json:"error,omitempty"`
+}
+
+// ParseTransaction 解析请求体中getTransaction格式的交易JSON，返回订单、余额变化和指令树，不访问RPC
+// query参数userAddress为空时使用手续费支付者，tokenMint为空时返回任意代币的订单
+func (h *PnLHandler) ParseTransaction(c *gin.Context) {
+	var raw rpc.GetTransactionResult
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, MaxParseBodyBytes)
+	if err := json.NewDecoder(body).Decode(&raw); err != nil {
+		c.JSON(http.StatusBadRequest, ParseResponse{Error: "请求体不是合法的getTransaction结果: " + err.Error()})
+		return
+	}
+
+	parsed, err := h.PnlService.ParseRawTransaction(c.Request.Context(), &raw, c.Query("userAddress"), c.Query("tokenMint"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ParseResponse{Error: "解析交易失败: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ParseResponse{Transaction: parsed})
+}
@@ -2,32 +2,97 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/zhinan22/DPLabsDemo/services"
+	"log/slog"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/gagliardetto/solana-go"
 	"github.com/gin-gonic/gin"
+	"github.com/zhinan22/DPLabsDemo/services"
 )
 
+// DefaultRequestTimeout 单次PnL请求（获取交易和计算）的默认超时时间
+const DefaultRequestTimeout = 30 * time.Second
+
 // PnLHandler 处理PnL相关请求
 type PnLHandler struct {
-	PnlService *services.PnlService
+	PnlService        *services.PnlService
+	DefaultLimit      int           // 未传limit时使用的交易数量，来自配置TransactionLimit
+	LongTermThreshold time.Duration // 税务批次长期持有阈值
+	// AllowedPriceProviders 允许请求通过priceProvider指定的价格提供方
+	AllowedPriceProviders []string
+	// Logger 日志输出，默认slog.Default()
+	Logger *slog.Logger
+	// RequestTimeout 单次PnL请求的超时时间，客户端断开或超时后停止获取交易和计算，<=0表示不限制
+	RequestTimeout time.Duration
 }
 
 // NewPnLHandler 创建新的PnL处理器
-func NewPnLHandler(PnlService *services.PnlService) *PnLHandler {
+func NewPnLHandler(PnlService *services.PnlService, defaultLimit int) *PnLHandler {
 	return &PnLHandler{
-		PnlService: PnlService,
+		PnlService:        PnlService,
+		DefaultLimit:      defaultLimit,
+		LongTermThreshold: services.DefaultLongTermThreshold,
+		AllowedPriceProviders: []string{
+			services.PriceProviderOKX,
+			services.PriceProviderJupiter,
+			services.PriceProviderFixed,
+		},
+		Logger:         slog.Default(),
+		RequestTimeout: DefaultRequestTimeout,
 	}
 }
 
+// log 返回带当前请求关联ID的logger
+func (h *PnLHandler) log(c *gin.Context) *slog.Logger {
+	return services.LoggerWithRequestID(c.Request.Context(), h.Logger)
+}
+
 // PnLResponse API响应结构
 type PnLResponse struct {
-	ClosedPositions []ClosedPosition `json:"closedPositions,omitempty"`
-	OpenPosition    *OpenPosition    `json:"openPosition,omitempty"`
-	Error           string           `json:"error,omitempty"`
+	Results         []services.PnLResult `json:"results,omitempty"`
+	ClosedPositions []ClosedPosition     `json:"closedPositions,omitempty"`
+	OpenPosition    *OpenPosition        `json:"openPosition,omitempty"`
+	Total           int                  `json:"total,omitempty"`     // 分页前的头寸总数
+	Truncated       bool                 `json:"truncated,omitempty"` // 签名查询超出耗时预算，结果仅基于部分交易
+	Denom           string               `json:"denom,omitempty"`     // 请求指定的计价单位，sol时所有(USD)字段均为SOL数量
+	Error           string               `json:"error,omitempty"`
+	Details         []FieldError         `json:"details,omitempty"`         // 参数校验失败的字段明细
+	ComputedAt      *time.Time           `json:"computedAt,omitempty"`      // 计算完成时间
+	LatestSlot      uint64               `json:"latestSlot,omitempty"`      // 扫描到的最新交易slot
+	LatestBlockTime *time.Time           `json:"latestBlockTime,omitempty"` // 扫描到的最新交易时间
+
+	OverallAnnualized *services.AnnualizedReturn `json:"overallAnnualized,omitempty"` // 所有已平仓头寸的整体年化收益
+	ClosedSummary     *ClosedSummary             `json:"closedSummary,omitempty"`     // 已平仓头寸汇总统计
+	Summary           *PnLSummary                `json:"summary,omitempty"`           // 所有头寸的盈亏合计
+	Warnings          []string                   `json:"warnings,omitempty"`          // 计算过程中被跳过的订单等提示
+	OrdersBySource    map[string]int             `json:"ordersBySource,omitempty"`    // 参与计算的订单按DEX解析器来源计数
+	RoundTrips        []services.RoundTrip       `json:"roundTrips,omitempty"`        // 检测到的同代币短间隔往返交易（可能被夹）
+	UnmatchedSells    []services.UnmatchedSell   `json:"unmatchedSells,omitempty"`    // 没有买入记录的卖出，收入未计入已实现盈亏
+	ByMint            map[string]PnLResponse     `json:"byMint,omitempty"`            // 查询多个代币时按mint分别返回的结果
+}
+
+// ClosedSummary 已平仓头寸汇总统计
+type ClosedSummary struct {
+	Count                 int     `json:"count"`                 // 已平仓头寸数量
+	TotalRealized         float64 `json:"totalRealized"`         // 已实现盈亏合计(USD)
+	Winning               int     `json:"winning"`               // 盈利头寸数量
+	Losing                int     `json:"losing"`                // 亏损头寸数量
+	WinRate               float64 `json:"winRate"`               // 胜率（盈利数量/总数量，0-1）
+	AverageHoldingSeconds int64   `json:"averageHoldingSeconds"` // 平均持有时长（秒）
+}
+
+// PnLSummary 代币所有头寸（含持仓中）的盈亏合计
+type PnLSummary struct {
+	TotalRealized        float64 `json:"totalRealized"`        // 已实现盈亏合计(USD)
+	TotalUnrealized      float64 `json:"totalUnrealized"`      // 持仓中头寸的未实现盈亏合计(USD)
+	TotalInvested        float64 `json:"totalInvested"`        // 总投入成本合计(USD)
+	ProfitLossPercentage string  `json:"profitLossPercentage"` // (已实现+未实现)/总投入，即按投入加权的整体收益率
 }
 
 // ClosedPosition 已平仓头寸
@@ -47,58 +112,549 @@ type OpenPosition struct {
 
 // GetPnL 处理PnL查询请求
 func (h *PnLHandler) GetPnL(c *gin.Context) {
+	req, ok := h.parsePnLQuery(c)
+	if !ok {
+		return
+	}
+
+	h.respondPnL(c, req)
+}
+
+// parsePnLQuery 从query解析PnL查询参数，参数不合法时写入错误响应并返回false
+func (h *PnLHandler) parsePnLQuery(c *gin.Context) (PnLRequest, bool) {
 	// 获取请求参数
 	userAddress := c.Query("userAddress")
-	tokenMint := c.Query("tokenMint")
-	limitStr := c.DefaultQuery("limit", "100")
+	tokenMints := uniqueStrings(queryList(c, "tokenMint"))
+	limitStr := c.DefaultQuery("limit", strconv.Itoa(h.DefaultLimit))
 
 	// 验证必要参数
-	if userAddress == "" || tokenMint == "" {
+	if userAddress == "" || len(tokenMints) == 0 {
 		c.JSON(http.StatusBadRequest, PnLResponse{
 			Error: "缺少必要参数: userAddress和tokenMint都是必需的",
 		})
-		return
+		return PnLRequest{}, false
+	}
+	// 地址格式在入口校验，避免无效地址进入RPC调用后只得到笼统的错误
+	if _, err := solana.PublicKeyFromBase58(userAddress); err != nil {
+		c.JSON(http.StatusBadRequest, PnLResponse{
+			Error: "userAddress不是合法的base58地址",
+		})
+		return PnLRequest{}, false
+	}
+	for _, mint := range tokenMints {
+		if _, err := solana.PublicKeyFromBase58(mint); err != nil {
+			c.JSON(http.StatusBadRequest, PnLResponse{
+				Error: "tokenMint不是合法的base58地址: " + mint,
+			})
+			return PnLRequest{}, false
+		}
 	}
 
 	limit, err := strconv.Atoi(limitStr)
 	if err != nil {
-		// 转换失败（如字符串不是数字）
-		fmt.Printf("转换失败: %v\n", err)
-		return
+		c.JSON(http.StatusBadRequest, PnLResponse{
+			Error: "limit必须是整数",
+		})
+		return PnLRequest{}, false
+	}
+	if limit < 1 {
+		c.JSON(http.StatusBadRequest, PnLResponse{
+			Error: "limit必须大于0",
+		})
+		return PnLRequest{}, false
+	}
+	// 与POST一致，避免一次请求获取过多签名和交易
+	if limit > MaxLimit {
+		c.JSON(http.StatusBadRequest, PnLResponse{
+			Error: fmt.Sprintf("limit必须在1到%d之间", MaxLimit),
+		})
+		return PnLRequest{}, false
+	}
+
+	format := c.Query("format")
+	if format != "" && format != FormatJSON && format != FormatTable && format != FormatCSV {
+		c.JSON(http.StatusBadRequest, PnLResponse{
+			Error: "format只支持json、table或csv",
+		})
+		return PnLRequest{}, false
+	}
+	if format == FormatTable && len(tokenMints) > 1 {
+		c.JSON(http.StatusBadRequest, PnLResponse{
+			Error: "format=table只支持单个tokenMint",
+		})
+		return PnLRequest{}, false
+	}
+
+	positionModel := c.Query("positionModel")
+	if !validPositionModel(positionModel) {
+		c.JSON(http.StatusBadRequest, PnLResponse{
+			Error: "positionModel只支持perCycle或lifetime",
+		})
+		return PnLRequest{}, false
+	}
+
+	var startTime, endTime *time.Time
+	for name, dst := range map[string]**time.Time{"startTime": &startTime, "endTime": &endTime} {
+		if val := c.Query(name); val != "" {
+			t, err := parseTimeParam(val)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, PnLResponse{
+					Error: name + "必须是RFC3339时间或Unix秒",
+				})
+				return PnLRequest{}, false
+			}
+			*dst = &t
+		}
+	}
+	if startTime != nil && endTime != nil && startTime.After(*endTime) {
+		c.JSON(http.StatusBadRequest, PnLResponse{
+			Error: "startTime不能晚于endTime",
+		})
+		return PnLRequest{}, false
+	}
+
+	method := c.Query("method")
+	if !services.ValidCostBasisMethod(services.CostBasisMethod(method)) {
+		c.JSON(http.StatusBadRequest, PnLResponse{
+			Error: "method只支持average或fifo",
+		})
+		return PnLRequest{}, false
+	}
+
+	var roundTripSlots uint64
+	if val := c.Query("roundTripSlots"); val != "" {
+		roundTripSlots, err = strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, PnLResponse{
+				Error: "roundTripSlots必须是非负整数",
+			})
+			return PnLRequest{}, false
+		}
+	}
+
+	var fixedPrice float64
+	if val := c.Query("fixedPrice"); val != "" {
+		fixedPrice, err = strconv.ParseFloat(val, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, PnLResponse{
+				Error: "fixedPrice必须是数字",
+			})
+			return PnLRequest{}, false
+		}
+	}
+	priceProvider := c.Query("priceProvider")
+	if fieldErr := h.validatePriceProvider(priceProvider, fixedPrice); fieldErr != nil {
+		c.JSON(http.StatusBadRequest, PnLResponse{
+			Error: fieldErr.Message,
+		})
+		return PnLRequest{}, false
+	}
+
+	var initialQuantity, initialCostUSD float64
+	for name, dst := range map[string]*float64{"initialQuantity": &initialQuantity, "initialCostUSD": &initialCostUSD} {
+		if val := c.Query(name); val != "" {
+			if *dst, err = strconv.ParseFloat(val, 64); err != nil {
+				c.JSON(http.StatusBadRequest, PnLResponse{
+					Error: name + "必须是数字",
+				})
+				return PnLRequest{}, false
+			}
+		}
+	}
+	if fieldErr := validateInitialPosition(initialQuantity, initialCostUSD); fieldErr != nil {
+		c.JSON(http.StatusBadRequest, PnLResponse{
+			Error: fieldErr.Message,
+		})
+		return PnLRequest{}, false
+	}
+	if initialQuantity > 0 && len(tokenMints) > 1 {
+		c.JSON(http.StatusBadRequest, PnLResponse{
+			Error: "初始持仓只支持单个tokenMint",
+		})
+		return PnLRequest{}, false
+	}
+
+	var minUSDValue float64
+	if val := c.Query("minUsdValue"); val != "" {
+		minUSDValue, err = strconv.ParseFloat(val, 64)
+		if err != nil || minUSDValue < 0 {
+			c.JSON(http.StatusBadRequest, PnLResponse{
+				Error: "minUsdValue必须是非负数",
+			})
+			return PnLRequest{}, false
+		}
+	}
+
+	untilSignature := c.Query("untilSignature")
+	if untilSignature != "" {
+		if _, err := solana.SignatureFromBase58(untilSignature); err != nil {
+			c.JSON(http.StatusBadRequest, PnLResponse{
+				Error: "untilSignature不是合法的交易签名",
+			})
+			return PnLRequest{}, false
+		}
+	}
+
+	denom := c.Query("denom")
+	if !services.ValidDenomination(denom) {
+		c.JSON(http.StatusBadRequest, PnLResponse{
+			Error: "denom只支持usd或sol",
+		})
+		return PnLRequest{}, false
+	}
+
+	var offset, pageSize int
+	for name, dst := range map[string]*int{"offset": &offset, "pageSize": &pageSize} {
+		if val := c.Query(name); val != "" {
+			if *dst, err = strconv.Atoi(val); err != nil {
+				c.JSON(http.StatusBadRequest, PnLResponse{
+					Error: name + "必须是整数",
+				})
+				return PnLRequest{}, false
+			}
+		}
+	}
+	if fieldErr := validatePagination(offset, pageSize); fieldErr != nil {
+		c.JSON(http.StatusBadRequest, PnLResponse{
+			Error: fieldErr.Message,
+		})
+		return PnLRequest{}, false
+	}
+
+	// 单个代币保持原有的tokenMint字段，多个代币放入TokenMints
+	var tokenMint string
+	if len(tokenMints) == 1 {
+		tokenMint, tokenMints = tokenMints[0], nil
+	}
+
+	return PnLRequest{
+		UserAddress:       userAddress,
+		TokenMint:         tokenMint,
+		TokenMints:        tokenMints,
+		Limit:             limit,
+		ExcludeSignatures: queryList(c, "excludeSignatures"),
+		Format:            format,
+		SkipUnpriceable:   c.Query("skipUnpriceable") == "true",
+		PositionModel:     positionModel,
+		Method:            method,
+		StartTime:         startTime,
+		EndTime:           endTime,
+		RoundTripSlots:    roundTripSlots,
+		ExcludeRoundTrips: c.Query("excludeRoundTrips") == "true",
+		PriceProvider:     priceProvider,
+		FixedPrice:        fixedPrice,
+		InitialQuantity:   initialQuantity,
+		InitialCostUSD:    initialCostUSD,
+		Offset:            offset,
+		PageSize:          pageSize,
+		Debug:             c.Query("debug") == "true",
+		MinUSDValue:       minUSDValue,
+		Denom:             denom,
+		UntilSignature:    untilSignature,
+	}, true
+}
+
+// respondPnL 获取交易、计算PnL并返回结果，GET和POST共用
+func (h *PnLHandler) respondPnL(c *gin.Context, req PnLRequest) {
+	// 获取交易和计算共用同一个context，客户端断开或超时后两者都会停止
+	ctx := c.Request.Context()
+	if h.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.RequestTimeout)
+		defer cancel()
 	}
 
 	// 获取用户与Jupiter的交易
-	transactions, err := h.PnlService.GetTransactions(
-		c.Request.Context(),
-		userAddress,
-		limit,
+	transactions, truncated, err := h.PnlService.GetTransactionsInRange(
+		ctx,
+		req.UserAddress,
+		req.Limit,
+		req.timeRange(),
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, PnLResponse{
+		c.JSON(fetchErrorStatus(err), PnLResponse{
 			Error: "获取交易记录失败: " + err.Error(),
 		})
 		return
 	}
 
-	results, err := h.PnlService.CalculatePnL(context.Background(), transactions, userAddress, tokenMint)
+	// 排除用户指定的交易签名
+	transactions = services.ExcludeSignatures(transactions, req.ExcludeSignatures)
+
+	// 交易集合和参数都没有变化时返回304，跳过PnL计算
+	etag := pnlETag(req, transactions, truncated)
+	c.Header("ETag", etag)
+	if match := c.GetHeader("If-None-Match"); match != "" && etagMatches(match, etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	computedAt := time.Now().UTC()
+	var response PnLResponse
+	if len(req.TokenMints) > 0 {
+		// 多个代币共用同一批交易，逐个代币计算
+		response.ByMint = make(map[string]PnLResponse, len(req.TokenMints))
+		for _, mint := range req.TokenMints {
+			mintResponse, err := h.calculateMint(ctx, transactions, req, mint)
+			if err != nil {
+				c.JSON(fetchErrorStatus(err), PnLResponse{
+					Error: fmt.Sprintf("计算代币 %s 的PnL失败: %s", mint, err.Error()),
+				})
+				return
+			}
+			response.ByMint[mint] = mintResponse
+		}
+	} else {
+		response, err = h.calculateMint(ctx, transactions, req, req.TokenMint)
+		if err != nil {
+			c.JSON(fetchErrorStatus(err), PnLResponse{
+				Error: "获取交易记录失败: " + err.Error(),
+			})
+			return
+		}
+	}
+	response.Truncated = truncated
+	response.Denom = req.Denom
+	response.ComputedAt = &computedAt
+	if latestSlot, latestBlockTime := services.LatestSlotAndTime(transactions); latestSlot > 0 {
+		response.LatestSlot = latestSlot
+		response.LatestBlockTime = &latestBlockTime
+	}
+	h.log(c).Debug("PnL计算完成", "userAddress", req.UserAddress, "tokenMint", req.TokenMint,
+		"transactions", len(transactions), "results", len(response.Results), "truncated", truncated)
+	switch req.Format {
+	case FormatTable:
+		writePnLTable(c, req.TokenMint, response)
+	case FormatCSV:
+		writeTradesCSV(c, req, response)
+	default:
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// calculateMint 计算单个代币的PnL及汇总统计，不包含交易范围相关字段
+func (h *PnLHandler) calculateMint(ctx context.Context, transactions []*services.Transaction, req PnLRequest, mint string) (PnLResponse, error) {
+	calc, err := h.PnlService.CalculatePnLWithOptions(ctx, transactions, req.UserAddress, mint, req.pnlOptions())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, PnLResponse{
-			Error: "获取交易记录失败: " + err.Error(),
+		return PnLResponse{}, err
+	}
+	// 汇总统计和持仓中头寸基于全部头寸，分页只影响返回的results和closedPositions
+	results := paginateResults(calc.Results, req.Offset, req.PageSize)
+	closed, _ := splitPositions(results)
+	_, open := splitPositions(calc.Results)
+	return PnLResponse{
+		Results:           results,
+		ClosedPositions:   closed,
+		OpenPosition:      open,
+		Warnings:          calc.Warnings,
+		OrdersBySource:    calc.OrdersBySource,
+		RoundTrips:        calc.RoundTrips,
+		UnmatchedSells:    calc.UnmatchedSells,
+		OverallAnnualized: overallAnnualized(calc.Results),
+		ClosedSummary:     summarizeClosed(calc.Results),
+		Summary:           summarizePnL(calc.Results),
+		Total:             len(calc.Results),
+	}, nil
+}
+
+// splitPositions 将PnL结果转换为已平仓头寸列表和持仓中头寸，最多只有最后一个头寸未平仓
+func splitPositions(results []services.PnLResult) ([]ClosedPosition, *OpenPosition) {
+	var closed []ClosedPosition
+	var open *OpenPosition
+	for _, r := range results {
+		if r.IsClosed {
+			closed = append(closed, ClosedPosition{
+				AverageCost:          r.AverageCost,
+				ProfitLossPercentage: r.ProfitLossPercentage,
+				ProfitLossValue:      r.ProfitLossValue,
+			})
+			continue
+		}
+		open = &OpenPosition{
+			AverageCost:               r.AverageCost,
+			ProfitLossPercentage:      r.ProfitLossPercentage,
+			RealizedProfitLossValue:   r.ProfitLossValue,
+			UnrealizedProfitLossValue: r.UnrealizedProfitLossValue,
+		}
+	}
+	return closed, open
+}
+
+// paginateResults 按首笔交易时间排序后返回第offset个头寸开始的pageSize个头寸，pageSize为0时返回全部
+func paginateResults(results []services.PnLResult, offset, pageSize int) []services.PnLResult {
+	if offset == 0 && pageSize == 0 {
+		return results
+	}
+	sorted := append([]services.PnLResult(nil), results...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].OpenedAt.Before(sorted[j].OpenedAt)
+	})
+	if offset >= len(sorted) {
+		return nil
+	}
+	sorted = sorted[offset:]
+	if pageSize > 0 && pageSize < len(sorted) {
+		sorted = sorted[:pageSize]
+	}
+	return sorted
+}
+
+// summarizePnL 合计所有头寸的盈亏和投入，收益率用合计值计算而不是对各头寸百分比取平均，没有头寸时返回nil
+func summarizePnL(results []services.PnLResult) *PnLSummary {
+	if len(results) == 0 {
+		return nil
+	}
+	summary := &PnLSummary{}
+	for _, r := range results {
+		summary.TotalRealized += r.ProfitLossValue
+		summary.TotalUnrealized += r.UnrealizedProfitLossValue
+		summary.TotalInvested += r.TotalInvestment
+	}
+	var percentage float64
+	if summary.TotalInvested > 0 {
+		percentage = (summary.TotalRealized + summary.TotalUnrealized) / summary.TotalInvested * 100
+	}
+	summary.ProfitLossPercentage = fmt.Sprintf("%.2f%%", percentage)
+	return summary
+}
+
+// overallAnnualized 汇总所有已平仓头寸，按首笔买入到最后平仓的时间跨度计算整体年化收益
+func overallAnnualized(results []services.PnLResult) *services.AnnualizedReturn {
+	var realized, investment float64
+	var start, end time.Time
+	for _, r := range results {
+		if !r.IsClosed {
+			continue
+		}
+		realized += r.ProfitLossValue
+		investment += r.TotalInvestment
+		if start.IsZero() || r.OpenedAt.Before(start) {
+			start = r.OpenedAt
+		}
+		if r.LastTradeAt.After(end) {
+			end = r.LastTradeAt
+		}
+	}
+	if investment <= 0 {
+		return nil
+	}
+	return services.AnnualizeReturn(realized, investment, end.Sub(start))
+}
+
+// summarizeClosed 汇总已平仓头寸的已实现盈亏、胜负数量和平均持有时长，没有已平仓头寸时返回nil
+func summarizeClosed(results []services.PnLResult) *ClosedSummary {
+	summary := &ClosedSummary{}
+	var holding int64
+	for _, r := range results {
+		if !r.IsClosed {
+			continue
+		}
+		summary.Count++
+		summary.TotalRealized += r.ProfitLossValue
+		switch {
+		case r.ProfitLossValue > 0:
+			summary.Winning++
+		case r.ProfitLossValue < 0:
+			summary.Losing++
+		}
+		holding += r.HoldingSeconds
+	}
+	if summary.Count == 0 {
+		return nil
+	}
+	summary.WinRate = float64(summary.Winning) / float64(summary.Count)
+	summary.AverageHoldingSeconds = holding / int64(summary.Count)
+	return summary
+}
+
+// TxOrdersResponse 单笔交易订单查询响应
+type TxOrdersResponse struct {
+	Orders     []services.Order          `json:"orders,omitempty"`
+	Diagnostic *services.RouteDiagnostic `json:"diagnostic,omitempty"`
+	Error      string                    `json:"error,omitempty"`
+}
+
+// GetTxOrders 查询单笔交易解析出的订单，verbose=true时附带route账户和事件诊断信息
+func (h *PnLHandler) GetTxOrders(c *gin.Context) {
+	signature := c.Param("signature")
+	userAddress := c.Query("userAddress")
+	tokenMint := c.Query("tokenMint")
+	verbose := c.Query("verbose") == "true"
+
+	if userAddress == "" || tokenMint == "" {
+		c.JSON(http.StatusBadRequest, TxOrdersResponse{
+			Error: "缺少必要参数: userAddress和tokenMint都是必需的",
 		})
+		return
 	}
 
-	response := results
-	// 将数组格式化为带缩进的 JSON
-	jsonData, err := json.MarshalIndent(results, "", "  ")
+	orders, diag, err := h.PnlService.GetTransactionOrders(c.Request.Context(), signature, userAddress, tokenMint, verbose)
 	if err != nil {
-		fmt.Printf("JSON 格式化失败: %v\n", err)
+		c.JSON(http.StatusInternalServerError, TxOrdersResponse{
+			Error: "解析交易订单失败: " + err.Error(),
+		})
 		return
 	}
 
-	// 打印格式化后的 JSON
-	fmt.Println("PnL 结果数组:")
-	fmt.Println(string(jsonData))
-	c.JSON(http.StatusOK, response)
+	c.JSON(http.StatusOK, TxOrdersResponse{
+		Orders:     orders,
+		Diagnostic: diag,
+	})
+}
+
+// fetchErrorStatus 获取交易或计算PnL失败时的HTTP状态码：RPC节点不支持所需方法、OKX响应无法解析属于上游问题，返回502；
+// 请求预计成本超过上限时返回400，调用方可减小limit后重试
+func fetchErrorStatus(err error) int {
+	var unsupported *services.UnsupportedRPCMethodError
+	if errors.As(err, &unsupported) {
+		return http.StatusBadGateway
+	}
+	if errors.Is(err, services.ErrOKXInvalidResponse) {
+		return http.StatusBadGateway
+	}
+	var overCap *services.CostCapExceededError
+	if errors.As(err, &overCap) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// queryList 读取列表型查询参数，同时支持重复传参和逗号分隔
+func queryList(c *gin.Context, key string) []string {
+	var list []string
+	for _, value := range c.QueryArray(key) {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// parseTimeParam 解析时间参数，支持Unix秒和RFC3339
+func parseTimeParam(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// uniqueStrings 去除重复项并保持原有顺序
+func uniqueStrings(list []string) []string {
+	seen := make(map[string]struct{}, len(list))
+	var out []string
+	for _, item := range list {
+		if _, ok := seen[item]; ok {
+			continue
+		}
+		seen[item] = struct{}{}
+		out = append(out, item)
+	}
+	return out
 }
 
 // 辅助函数：将字符串转换为整数
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gin-gonic/gin"
+	"github.com/zhinan22/DPLabsDemo/services"
+)
+
+// MaxLimit 单次请求允许获取的最大交易数量
+const MaxLimit = 1000
+
+// MaxPageSize 分页时每页允许的最大头寸数量
+const MaxPageSize = 500
+
+// PnLRequest PnL查询参数，GET由query解析，POST由JSON请求体解析
+type PnLRequest struct {
+	UserAddress       string     `json:"userAddress"`
+	TokenMint         string     `json:"tokenMint"`
+	TokenMints        []string   `json:"tokenMints,omitempty"` // 同时查询多个代币，交易只获取一次，结果按mint返回在byMint中
+	Limit             int        `json:"limit"`
+	ExcludeSignatures []string   `json:"excludeSignatures"`
+	Format            string     `json:"format"`              // 响应格式：json（默认）、table或csv
+	SkipUnpriceable   bool       `json:"skipUnpriceable"`     // 跳过无法定价的订单并在warnings中说明，默认严格模式
+	PositionModel     string     `json:"positionModel"`       // 持仓模型：perCycle（默认）或lifetime
+	Method            string     `json:"method"`              // 成本计算方法：average（默认）或fifo
+	RoundTripSlots    uint64     `json:"roundTripSlots"`      // 标记间隔不超过该slot数的同代币往返交易，0表示不检测
+	ExcludeRoundTrips bool       `json:"excludeRoundTrips"`   // 将标记的往返交易排除在PnL计算之外
+	PriceProvider     string     `json:"priceProvider"`       // 价格提供方：okx（默认）、jupiter或fixed，须在允许列表中
+	FixedPrice        float64    `json:"fixedPrice"`          // priceProvider为fixed时目标代币使用的价格(USD)
+	InitialQuantity   float64    `json:"initialQuantity"`     // 计算前已持有的数量（如转入），不能为负数
+	InitialCostUSD    float64    `json:"initialCostUSD"`      // 初始持仓的总成本(USD)
+	StartTime         *time.Time `json:"startTime,omitempty"` // 只计算不早于该时间的交易（RFC3339）
+	EndTime           *time.Time `json:"endTime,omitempty"`   // 只计算不晚于该时间的交易（RFC3339）
+	Offset            int        `json:"offset,omitempty"`    // 分页：跳过按首笔交易时间排序的前offset个头寸
+	PageSize          int        `json:"pageSize,omitempty"`  // 分页：每页头寸数量，0表示返回全部
+	Debug             bool       `json:"debug,omitempty"`     // 在每笔交易明细中附带解析出的swap事件（每跳一个）
+	MinUSDValue       float64    `json:"minUsdValue"`         // 忽略美元价值低于该值的订单（粉尘交易），0表示不过滤
+	Denom             string     `json:"denom,omitempty"`     // 盈亏计价单位：usd（默认）或sol
+	UntilSignature    string     `json:"untilSignature"`      // 已同步到的签名，只获取比它更新的交易，用于增量同步
+}
+
+// pnlOptions 请求中的PnL计算选项
+func (r PnLRequest) pnlOptions() services.PnLOptions {
+	return services.PnLOptions{
+		SkipUnpriceable:   r.SkipUnpriceable,
+		PositionModel:     r.PositionModel,
+		CostBasisMethod:   services.CostBasisMethod(r.Method),
+		RoundTripSlots:    r.RoundTripSlots,
+		ExcludeRoundTrips: r.ExcludeRoundTrips,
+		PriceProvider:     r.PriceProvider,
+		FixedPrice:        r.FixedPrice,
+		InitialQuantity:   r.InitialQuantity,
+		InitialCostUSD:    r.InitialCostUSD,
+		IncludeEvents:     r.Debug,
+		MinUSDValue:       r.MinUSDValue,
+		Denomination:      r.Denom,
+	}
+}
+
+// timeRange 请求的交易时间范围，未指定的一端不限制
+func (r PnLRequest) timeRange() services.TimeRange {
+	var timeRange services.TimeRange
+	if r.StartTime != nil {
+		timeRange.Start = *r.StartTime
+	}
+	if r.EndTime != nil {
+		timeRange.End = *r.EndTime
+	}
+	// 签名格式已在校验时检查
+	if r.UntilSignature != "" {
+		timeRange.Until, _ = solana.SignatureFromBase58(r.UntilSignature)
+	}
+	return timeRange
+}
+
+// FieldError 字段级校验错误
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// PostPnL 通过JSON请求体查询PnL
+func (h *PnLHandler) PostPnL(c *gin.Context) {
+	req := PnLRequest{Limit: h.DefaultLimit}
+
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		c.JSON(http.StatusBadRequest, PnLResponse{
+			Error:   "请求体格式错误",
+			Details: decodeErrorDetails(err),
+		})
+		return
+	}
+
+	details := req.validate()
+	if fieldErr := h.validatePriceProvider(req.PriceProvider, req.FixedPrice); fieldErr != nil {
+		details = append(details, *fieldErr)
+	}
+	if len(details) > 0 {
+		c.JSON(http.StatusBadRequest, PnLResponse{
+			Error:   "参数校验失败",
+			Details: details,
+		})
+		return
+	}
+
+	h.respondPnL(c, req)
+}
+
+// validate 校验请求参数，返回所有不合法字段
+func (r PnLRequest) validate() []FieldError {
+	var details []FieldError
+
+	if r.UserAddress == "" {
+		details = append(details, FieldError{Field: "userAddress", Message: "userAddress不能为空"})
+	} else if _, err := solana.PublicKeyFromBase58(r.UserAddress); err != nil {
+		details = append(details, FieldError{Field: "userAddress", Message: "userAddress不是合法的base58地址"})
+	}
+
+	switch {
+	case r.TokenMint != "" && len(r.TokenMints) > 0:
+		details = append(details, FieldError{Field: "tokenMints", Message: "tokenMint和tokenMints不能同时指定"})
+	case len(r.TokenMints) > 0:
+		for i, mint := range r.TokenMints {
+			if _, err := solana.PublicKeyFromBase58(mint); err != nil {
+				details = append(details, FieldError{
+					Field:   fmt.Sprintf("tokenMints[%d]", i),
+					Message: "不是合法的base58地址",
+				})
+			}
+		}
+		if r.Format == FormatTable {
+			details = append(details, FieldError{Field: "format", Message: "format=table只支持单个tokenMint"})
+		}
+		if r.InitialQuantity > 0 {
+			details = append(details, FieldError{Field: "initialQuantity", Message: "初始持仓只支持单个tokenMint"})
+		}
+	case r.TokenMint == "":
+		details = append(details, FieldError{Field: "tokenMint", Message: "tokenMint不能为空"})
+	default:
+		if _, err := solana.PublicKeyFromBase58(r.TokenMint); err != nil {
+			details = append(details, FieldError{Field: "tokenMint", Message: "tokenMint不是合法的base58地址"})
+		}
+	}
+
+	if r.Limit < 1 || r.Limit > MaxLimit {
+		details = append(details, FieldError{Field: "limit", Message: fmt.Sprintf("limit必须在1到%d之间", MaxLimit)})
+	}
+
+	for i, sig := range r.ExcludeSignatures {
+		if _, err := solana.SignatureFromBase58(sig); err != nil {
+			details = append(details, FieldError{
+				Field:   fmt.Sprintf("excludeSignatures[%d]", i),
+				Message: "不是合法的交易签名",
+			})
+		}
+	}
+
+	if r.Format != "" && r.Format != FormatJSON && r.Format != FormatTable && r.Format != FormatCSV {
+		details = append(details, FieldError{Field: "format", Message: "format只支持json、table或csv"})
+	}
+
+	if !validPositionModel(r.PositionModel) {
+		details = append(details, FieldError{Field: "positionModel", Message: "positionModel只支持perCycle或lifetime"})
+	}
+
+	if !services.ValidCostBasisMethod(services.CostBasisMethod(r.Method)) {
+		details = append(details, FieldError{Field: "method", Message: "method只支持average或fifo"})
+	}
+
+	if fieldErr := validateInitialPosition(r.InitialQuantity, r.InitialCostUSD); fieldErr != nil {
+		details = append(details, *fieldErr)
+	}
+
+	if r.StartTime != nil && r.EndTime != nil && r.StartTime.After(*r.EndTime) {
+		details = append(details, FieldError{Field: "startTime", Message: "startTime不能晚于endTime"})
+	}
+
+	if fieldErr := validatePagination(r.Offset, r.PageSize); fieldErr != nil {
+		details = append(details, *fieldErr)
+	}
+
+	if r.MinUSDValue < 0 {
+		details = append(details, FieldError{Field: "minUsdValue", Message: "minUsdValue不能为负数"})
+	}
+
+	if r.UntilSignature != "" {
+		if _, err := solana.SignatureFromBase58(r.UntilSignature); err != nil {
+			details = append(details, FieldError{Field: "untilSignature", Message: "untilSignature不是合法的交易签名"})
+		}
+	}
+
+	if !services.ValidDenomination(r.Denom) {
+		details = append(details, FieldError{Field: "denom", Message: "denom只支持usd或sol"})
+	}
+
+	return details
+}
+
+// validatePagination offset不能为负数，pageSize为0（不分页）或1到MaxPageSize之间
+func validatePagination(offset, pageSize int) *FieldError {
+	if offset < 0 {
+		return &FieldError{Field: "offset", Message: "offset不能为负数"}
+	}
+	if pageSize < 0 || pageSize > MaxPageSize {
+		return &FieldError{Field: "pageSize", Message: fmt.Sprintf("pageSize必须在1到%d之间", MaxPageSize)}
+	}
+	return nil
+}
+
+// validPositionModel 持仓模型为空或为支持的取值
+func validPositionModel(model string) bool {
+	return model == "" || model == services.PositionModelPerCycle || model == services.PositionModelLifetime
+}
+
+// validateInitialPosition 初始数量和成本不能为负数，只有成本没有数量时无法计算平均成本
+func validateInitialPosition(quantity, costUSD float64) *FieldError {
+	if quantity < 0 {
+		return &FieldError{Field: "initialQuantity", Message: "initialQuantity不能为负数"}
+	}
+	if costUSD < 0 {
+		return &FieldError{Field: "initialCostUSD", Message: "initialCostUSD不能为负数"}
+	}
+	if quantity == 0 && costUSD > 0 {
+		return &FieldError{Field: "initialQuantity", Message: "指定initialCostUSD时initialQuantity必须大于0"}
+	}
+	return nil
+}
+
+// validatePriceProvider 校验价格提供方在允许列表中，fixed需要同时指定大于0的fixedPrice
+func (h *PnLHandler) validatePriceProvider(provider string, fixedPrice float64) *FieldError {
+	if provider == "" {
+		return nil
+	}
+	allowed := false
+	for _, p := range h.AllowedPriceProviders {
+		allowed = allowed || p == provider
+	}
+	if !allowed {
+		return &FieldError{Field: "priceProvider", Message: "priceProvider只支持" + strings.Join(h.AllowedPriceProviders, "、")}
+	}
+	if provider == services.PriceProviderFixed && fixedPrice <= 0 {
+		return &FieldError{Field: "fixedPrice", Message: "priceProvider为fixed时fixedPrice必须大于0"}
+	}
+	return nil
+}
+
+// decodeErrorDetails 将JSON解码错误转换为字段级错误
+func decodeErrorDetails(err error) []FieldError {
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &typeErr):
+		return []FieldError{{
+			Field:   typeErr.Field,
+			Message: fmt.Sprintf("%s类型错误，期望%s", typeErr.Field, typeErr.Type.String()),
+		}}
+	case errors.As(err, &syntaxErr):
+		return []FieldError{{Field: "body", Message: fmt.Sprintf("JSON语法错误（位置%d）", syntaxErr.Offset)}}
+	case errors.Is(err, io.EOF):
+		return []FieldError{{Field: "body", Message: "请求体不能为空"}}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return []FieldError{{Field: field, Message: "不支持的字段" + field}}
+	default:
+		return []FieldError{{Field: "body", Message: err.Error()}}
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zhinan22/DPLabsDemo/services"
+)
+
+// 响应格式
+const (
+	FormatJSON  = "json"
+	FormatTable = "table"
+	FormatCSV   = "csv" // 逐笔交易明细，见/pnl/export
+)
+
+// writePnLTable 以等宽文本表格输出PnL结果，每个头寸一行，最后附汇总行，便于curl直接查看
+func writePnLTable(c *gin.Context, mint string, response PnLResponse) {
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	fmt.Fprintf(c.Writer, "代币: %s\n", mint)
+	if response.Truncated {
+		fmt.Fprintln(c.Writer, "注意: 签名查询超出耗时预算，结果仅基于部分交易")
+	}
+
+	w := tabwriter.NewWriter(c.Writer, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "#\tstatus\taverageCost\tinvestment\trealized\tunrealized\tpnl%\topenedAt\tholding\t")
+
+	var realized, unrealized float64
+	for i, r := range response.Results {
+		status := "open"
+		if r.IsClosed {
+			status = "closed"
+		}
+		realized += r.ProfitLossValue
+		unrealized += r.UnrealizedProfitLossValue
+		fmt.Fprintf(w, "%d\t%s\t%.6f\t%.2f\t%.2f\t%.2f\t%s\t%s\t%s\t\n",
+			i+1,
+			status,
+			r.AverageCost,
+			r.TotalInvestment,
+			r.ProfitLossValue,
+			r.UnrealizedProfitLossValue,
+			r.ProfitLossPercentage,
+			r.OpenedAt.UTC().Format("2006-01-02 15:04"),
+			time.Duration(r.HoldingSeconds)*time.Second,
+		)
+	}
+	_ = w.Flush()
+
+	unit := "USD"
+	if response.Denom == services.DenominationSOL {
+		unit = "SOL"
+	}
+	fmt.Fprintf(c.Writer, "共%d个头寸，已实现盈亏: %.2f %s，未实现盈亏: %.2f %s\n",
+		len(response.Results), realized, unit, unrealized, unit)
+	for _, warning := range response.Warnings {
+		fmt.Fprintf(c.Writer, "警告: %s\n", warning)
+	}
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zhinan22/DPLabsDemo/services"
+)
+
+// TaxLotsResponse 批次级已实现盈亏响应
+type TaxLotsResponse struct {
+	Lots  []services.RealizedLotEvent `json:"lots"`
+	Error string                      `json:"error,omitempty"`
+}
+
+// GetTaxLots 返回FIFO匹配后的批次级已实现盈亏，format=csv时导出CSV
+// 接受与GET /pnl相同的计算参数，method只能为fifo；longTermDays指定长期持有阈值（天），默认使用配置值
+func (h *PnLHandler) GetTaxLots(c *gin.Context) {
+	req, ok := h.parsePnLQuery(c)
+	if !ok {
+		return
+	}
+	if len(req.TokenMints) > 0 {
+		c.JSON(http.StatusBadRequest, TaxLotsResponse{Error: "批次盈亏只支持单个tokenMint"})
+		return
+	}
+	if req.Method != "" && services.CostBasisMethod(req.Method) != services.CostBasisFIFO {
+		c.JSON(http.StatusBadRequest, TaxLotsResponse{Error: "批次盈亏只支持method=fifo"})
+		return
+	}
+	if format := c.Query("format"); format != "" && format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, TaxLotsResponse{Error: "批次盈亏只支持format=json或csv"})
+		return
+	}
+
+	threshold := h.LongTermThreshold
+	if daysStr := c.Query("longTermDays"); daysStr != "" {
+		days, err := strconv.Atoi(daysStr)
+		if err != nil || days <= 0 {
+			c.JSON(http.StatusBadRequest, TaxLotsResponse{Error: "longTermDays必须是正整数"})
+			return
+		}
+		threshold = time.Duration(days) * 24 * time.Hour
+	}
+
+	transactions, _, err := h.PnlService.GetTransactionsInRange(c.Request.Context(), req.UserAddress, req.Limit, req.timeRange())
+	if err != nil {
+		c.JSON(fetchErrorStatus(err), TaxLotsResponse{Error: "获取交易记录失败: " + err.Error()})
+		return
+	}
+	transactions = services.ExcludeSignatures(transactions, req.ExcludeSignatures)
+
+	lots, err := h.PnlService.CalculateRealizedLots(c.Request.Context(), transactions, req.UserAddress, req.TokenMint, req.pnlOptions(), threshold)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, TaxLotsResponse{Error: "计算批次盈亏失败: " + err.Error()})
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		writeTaxLotsCSV(c, req.TokenMint, lots)
+		return
+	}
+	c.JSON(http.StatusOK, TaxLotsResponse{Lots: lots})
+}
+
+// writeTaxLotsCSV 以CSV格式输出批次级已实现盈亏
+func writeTaxLotsCSV(c *gin.Context, mint string, lots []services.RealizedLotEvent) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="lots_%s.csv"`, mint))
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write([]string{
+		"acquisitionSignature", "disposalSignature", "acquiredAt", "disposedAt",
+		"quantity", "proceeds", "costBasis", "gainLoss", "holdingPeriod",
+	})
+	for _, lot := range lots {
+		_ = w.Write([]string{
+			lot.AcquisitionSignature,
+			lot.DisposalSignature,
+			lot.AcquiredAt.UTC().Format(time.RFC3339),
+			lot.DisposedAt.UTC().Format(time.RFC3339),
+			strconv.FormatFloat(lot.Quantity, 'f', -1, 64),
+			strconv.FormatFloat(lot.Proceeds, 'f', 2, 64),
+			strconv.FormatFloat(lot.CostBasis, 'f', 2, 64),
+			strconv.FormatFloat(lot.GainLoss, 'f', 2, 64),
+			lot.HoldingPeriod,
+		})
+	}
+	w.Flush()
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gin-gonic/gin"
+	"github.com/zhinan22/DPLabsDemo/services"
+)
+
+// WalletPnLResponse 钱包级PnL汇总响应
+type WalletPnLResponse struct {
+	Wallet    *services.WalletPnL `json:"wallet,omitempty"`
+	Truncated bool                `json:"truncated,omitempty"` // 签名查询超出耗时预算，结果仅基于部分交易
+	Error     string              `json:"error,omitempty"`
+}
+
+// GetWalletPnL 汇总钱包交易过的所有代币的已实现和未实现盈亏
+func (h *PnLHandler) GetWalletPnL(c *gin.Context) {
+	userAddress := c.Query("userAddress")
+	if userAddress == "" {
+		c.JSON(http.StatusBadRequest, WalletPnLResponse{Error: "缺少必要参数: userAddress"})
+		return
+	}
+	if _, err := solana.PublicKeyFromBase58(userAddress); err != nil {
+		c.JSON(http.StatusBadRequest, WalletPnLResponse{Error: "userAddress不是合法的base58地址"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(h.DefaultLimit)))
+	if err != nil || limit < 1 || limit > MaxLimit {
+		c.JSON(http.StatusBadRequest, WalletPnLResponse{Error: "limit必须在1到" + strconv.Itoa(MaxLimit) + "之间"})
+		return
+	}
+
+	transactions, truncated, err := h.PnlService.GetTransactions(c.Request.Context(), userAddress, limit)
+	if err != nil {
+		c.JSON(fetchErrorStatus(err), WalletPnLResponse{Error: "获取交易记录失败: " + err.Error()})
+		return
+	}
+	transactions = services.ExcludeSignatures(transactions, queryList(c, "excludeSignatures"))
+
+	wallet := h.PnlService.CalculateWalletPnL(c.Request.Context(), transactions, userAddress, services.PnLOptions{
+		SkipUnpriceable: c.Query("skipUnpriceable") == "true",
+	})
+	c.JSON(http.StatusOK, WalletPnLResponse{Wallet: wallet, Truncated: truncated})
+}
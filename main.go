@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
 	"github.com/zhinan22/DPLabsDemo/config"
 	"github.com/zhinan22/DPLabsDemo/handlers"
 	"github.com/zhinan22/DPLabsDemo/services"
 	"log"
-	_ "os"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
@@ -24,18 +31,114 @@ func main() {
 		log.Fatalf("加载配置失败: %v", err)
 	}
 
+	// 日志以JSON输出到标准输出，log包的输出也经过同一个handler
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		log.Printf("LOG_LEVEL无效，使用info: %v", err)
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
+	slog.SetDefault(logger)
+
+	// 配置了Redis时交易缓存写入Redis，重启和多副本间共享
+	var txCache services.Cache
+	if cfg.RedisURL != "" {
+		redisOpts, err := redis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			log.Fatalf("解析REDIS_URL失败: %v", err)
+		}
+		txCache = services.NewRedisCache(redis.NewClient(redisOpts), cfg.RedisCacheTTL)
+	}
+
 	// 初始化Solana服务
-	solanaService, _ := services.NewPnlService(cfg.SolanaRPCUrl, cfg.JupiterProgramID, cfg.OKXClient)
+	solanaService, _ := services.NewPnlService(cfg.SolanaRPCUrl, cfg.JupiterProgramID, cfg.OKXClient,
+		services.WithZeroChangeEpsilon(cfg.ZeroChangeEpsilon),
+		services.WithSignatureFetchBudget(cfg.SignatureFetchBudget),
+		services.WithStablecoins(cfg.StablecoinMints),
+		services.WithDecimalsCacheTTL(cfg.DecimalsCacheTTL),
+		services.WithPriceCacheTTL(cfg.PriceCacheTTL),
+		services.WithMaxInstructionDepth(cfg.MaxInstructionDepth),
+		services.WithTransactionFetchOptions(cfg.TransactionFetch),
+		services.WithConfirmedCacheTTL(cfg.ConfirmedCacheTTL),
+		services.WithTransactionCache(txCache),
+		services.WithTransactionCacheSize(cfg.TransactionCacheSize),
+		services.WithProbeBuyFraction(cfg.ProbeBuyFraction),
+		services.WithSOLReconciliation(cfg.SOLReconcileTolerance),
+		services.WithRequestCostCap(cfg.MaxRequestRPCCalls, cfg.MaxRequestOKXCalls),
+		services.WithRPCConcurrency(cfg.RPCConcurrency),
+		services.WithRPCRetry(cfg.RPCMaxAttempts, cfg.RPCRetryBaseDelay),
+		services.WithBatchAPI(cfg.RPCBatchAPI),
+		services.WithLogger(logger),
+		services.WithMintDiscovery(cfg.MintDiscoveryConcurrency, cfg.MintDiscoveryBatchSize),
+		services.WithJupiterPriceURL(cfg.JupiterPriceURL),
+		services.WithJupiterDiscriminators(cfg.JupiterDiscriminators),
+	)
+
+	// 启动当前价格后台刷新（默认不启用）
+	solanaService.StartPriceRefresher(context.Background(), cfg.PriceWatchlist, cfg.PriceRefreshInterval)
+
+	// 加载代币列表，用于解析代币symbol和精度
+	solanaService.StartTokenListLoader(context.Background(), cfg.TokenListURL, cfg.TokenListRefreshInterval)
 
 	// 初始化处理器
-	handler := handlers.NewPnLHandler(solanaService)
+	handler := handlers.NewPnLHandler(solanaService, cfg.TransactionLimit)
+	handler.Logger = logger
+	if len(cfg.AllowedPriceProviders) > 0 {
+		handler.AllowedPriceProviders = cfg.AllowedPriceProviders
+	}
+	if cfg.LongTermHoldingDays > 0 {
+		handler.LongTermThreshold = time.Duration(cfg.LongTermHoldingDays) * 24 * time.Hour
+	}
+	handler.RequestTimeout = cfg.PnLRequestTimeout
 
 	//	curl "http://localhost:8080/pnl?userAddress=8deJ9xeUvXSJwicYptA9mHsU2rN2pDx37KWzkDkEXhU6&tokenMint=2dMHTBnkSPRNqasqwpPfK4wwPxNdgmb1LhrbJ8vGjupsv&limit=200"
 	// 设置Gin路由
 	r := gin.Default()
-	r.GET("/pnl", handler.GetPnL)
+	r.Use(handlers.RequestID())
+	// Prometheus指标：PnL请求耗时、RPC请求数、交易缓存命中率和OKX错误数
+	prometheus.MustRegister(services.MetricsCollectors()...)
+	prometheus.MustRegister(handlers.MetricsCollectors()...)
+	r.GET("/metrics", handlers.Metrics(prometheus.DefaultGatherer))
+	// 存活和就绪检查，供负载均衡和Kubernetes探针使用
+	r.GET("/health", handler.Health)
+	r.GET("/ready", handler.Ready)
+	// PnL计算会大量调用RPC和OKX，限制同时进行的请求数量
+	pnl := r.Group("", handlers.RequestDuration(), handlers.LimitConcurrency(cfg.MaxConcurrentPnL, 1))
+	pnl.GET("/pnl", handler.GetPnL)
+	pnl.POST("/pnl", handler.PostPnL)
+	pnl.GET("/pnl/lots", handler.GetTaxLots)
+	pnl.GET("/pnl/export", handler.ExportTrades)
+	pnl.GET("/pnl/wallet", handler.GetWalletPnL)
+	pnl.GET("/tx/:signature/orders", handler.GetTxOrders)
+
+	// 离线解析调用方提供的交易数据，不访问RPC
+	r.POST("/parse", handler.ParseTransaction)
+
+	// 管理接口需要Bearer token或HMAC签名
+	admin := r.Group("/admin", handlers.AdminAuth(cfg.AdminToken, cfg.AdminHMACSecret))
+	admin.POST("/cache/clear", handler.ClearCache)
+	admin.GET("/okx/candles", handler.GetOKXCandles)
+
+	// 启动pprof管理端口（默认不启用，与业务端口分离）
+	if cfg.PprofAddr != "" {
+		go startPprofServer(cfg.PprofAddr)
+	}
 
 	// 启动服务器
 	log.Printf("服务器启动在端口 %s", cfg.ServerPort)
 	log.Fatal(r.Run(":" + cfg.ServerPort))
 }
+
+// startPprofServer 在独立端口上注册net/http/pprof，避免profile接口随业务端口对外暴露
+func startPprofServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	log.Printf("pprof启动在 %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("pprof服务退出: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// 年化收益标记
+const (
+	AnnualizedFlagTooShort = "holdingTooShort" // 持有时间过短，年化没有意义
+	AnnualizedFlagCapped   = "capped"          // 年化结果过大，已截断到上限
+)
+
+const (
+	// MinAnnualizeHolding 低于该持有时长不计算年化（分钟级持有的年化会被放大到无意义的数量级）
+	MinAnnualizeHolding = time.Minute
+	// MaxAnnualizedPercentage 年化收益率上限（%）
+	MaxAnnualizedPercentage = 1000000.0
+	yearDuration            = 365 * 24 * time.Hour
+)
+
+// AnnualizedReturn 年化收益
+type AnnualizedReturn struct {
+	Percentage string `json:"percentage,omitempty"` // 年化收益率，如"12.34%"；持有时间过短时为空
+	Flag       string `json:"flag,omitempty"`       // 特殊情况标记
+}
+
+// AnnualizeReturn 将持有期收益（pnl/investment）按复利折算为年化收益率
+func AnnualizeReturn(pnl, investment float64, holding time.Duration) *AnnualizedReturn {
+	if investment <= 0 {
+		return nil
+	}
+	if holding < MinAnnualizeHolding {
+		return &AnnualizedReturn{Flag: AnnualizedFlagTooShort}
+	}
+
+	periodReturn := pnl / investment
+	annualized := (math.Pow(1+periodReturn, float64(yearDuration)/float64(holding)) - 1) * 100
+	if periodReturn <= -1 {
+		// 本金全部亏损
+		annualized = -100
+	}
+
+	result := &AnnualizedReturn{}
+	if math.IsInf(annualized, 0) || math.IsNaN(annualized) || annualized > MaxAnnualizedPercentage {
+		annualized = MaxAnnualizedPercentage
+		result.Flag = AnnualizedFlagCapped
+	}
+	result.Percentage = fmt.Sprintf("%.2f%%", annualized)
+	return result
+}
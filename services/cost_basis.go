@@ -0,0 +1,90 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// CostBasisMethod 卖出时确定成本的方法
+type CostBasisMethod string
+
+// 成本计算方法
+const (
+	CostBasisAverage CostBasisMethod = "average" // 加权平均成本（默认）
+	CostBasisFIFO    CostBasisMethod = "fifo"    // 先进先出，卖出依次匹配最早的剩余买入批次
+)
+
+// ValidCostBasisMethod 成本计算方法为空或为支持的取值
+func ValidCostBasisMethod(method CostBasisMethod) bool {
+	return method == "" || method == CostBasisAverage || method == CostBasisFIFO
+}
+
+// WithCostBasisMethod 设置请求未指定时使用的成本计算方法，为空时使用CostBasisAverage
+func WithCostBasisMethod(method CostBasisMethod) Option {
+	return func(s *PnlService) {
+		if method != "" {
+			s.costBasis = method
+		}
+	}
+}
+
+// costBasisMethodFor 按opts选择本次计算的成本计算方法，未指定时使用服务默认值
+func (s *PnlService) costBasisMethodFor(opts PnLOptions) (CostBasisMethod, error) {
+	if !ValidCostBasisMethod(opts.CostBasisMethod) {
+		return "", fmt.Errorf("不支持的成本计算方法: %s", opts.CostBasisMethod)
+	}
+	if opts.CostBasisMethod == "" {
+		return s.costBasis, nil
+	}
+	return opts.CostBasisMethod, nil
+}
+
+// applySellFIFO 卖出按先进先出消耗买入批次，已实现盈亏 = 卖出收入 - 被消耗批次的成本
+// 每个被消耗的批次记录一条RealizedLotEvent；超出剩余批次数量的部分没有对应买入，成本按0计
+func (p *Position) applySellFIFO(order Order, amount, usdValue decimal.Decimal) {
+	var cost, allocated decimal.Decimal
+	remaining := amount
+	for remaining.IsPositive() && len(p.lots) > 0 {
+		lot := p.lots[0]
+		quantity := decimal.Min(lot.Quantity, remaining)
+
+		// 部分消耗的批次按数量比例分摊成本，先乘后除；消耗整个批次时取剩余成本，不产生舍入
+		lotCost := lot.CostUSD
+		if quantity.LessThan(lot.Quantity) {
+			lotCost = lot.CostUSD.Mul(quantity).Div(lot.Quantity)
+		}
+		// 卖出收入按数量比例分摊，最后一个批次取剩余收入，使各批次收入之和等于卖出收入
+		proceeds := usdValue.Mul(quantity).Div(amount)
+		if quantity.Equal(remaining) {
+			proceeds = usdValue.Sub(allocated)
+		}
+
+		p.realizedLots = append(p.realizedLots, RealizedLotEvent{
+			AcquisitionSignature: lot.Signature,
+			DisposalSignature:    order.Signature,
+			AcquiredAt:           lot.AcquiredAt,
+			DisposedAt:           order.BlockTime,
+			Quantity:             quantity.InexactFloat64(),
+			Proceeds:             proceeds.InexactFloat64(),
+			CostBasis:            lotCost.InexactFloat64(),
+			GainLoss:             proceeds.Sub(lotCost).InexactFloat64(),
+		})
+
+		cost = cost.Add(lotCost)
+		allocated = allocated.Add(proceeds)
+		remaining = remaining.Sub(quantity)
+		lot.Quantity = lot.Quantity.Sub(quantity)
+		lot.CostUSD = lot.CostUSD.Sub(lotCost)
+		if !lot.Quantity.IsPositive() {
+			p.lots = p.lots[1:]
+		}
+	}
+
+	realized := usdValue.Sub(cost)
+	p.RealizedPnL = p.RealizedPnL.Add(realized)
+	p.TotalAmount = p.TotalAmount.Sub(amount)
+	p.TotalCostUSD = p.TotalCostUSD.Sub(cost)
+	p.Transactions = append(p.Transactions, order)
+	p.legs = append(p.legs, positionLeg{order: order, amount: amount, usdValue: usdValue, realized: realized})
+}
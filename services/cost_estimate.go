@@ -0,0 +1,55 @@
+package services
+
+import "fmt"
+
+// CostEstimate 单次请求预计的外部调用次数（上限估计，未考虑缓存命中）
+type CostEstimate struct {
+	SignaturePages   int `json:"signaturePages"`   // getSignaturesForAddress分页次数
+	TransactionCalls int `json:"transactionCalls"` // getTransaction调用次数
+	RPCCalls         int `json:"rpcCalls"`         // RPC调用合计
+	OKXCalls         int `json:"okxCalls"`         // OKX价格查询次数：每笔交易一次历史价格和一次手续费的SOL价格，加一次当前价格
+}
+
+// CostCapExceededError 请求预计成本超过配置的上限，在发起任何外部调用前返回
+type CostCapExceededError struct {
+	Estimate    CostEstimate
+	MaxRPCCalls int
+	MaxOKXCalls int
+}
+
+func (e *CostCapExceededError) Error() string {
+	return fmt.Sprintf("请求预计需要%d次RPC调用、%d次OKX调用，超过上限（RPC %d，OKX %d），请减小limit",
+		e.Estimate.RPCCalls, e.Estimate.OKXCalls, e.MaxRPCCalls, e.MaxOKXCalls)
+}
+
+// WithRequestCostCap 设置单次请求预计调用次数上限，超过时直接拒绝，<=0表示不限制
+func WithRequestCostCap(maxRPCCalls, maxOKXCalls int) Option {
+	return func(s *PnlService) {
+		s.maxRPCCalls = maxRPCCalls
+		s.maxOKXCalls = maxOKXCalls
+	}
+}
+
+// EstimateCost 估算获取并计算limit笔交易所需的RPC和OKX调用次数
+func (s *PnlService) EstimateCost(limit int) CostEstimate {
+	if limit <= 0 {
+		return CostEstimate{}
+	}
+	pages := (limit + s.batchSize - 1) / s.batchSize
+	return CostEstimate{
+		SignaturePages:   pages,
+		TransactionCalls: limit,
+		RPCCalls:         pages + limit,
+		OKXCalls:         2*limit + 1,
+	}
+}
+
+// checkCostCap 预计成本超过上限时返回CostCapExceededError
+func (s *PnlService) checkCostCap(limit int) error {
+	estimate := s.EstimateCost(limit)
+	if (s.maxRPCCalls > 0 && estimate.RPCCalls > s.maxRPCCalls) ||
+		(s.maxOKXCalls > 0 && estimate.OKXCalls > s.maxOKXCalls) {
+		return &CostCapExceededError{Estimate: estimate, MaxRPCCalls: s.maxRPCCalls, MaxOKXCalls: s.maxOKXCalls}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/shopspring/decimal"
+)
+
+// 盈亏计价单位
+const (
+	DenominationUSD = "usd" // 美元（默认）
+	DenominationSOL = "sol" // SOL：成本和已实现盈亏按交易时的SOL价格换算，未实现盈亏按当前SOL价格换算
+)
+
+// ValidDenomination 计价单位为空或为支持的取值
+func ValidDenomination(denom string) bool {
+	return denom == "" || denom == DenominationUSD || denom == DenominationSOL
+}
+
+// denominate 将at时的美元价值换算为opts的计价单位，以USD计价时原样返回
+func denominate(ctx context.Context, prices PriceProvider, opts PnLOptions, usdValue decimal.Decimal, at time.Time) (decimal.Decimal, error) {
+	if opts.Denomination != DenominationSOL {
+		return usdValue, nil
+	}
+	sol, err := prices.HistoricalPrice(ctx, solana.SolMint.String(), at)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if sol.Price <= 0 {
+		return decimal.Zero, fmt.Errorf("SOL价格无效: %v", sol.Price)
+	}
+	return usdValue.Div(decimal.NewFromFloat(sol.Price)), nil
+}
+
+// currentDenominator 当前一个计价单位的美元价格，用于换算未实现盈亏；以USD计价时为1
+func currentDenominator(ctx context.Context, prices PriceProvider, opts PnLOptions) (float64, error) {
+	if opts.Denomination != DenominationSOL {
+		return 1, nil
+	}
+	sol, err := prices.CurrentPrice(ctx, solana.SolMint.String())
+	if err != nil {
+		return 0, err
+	}
+	if sol.Price <= 0 {
+		return 0, fmt.Errorf("SOL价格无效: %v", sol.Price)
+	}
+	return sol.Price, nil
+}
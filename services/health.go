@@ -0,0 +1,23 @@
+package services
+
+import (
+	"context"
+	"fmt"
+)
+
+// CheckRPC 调用getHealth检查Solana RPC节点是否可用
+func (s *PnlService) CheckRPC(ctx context.Context) error {
+	status, err := s.rpcClient.GetHealth(ctx)
+	if err != nil {
+		return fmt.Errorf("RPC节点不可用: %w", err)
+	}
+	if status != "ok" {
+		return fmt.Errorf("RPC节点状态异常: %s", status)
+	}
+	return nil
+}
+
+// CheckOKX 检查OKX行情接口是否可达
+func (s *PnlService) CheckOKX(ctx context.Context) error {
+	return s.okxMarketClient.Ping(ctx)
+}
@@ -1,11 +1,11 @@
 package services
 
 import (
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/gagliardetto/solana-go"
 	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/shopspring/decimal"
 	"math/big"
 	"sort"
 	"strconv"
@@ -182,15 +182,51 @@ func findParentByStackHeight(startNode *StackInstructionNode, targetHeight uint6
 	return findChildByStackHeight(startNode, targetHeight)
 }
 
-// 辅助函数：从某个节点的子树中查找栈高度为targetHeight的节点
+// 辅助函数：从某个节点的子树中查找栈高度为targetHeight的节点（前序遍历，使用显式栈避免深层递归）
 func findChildByStackHeight(node *StackInstructionNode, targetHeight uint64) *StackInstructionNode {
-	if node.StackHeight == targetHeight {
-		return node
+	var found *StackInstructionNode
+	_ = walkInstructionTree(node, 0, func(n *StackInstructionNode, _ int) bool {
+		if n.StackHeight == targetHeight {
+			found = n
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// ErrInstructionTreeTooDeep 指令树深度超过限制
+var ErrInstructionTreeTooDeep = errors.New("指令树深度超过限制")
+
+// DefaultMaxInstructionDepth 默认的指令树最大深度
+const DefaultMaxInstructionDepth = 64
+
+// walkInstructionTree 使用显式栈前序遍历指令树，visit返回false时提前结束
+// maxDepth>0时遍历到深度超过maxDepth的节点返回ErrInstructionTreeTooDeep（根节点深度为0）
+func walkInstructionTree(root *StackInstructionNode, maxDepth int, visit func(node *StackInstructionNode, depth int) bool) error {
+	if root == nil {
+		return nil
+	}
+
+	type frame struct {
+		node  *StackInstructionNode
+		depth int
 	}
-	for _, child := range node.Children {
-		found := findChildByStackHeight(child, targetHeight)
-		if found != nil {
-			return found
+	stack := []frame{{node: root}}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if maxDepth > 0 && current.depth > maxDepth {
+			return fmt.Errorf("%w: 深度%d超过%d", ErrInstructionTreeTooDeep, current.depth, maxDepth)
+		}
+		if !visit(current.node, current.depth) {
+			return nil
+		}
+
+		// 子节点逆序入栈，保证按原顺序访问
+		for i := len(current.node.Children) - 1; i >= 0; i-- {
+			stack = append(stack, frame{node: current.node.Children[i], depth: current.depth + 1})
 		}
 	}
 	return nil
@@ -198,62 +234,76 @@ func findChildByStackHeight(node *StackInstructionNode, targetHeight uint64) *St
 
 // PrintStackInstructionTree 打印基于栈高度的指令树
 func PrintStackInstructionTree(node *StackInstructionNode, indent int) {
-	if node == nil {
-		return
-	}
+	_ = walkInstructionTree(node, 0, func(n *StackInstructionNode, depth int) bool {
+		indentStr := strings.Repeat("  ", indent+depth)
 
-	indentStr := ""
-	for i := 0; i < indent; i++ {
-		indentStr += "  "
-	}
+		programID := n.ProgramIDIndex
+		if n.Index == -1 {
+			programID = 0
+		}
 
-	programID := node.ProgramIDIndex
-	if node.Index == -1 {
-		programID = 0
-	}
+		fmt.Printf("%s指令#%d (栈高度: %d) - 程序: %d\n", indentStr, n.Index, n.StackHeight, programID)
+		fmt.Printf("%s  账户数: %d, 数据长度: %d字节\n", indentStr, len(n.Accounts), len(n.Data))
+		return true
+	})
+}
 
-	fmt.Printf("%s指令#%d (栈高度: %d) - 程序: %d\n", indentStr, node.Index, node.StackHeight, programID)
-	fmt.Printf("%s  账户数: %d, 数据长度: %d字节\n", indentStr, len(node.Accounts), len(node.Data))
+// FindNodesByProgramID 从指令树中查找所有匹配指定Program ID的节点
+func FindNodesByProgramID(fullAccountKeys []solana.PublicKey, root *StackInstructionNode, targetProgramID solana.PublicKey) ([]*StackInstructionNode, []*StackInstructionNode) {
+	route, event, _ := FindNodesByProgramIDWithMaxDepth(fullAccountKeys, root, targetProgramID, 0)
+	return route, event
+}
 
-	// 递归打印子节点
-	for _, child := range node.Children {
-		PrintStackInstructionTree(child, indent+1)
+// FindNodesByProgramIDWithMaxDepth 同FindNodesByProgramID，但指令树深度超过maxDepth时返回ErrInstructionTreeTooDeep
+// maxDepth<=0表示不限制深度
+func FindNodesByProgramIDWithMaxDepth(fullAccountKeys []solana.PublicKey, root *StackInstructionNode, targetProgramID solana.PublicKey, maxDepth int) ([]*StackInstructionNode, []*StackInstructionNode, error) {
+	return FindJupiterNodes(fullAccountKeys, root, targetProgramID, maxDepth, DefaultJupiterDiscriminators())
+}
+
+// FindJupiterNodes 同FindNodesByProgramIDWithMaxDepth，按discs匹配route指令和swap事件
+func FindJupiterNodes(fullAccountKeys []solana.PublicKey, root *StackInstructionNode, targetProgramID solana.PublicKey, maxDepth int, discs JupiterDiscriminators) ([]*StackInstructionNode, []*StackInstructionNode, error) {
+	return findJupiterNodes(fullAccountKeys, root, targetProgramID, maxDepth, newDiscriminatorSet(discs))
+}
+
+func findJupiterNodes(fullAccountKeys []solana.PublicKey, root *StackInstructionNode, targetProgramID solana.PublicKey, maxDepth int, discs discriminatorSet) ([]*StackInstructionNode, []*StackInstructionNode, error) {
+	nodes, err := FindDEXNodes(fullAccountKeys, root, jupiterMatchers(targetProgramID, discs), maxDepth)
+	if err != nil {
+		return nil, nil, err
 	}
+	return nodes[0], nodes[1], nil
 }
 
-// FindNodesByProgramID 从指令树中查找所有匹配指定Program ID的节点
-func FindNodesByProgramID(fullAccountKeys []solana.PublicKey, root *StackInstructionNode, targetProgramID solana.PublicKey) ([]*StackInstructionNode, []*StackInstructionNode) {
-	var route []*StackInstructionNode
-	var event []*StackInstructionNode
+// DEXMatcher 按程序ID和指令数据识别某个DEX的指令
+type DEXMatcher struct {
+	ProgramID solana.PublicKey
+	Match     func(data []byte) bool // 为nil时该程序的所有指令都匹配
+}
 
+// FindDEXNodes 一次遍历指令树查找各matcher匹配的节点，返回结果与matchers一一对应
+// maxDepth<=0表示不限制深度，超过时返回ErrInstructionTreeTooDeep
+func FindDEXNodes(fullAccountKeys []solana.PublicKey, root *StackInstructionNode, matchers []DEXMatcher, maxDepth int) ([][]*StackInstructionNode, error) {
+	nodes := make([][]*StackInstructionNode, len(matchers))
 	if root == nil {
-		return route, event
+		return nodes, nil
 	}
 
-	// 递归遍历指令树
-	var traverse func(node *StackInstructionNode)
-	traverse = func(node *StackInstructionNode) {
-		// 检查当前节点是否匹配目标Program ID
+	err := walkInstructionTree(root, maxDepth, func(node *StackInstructionNode, _ int) bool {
+		// 虚拟根节点没有程序
+		if node.Index == -1 || int(node.ProgramIDIndex) >= len(fullAccountKeys) {
+			return true
+		}
 		nodeProgram := fullAccountKeys[node.ProgramIDIndex]
-		if nodeProgram.Equals(targetProgramID) {
-			activeTag := hex.EncodeToString(node.Data[0:8])
-			activeTag2 := hex.EncodeToString(node.Data[8:16])
-
-			if activeTag == "e517cb977ae3ad2a" { //获得route指令
-				route = append(route, node)
-			}
-			if activeTag == "e445a52e51cb9a1d" && activeTag2 == "40c6cde8260871e2" { //获取jupitor事件
-				event = append(event, node)
+		for i, m := range matchers {
+			if nodeProgram.Equals(m.ProgramID) && (m.Match == nil || m.Match(node.Data)) {
+				nodes[i] = append(nodes[i], node)
 			}
 		}
-		// 递归处理子节点
-		for _, child := range node.Children {
-			traverse(child)
-		}
+		return true
+	})
+	if err != nil {
+		return nil, err
 	}
-
-	traverse(root)
-	return route, event
+	return nodes, nil
 }
 
 func GetFullAccountKeys(tx *rpc.GetTransactionResult) ([]solana.PublicKey, error) {
@@ -263,8 +313,8 @@ func GetFullAccountKeys(tx *rpc.GetTransactionResult) ([]solana.PublicKey, error
 	}
 	var fullAccountKeys []solana.PublicKey
 	fullAccountKeys = append(fullAccountKeys, transaction.Message.AccountKeys...)
-	// 支持处理v0类型的交易，修改transaction中的AccountKeys
-	if transaction.Message.GetVersion() == solana.MessageVersionLegacy {
+	// v0交易通过地址查找表加载的账户按先writable后readonly的顺序追加在静态账户之后，legacy交易没有
+	if transaction.Message.GetVersion() != solana.MessageVersionLegacy && tx.Meta != nil {
 		if len(tx.Meta.LoadedAddresses.Writable) > 0 {
 			fullAccountKeys = append(fullAccountKeys,
 				tx.Meta.LoadedAddresses.Writable...)
@@ -279,10 +329,10 @@ func GetFullAccountKeys(tx *rpc.GetTransactionResult) ([]solana.PublicKey, error
 }
 
 type TokenChange struct {
-	Amount         string  // 原始数量变化（字符串，支持大数字）
-	Decimals       uint8   // 代币小数位数
-	UiAmountString string  // 格式化后的变化值（考虑小数位数）
-	UiAmount       float64 // 格式化后的变化值（浮点数）
+	Amount         string  `json:"amount"`         // 原始数量变化（字符串，支持大数字），余额增加为正、减少为负
+	Decimals       uint8   `json:"decimals"`       // 代币小数位数
+	UiAmountString string  `json:"uiAmountString"` // 格式化后的变化值（考虑小数位数，带符号）
+	UiAmount       float64 `json:"uiAmount"`       // 格式化后的变化值（浮点数，带符号）
 }
 
 // GetBalanceChanges 解析交易中所有地址的资产余额变化（SOL也作为特殊代币处理）
@@ -310,6 +360,9 @@ func GetBalanceChanges(tx *rpc.GetTransactionResult, accountKeys []solana.Public
 		accountIdx := preTb.AccountIndex
 
 		// 记录代币基本信息
+		if int(accountIdx) >= len(accountKeys) {
+			return nil, nil, fmt.Errorf("代币余额账户索引%d超出账户列表长度%d", accountIdx, len(accountKeys))
+		}
 		pk := accountKeys[accountIdx].String()
 		tokenInfo := &TokenInfo{
 			Owner:    ownerAddr,
@@ -318,18 +371,15 @@ func GetBalanceChanges(tx *rpc.GetTransactionResult, accountKeys []solana.Public
 		}
 		tokenMap[pk] = tokenInfo
 
-		// 记录交易前余额（只保存Amount和Decimals）
-		if _, ok := preTokenBalMap[ownerAddr]; !ok {
-			preTokenBalMap[ownerAddr] = make(map[string]*rpc.UiTokenAmount)
-		}
-		preTokenBalMap[ownerAddr][mintAddr] = &rpc.UiTokenAmount{
-			Amount:   preTb.UiTokenAmount.Amount,
-			Decimals: preTb.UiTokenAmount.Decimals,
-		}
+		// 记录交易前余额（只保存Amount和Decimals），同一所有者的多个代币账户累加
+		addOwnerBalance(preTokenBalMap, ownerAddr, mintAddr, preTb.UiTokenAmount)
 	}
 
 	// 2. 处理交易前的SOL余额（作为特殊资产添加到preTokenBalMap）
 	for i, preBal := range meta.PreBalances {
+		if i >= len(accountKeys) {
+			return nil, nil, fmt.Errorf("SOL余额索引%d超出账户列表长度%d", i, len(accountKeys))
+		}
 		ownerAddr := accountKeys[i].String()
 		assetKey := "SOL" // 用"SOL"作为SOL的资产标识
 
@@ -349,6 +399,9 @@ func GetBalanceChanges(tx *rpc.GetTransactionResult, accountKeys []solana.Public
 		accountIdx := postTb.AccountIndex
 
 		// 记录代币基本信息
+		if int(accountIdx) >= len(accountKeys) {
+			return nil, nil, fmt.Errorf("代币余额账户索引%d超出账户列表长度%d", accountIdx, len(accountKeys))
+		}
 		pk := accountKeys[accountIdx].String()
 		tokenInfo := &TokenInfo{
 			Owner:    ownerAddr,
@@ -361,18 +414,15 @@ func GetBalanceChanges(tx *rpc.GetTransactionResult, accountKeys []solana.Public
 		assetKey := fmt.Sprintf("%s_%s", ownerAddr, mintAddr)
 		accountAssetMap[assetKey] = struct{}{}
 
-		// 记录交易后代币余额（只保存Amount和Decimals）
-		if _, ok := balanceMap[ownerAddr]; !ok {
-			balanceMap[ownerAddr] = make(map[string]*rpc.UiTokenAmount)
-		}
-		balanceMap[ownerAddr][mintAddr] = &rpc.UiTokenAmount{
-			Amount:   postTb.UiTokenAmount.Amount,
-			Decimals: postTb.UiTokenAmount.Decimals,
-		}
+		// 记录交易后代币余额（只保存Amount和Decimals），同一所有者的多个代币账户累加
+		addOwnerBalance(balanceMap, ownerAddr, mintAddr, postTb.UiTokenAmount)
 	}
 
 	// 4. 处理交易后的SOL余额（作为特殊代币"SOL"处理）
 	for i, postBal := range meta.PostBalances {
+		if i >= len(accountKeys) {
+			return nil, nil, fmt.Errorf("SOL余额索引%d超出账户列表长度%d", i, len(accountKeys))
+		}
 		ownerAddr := accountKeys[i].String()
 		assetKey := "SOL"
 
@@ -432,16 +482,17 @@ func GetBalanceChanges(tx *rpc.GetTransactionResult, accountKeys []solana.Public
 			// 计算变化量（原始数量）
 			postAmount, _ := new(big.Int).SetString(postTa.Amount, 10)
 			changeAmount := new(big.Int).Sub(postAmount, preAmount)
-			absoluteChange := new(big.Int).Abs(changeAmount) // 取绝对值
 
-			// 格式化变化值
-			uiAmountString := formatTokenAmount(absoluteChange.String(), decimals)
+			// 格式化变化值，保留正负号：增加为正，减少为负
+			uiAmountString := formatTokenAmount(changeAmount.String(), decimals)
+			uiAmount, _ := decimal.NewFromBigInt(changeAmount, -int32(decimals)).Float64()
 
 			// 记录变化信息（标记是否为SOL）
 			unifiedChangeMap[ownerAddr][assetID] = &TokenChange{
-				Amount:         absoluteChange.String(),
+				Amount:         changeAmount.String(),
 				Decimals:       decimals,
 				UiAmountString: uiAmountString,
+				UiAmount:       uiAmount,
 			}
 		}
 	}
@@ -449,6 +500,24 @@ func GetBalanceChanges(tx *rpc.GetTransactionResult, accountKeys []solana.Public
 	return tokenMap, unifiedChangeMap, nil
 }
 
+// addOwnerBalance 将一个代币账户的余额累加到所有者在该代币上的余额，
+// 余额只按代币账户的所有者归属，同一交易中其他签名者或对手方的账户不会计入
+func addOwnerBalance(balances map[string]map[string]*rpc.UiTokenAmount, owner, mint string, amount *rpc.UiTokenAmount) {
+	if _, ok := balances[owner]; !ok {
+		balances[owner] = make(map[string]*rpc.UiTokenAmount)
+	}
+	existing, ok := balances[owner][mint]
+	if !ok {
+		balances[owner][mint] = &rpc.UiTokenAmount{Amount: amount.Amount, Decimals: amount.Decimals}
+		return
+	}
+	sum, ok1 := new(big.Int).SetString(existing.Amount, 10)
+	add, ok2 := new(big.Int).SetString(amount.Amount, 10)
+	if ok1 && ok2 {
+		existing.Amount = sum.Add(sum, add).String()
+	}
+}
+
 // formatTokenAmount 将原始数量字符串按指定小数位数格式化为可读字符串
 // 例如: formatTokenAmount("123456", 6) -> "0.123456"
 //
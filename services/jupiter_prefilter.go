@@ -0,0 +1,115 @@
+package services
+
+import (
+	"sync"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// DefaultNonJupiterMemoSize 最多记录的不含Jupiter或Raydium指令的交易签名数量
+const DefaultNonJupiterMemoSize = 100000
+
+// signatureMemo 记录已确认不调用Jupiter和Raydium程序的交易签名，之后的请求不再获取这些交易
+// 达到上限时整体清空重新记录，代价只是这些交易再被获取一次
+type signatureMemo struct {
+	mu   sync.RWMutex
+	max  int
+	sigs map[solana.Signature]struct{}
+}
+
+func newSignatureMemo(max int) *signatureMemo {
+	return &signatureMemo{max: max, sigs: make(map[solana.Signature]struct{})}
+}
+
+func (m *signatureMemo) has(sig solana.Signature) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.sigs[sig]
+	return ok
+}
+
+func (m *signatureMemo) add(sig solana.Signature) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.sigs) >= m.max {
+		m.sigs = make(map[solana.Signature]struct{})
+	}
+	m.sigs[sig] = struct{}{}
+}
+
+func (m *signatureMemo) clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sigs = make(map[solana.Signature]struct{})
+}
+
+// skipNonDEXSignatures 去掉之前已确认不调用Jupiter和Raydium的签名，避免重复获取交易详情
+func (s *PnlService) skipNonDEXSignatures(signatures []solana.Signature) []solana.Signature {
+	filtered := make([]solana.Signature, 0, len(signatures))
+	for _, sig := range signatures {
+		if !s.nonJupiter.has(sig) {
+			filtered = append(filtered, sig)
+		}
+	}
+	return filtered
+}
+
+// keepDEXTransactions 只保留调用了Jupiter或Raydium程序的交易，其余交易的签名记入nonJupiter
+func (s *PnlService) keepDEXTransactions(txList []*Transaction) []*Transaction {
+	kept := txList[:0]
+	for _, tx := range txList {
+		if !s.touchesProgram(tx.RawTx, s.jupiterPID, RaydiumAMMProgramID) {
+			if sig, err := solana.SignatureFromBase58(tx.Signature); err == nil {
+				s.nonJupiter.add(sig)
+			}
+			continue
+		}
+		kept = append(kept, tx)
+	}
+	return kept
+}
+
+// touchesProgram 判断交易的指令树中是否有调用programIDs中任一程序的指令（包括内部指令）
+// 账户列表中没有这些程序时不解析指令树；无法解析的交易按调用处理，交给后续解析判断
+func (s *PnlService) touchesProgram(rawTx *rpc.GetTransactionResult, programIDs ...solana.PublicKey) bool {
+	if rawTx == nil || rawTx.Transaction == nil || rawTx.Meta == nil {
+		return true
+	}
+	accountKeys, err := GetFullAccountKeys(rawTx)
+	if err != nil {
+		return true
+	}
+	isTarget := func(key solana.PublicKey) bool {
+		for _, programID := range programIDs {
+			if key.Equals(programID) {
+				return true
+			}
+		}
+		return false
+	}
+	found := false
+	for _, key := range accountKeys {
+		if isTarget(key) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+
+	root, err := ParseInstructionTreeByStackHeight(rawTx)
+	if err != nil {
+		return true
+	}
+	found = false
+	err = walkInstructionTree(root, s.maxTreeDepth, func(node *StackInstructionNode, _ int) bool {
+		if node.Index != -1 && int(node.ProgramIDIndex) < len(accountKeys) && isTarget(accountKeys[node.ProgramIDIndex]) {
+			found = true
+			return false
+		}
+		return true
+	})
+	return found || err != nil
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// Jupiter v6 route类指令的discriminator（sha256("global:<name>")前8字节）
+const (
+	jupiterRouteDisc                              = "e517cb977ae3ad2a"
+	jupiterSharedAccountsRouteDisc                = "c1209b3341d69c81"
+	jupiterExactOutRouteDisc                      = "d033ef977b2bed5c"
+	jupiterSharedAccountsExactOutRouteDisc        = "b0d169a89a7d453e"
+	jupiterRouteWithTokenLedgerDisc               = "96564774a75d0e68"
+	jupiterSharedAccountsRouteWithTokenLedgerDisc = "e6798f50779f6aaa"
+)
+
+// jupiterSwapEventDisc Jupiter SwapEvent的discriminator：anchor事件CPI标记(8字节) + SwapEvent discriminator(8字节)
+const jupiterSwapEventDisc = "e445a52e51cb9a1d40c6cde8260871e2"
+
+// JupiterDiscriminators 识别Jupiter route指令和swap事件的discriminator（hex编码）
+// Jupiter发布新版本程序后可通过WithJupiterDiscriminators追加，无需修改解析逻辑
+type JupiterDiscriminators struct {
+	Route []string // route类指令，8字节（16个hex字符）
+	Event []string // swap事件，16字节（32个hex字符），之后为borsh编码的JupiterSwapEventData
+}
+
+// DefaultJupiterDiscriminators Jupiter v6的route类指令和SwapEvent
+func DefaultJupiterDiscriminators() JupiterDiscriminators {
+	d := JupiterDiscriminators{Event: []string{jupiterSwapEventDisc}}
+	for disc := range jupiterRouteVariants {
+		d.Route = append(d.Route, disc)
+	}
+	return d
+}
+
+// discriminatorSet 用于匹配指令的discriminator集合
+type discriminatorSet struct {
+	route map[string]struct{}
+	event map[string]struct{}
+}
+
+func newDiscriminatorSet(d JupiterDiscriminators) discriminatorSet {
+	set := discriminatorSet{route: make(map[string]struct{}), event: make(map[string]struct{})}
+	set.add(d)
+	return set
+}
+
+// add 加入d中的discriminator，长度不符的忽略
+func (set discriminatorSet) add(d JupiterDiscriminators) {
+	for _, disc := range d.Route {
+		if disc = strings.ToLower(disc); len(disc) == 16 {
+			set.route[disc] = struct{}{}
+		}
+	}
+	for _, disc := range d.Event {
+		if disc = strings.ToLower(disc); len(disc) == 32 {
+			set.event[disc] = struct{}{}
+		}
+	}
+}
+
+// matchRoute 指令数据以route类discriminator开头；无参数的CPI等短指令数据不足discriminator长度，不匹配
+func (set discriminatorSet) matchRoute(data []byte) bool {
+	if len(data) < 8 {
+		return false
+	}
+	_, ok := set.route[hex.EncodeToString(data[:8])]
+	return ok
+}
+
+// matchEvent 指令数据以swap事件discriminator开头
+func (set discriminatorSet) matchEvent(data []byte) bool {
+	if len(data) < 16 {
+		return false
+	}
+	_, ok := set.event[hex.EncodeToString(data[:16])]
+	return ok
+}
+
+// jupiterMatchers Jupiter的route指令和swap事件，分别对应FindDEXNodes结果的第0和第1项
+func jupiterMatchers(programID solana.PublicKey, discs discriminatorSet) []DEXMatcher {
+	return []DEXMatcher{
+		{ProgramID: programID, Match: discs.matchRoute},
+		{ProgramID: programID, Match: discs.matchEvent},
+	}
+}
+
+// WithJupiterDiscriminators 在默认discriminator之外追加route指令和swap事件的discriminator
+// 新增的route指令无法解析报价参数时订单的Quote为空，不影响买卖数量的解析
+func WithJupiterDiscriminators(d JupiterDiscriminators) Option {
+	return func(s *PnlService) {
+		s.jupiterDiscs.add(d)
+	}
+}
+
+// jupiterRouteVariant route指令变体的参数布局
+type jupiterRouteVariant struct {
+	Name        string
+	ExactOut    bool // 参数为out_amount + quoted_in_amount
+	TokenLedger bool // 输入数量来自token ledger，参数中没有in_amount
+}
+
+// jupiterRouteVariants discriminator -> route指令变体
+var jupiterRouteVariants = map[string]jupiterRouteVariant{
+	jupiterRouteDisc:                              {Name: "route"},
+	jupiterSharedAccountsRouteDisc:                {Name: "sharedAccountsRoute"},
+	jupiterExactOutRouteDisc:                      {Name: "exactOutRoute", ExactOut: true},
+	jupiterSharedAccountsExactOutRouteDisc:        {Name: "sharedAccountsExactOutRoute", ExactOut: true},
+	jupiterRouteWithTokenLedgerDisc:               {Name: "routeWithTokenLedger", TokenLedger: true},
+	jupiterSharedAccountsRouteWithTokenLedgerDisc: {Name: "sharedAccountsRouteWithTokenLedger", TokenLedger: true},
+}
+
+// JupiterRouteArgs route指令中的报价参数
+type JupiterRouteArgs struct {
+	Variant         string `json:"variant"`         // 指令变体
+	ExactOut        bool   `json:"exactOut"`        // 是否为exact-out路由
+	InAmount        uint64 `json:"inAmount"`        // exact-in为输入数量，exact-out为报价输入数量；token ledger变体为0
+	QuotedOutAmount uint64 `json:"quotedOutAmount"` // exact-in为报价输出数量，exact-out为指定输出数量
+	SlippageBps     uint16 `json:"slippageBps"`     // 滑点（基点）
+	PlatformFeeBps  uint8  `json:"platformFeeBps"`  // 平台费（基点）
+}
+
+// DecodeJupiterRouteArgs 解析Jupiter route类指令的报价参数
+// route_plan是变长的枚举数组，各变体的in/out数量、滑点和平台费都位于数据末尾，因此从尾部按固定长度解析
+func DecodeJupiterRouteArgs(data []byte) (*JupiterRouteArgs, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("route指令数据长度%d不足8字节", len(data))
+	}
+	variant, ok := jupiterRouteVariants[hex.EncodeToString(data[0:8])]
+	if !ok {
+		return nil, fmt.Errorf("未知的route指令discriminator %s", hex.EncodeToString(data[0:8]))
+	}
+
+	// 尾部布局：[in_amount u64] quoted_out_amount u64 | slippage_bps u16 | platform_fee_bps u8
+	tailLen := 8 + 2 + 1
+	if !variant.TokenLedger {
+		tailLen += 8
+	}
+	if len(data) < 8+tailLen {
+		return nil, fmt.Errorf("%s指令数据长度%d不足", variant.Name, len(data))
+	}
+	tail := data[len(data)-tailLen:]
+
+	args := &JupiterRouteArgs{
+		Variant:  variant.Name,
+		ExactOut: variant.ExactOut,
+	}
+	offset := 0
+	if !variant.TokenLedger {
+		first := binary.LittleEndian.Uint64(tail[0:8])
+		offset = 8
+		if variant.ExactOut {
+			// exact-out参数顺序为out_amount, quoted_in_amount
+			args.QuotedOutAmount = first
+			args.InAmount = binary.LittleEndian.Uint64(tail[offset : offset+8])
+		} else {
+			args.InAmount = first
+			args.QuotedOutAmount = binary.LittleEndian.Uint64(tail[offset : offset+8])
+		}
+	} else {
+		args.QuotedOutAmount = binary.LittleEndian.Uint64(tail[offset : offset+8])
+	}
+	offset += 8
+	args.SlippageBps = binary.LittleEndian.Uint16(tail[offset : offset+2])
+	args.PlatformFeeBps = tail[offset+2]
+
+	return args, nil
+}
+
+// RouteQuote 订单的报价与实际成交对比，用于分析执行质量
+type RouteQuote struct {
+	JupiterRouteArgs
+	ActualInAmount  uint64 `json:"actualInAmount"`  // 首个swap事件的实际输入数量
+	ActualOutAmount uint64 `json:"actualOutAmount"` // 最后一个swap事件的实际输出数量
+}
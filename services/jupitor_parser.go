@@ -7,32 +7,91 @@ import (
 	"github.com/gagliardetto/solana-go"
 	"github.com/gagliardetto/solana-go/rpc"
 	"github.com/near/borsh-go"
+	"github.com/shopspring/decimal"
+	"math/big"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// 订单来源（匹配到的DEX解析器）
+const (
+	OrderSourceJupiter = "jupiter"
+	OrderSourceRaydium = "raydium" // Raydium AMM v4的直接swap
+)
+
 type Order struct {
-	Signature string    // 交易签名
-	Slot      uint64    // 区块slot
-	BlockTime time.Time // 交易时间
-	BuyToken  OrderTokenInfo
-	SellToken OrderTokenInfo
+	Source    string         `json:"source"`    // 解析出该订单的DEX解析器
+	Signature string         `json:"signature"` // 交易签名
+	Slot      uint64         `json:"slot"`      // 区块slot
+	BlockTime time.Time      `json:"blockTime"` // 交易时间
+	BuyToken  OrderTokenInfo `json:"buyToken"`
+	SellToken OrderTokenInfo `json:"sellToken"`
+	Quote     *RouteQuote    `json:"quote,omitempty"` // route指令报价与实际成交数量
+
+	QuoteMint     string `json:"quoteMint"`     // 报价腿（目标代币的对手方）mint
+	QuoteAmount   string `json:"quoteAmount"`   // 报价腿支付或收到的数量（已考虑小数位）
+	QuoteIsStable bool   `json:"quoteIsStable"` // 报价腿是否为稳定币，即QuoteAmount可直接视为美元价值
+
+	Execution *ExecutionPrice `json:"execution,omitempty"` // 报价腿为SOL或稳定币时由swap事件计算的成交均价
+
+	Events []JupiterSwapEventData `json:"events,omitempty"` // 按执行顺序的swap事件（每跳一个），Raydium订单为由转账数量构造的单个事件
+
+	Fee uint64 `json:"fee,omitempty"` // 用户支付的网络手续费（lamports），同一交易有多个订单时只记在第一个订单上
+
+	Warnings []string   `json:"warnings,omitempty"` // 解析核对发现的问题（如SOL腿与事件数量不符）
+	Pricing  *PriceInfo `json:"pricing,omitempty"`  // 计算PnL时目标代币的定价来源
+}
+
+// RouteDiagnostic 匹配到的route指令诊断信息，用于排查买卖代币判定问题
+type RouteDiagnostic struct {
+	RouteAccounts []string               `json:"routeAccounts"` // route指令涉及的账户（base58）
+	Events        []JupiterSwapEventData `json:"events"`        // 解析后的swap事件
+	SellMint      string                 `json:"sellMint"`      // 判定的卖出代币（多个route时为最后一个）
+	BuyMint       string                 `json:"buyMint"`       // 判定的买入代币（多个route时为最后一个）
 }
 
 // PnLResult PnL计算结果
+// 注意：AverageCost、ProfitLossValue、UnrealizedProfitLossValue为截断值（不四舍五入），会略微低估，
+// 四舍五入后的值见Rounded
 type PnLResult struct {
-	AverageCost               float64 `json:"averageCost"`               // 平均买入价格
-	ProfitLossPercentage      string  `json:"profitLossPercentage"`      // 盈亏百分比
-	ProfitLossValue           float64 `json:"profitLossValue"`           // 盈亏值(USD)
-	UnrealizedProfitLossValue float64 `json:"unrealizedProfitLossValue"` // 未实现盈亏(USD) - 仅持仓中
+	AverageCost               float64 `json:"averageCost"`               // 平均买入价格（截断到9位小数）
+	ProfitLossPercentage      string  `json:"profitLossPercentage"`      // 盈亏百分比（四舍五入到2位小数）
+	ProfitLossValue           float64 `json:"profitLossValue"`           // 盈亏值(USD)（截断到10位小数）
+	UnrealizedProfitLossValue float64 `json:"unrealizedProfitLossValue"` // 未实现盈亏(USD) - 仅持仓中（截断到2位小数）
 	IsClosed                  bool    `json:"isClosed"`                  // 是否已平仓
+
+	TotalInvestment float64           `json:"totalInvestment"`           // 总投入成本(USD)，包含初始持仓成本
+	InitialQuantity float64           `json:"initialQuantity,omitempty"` // 计入该持仓的初始持仓数量
+	OpenedAt        time.Time         `json:"openedAt"`                  // 首笔交易时间
+	LastTradeAt     time.Time         `json:"lastTradeAt"`               // 最后一笔交易时间（已平仓时即平仓时间）
+	HoldingSeconds  int64             `json:"holdingSeconds"`            // 持有时长（秒）
+	Annualized      *AnnualizedReturn `json:"annualized,omitempty"`      // 年化收益（仅已平仓）
+	Warnings        []string          `json:"warnings,omitempty"`        // 该持仓相关订单的核对警告
+
+	ProbeFilter *ProbeBuyFilterReport `json:"probeFilter,omitempty"` // 试探性买入过滤的影响（启用且有忽略时）
+
+	Rounded *RoundedPnL `json:"rounded"` // 与截断字段相同小数位的四舍五入值
+
+	Pricing      []OrderPricing `json:"pricing,omitempty"`      // 各订单的定价来源和使用的K线时间
+	Trades       []TradeDetail  `json:"trades,omitempty"`       // 按时间顺序的每笔交易明细
+	CurrentPrice *PriceInfo     `json:"currentPrice,omitempty"` // 计算未实现盈亏使用的当前价格（仅持仓中）
+	Token        *TokenMetadata `json:"token,omitempty"`        // 目标代币的symbol、名称和精度（有元数据时）
+}
+
+// RoundedPnL 四舍五入后的平均成本和盈亏
+type RoundedPnL struct {
+	AverageCost               float64 `json:"averageCost"`               // 四舍五入到9位小数
+	ProfitLossValue           float64 `json:"profitLossValue"`           // 四舍五入到10位小数
+	UnrealizedProfitLossValue float64 `json:"unrealizedProfitLossValue"` // 四舍五入到2位小数
 }
 type JupiterSwapEventData struct {
-	Amm          solana.PublicKey
-	InputMint    solana.PublicKey
-	InputAmount  uint64
-	OutputMint   solana.PublicKey
-	OutputAmount uint64
+	Amm          solana.PublicKey `json:"amm"`
+	InputMint    solana.PublicKey `json:"inputMint"`
+	InputAmount  uint64           `json:"inputAmount"`
+	OutputMint   solana.PublicKey `json:"outputMint"`
+	OutputAmount uint64           `json:"outputAmount"`
 }
 
 type OrderTokenInfo struct {
@@ -41,153 +100,427 @@ type OrderTokenInfo struct {
 	UiTokenAmount rpc.UiTokenAmount `json:"uiTokenAmount"`
 }
 
+// 持仓模型：完全平仓后再次买入时的处理方式
+const (
+	PositionModelPerCycle = "perCycle" // 平仓后再买入开启新持仓（默认）
+	PositionModelLifetime = "lifetime" // 平仓后再买入延续原持仓，平均成本跨周期累计
+)
+
+// PnLOptions 单次PnL计算的可选参数
+type PnLOptions struct {
+	SkipUnpriceable bool   // 无法定价的订单跳过并记录警告，而不是让整个计算失败
+	PositionModel   string // 持仓模型，为空时使用PositionModelPerCycle
+
+	CostBasisMethod CostBasisMethod // 成本计算方法，为空时使用服务默认值（CostBasisAverage）
+
+	RoundTripSlots    uint64 // 检测间隔不超过该slot数的同代币往返交易（可能被夹），0表示不检测
+	ExcludeRoundTrips bool   // 将检测到的往返交易排除在PnL计算之外
+
+	PriceProvider string  // 价格提供方，见PriceProvider*，为空时使用OKX
+	FixedPrice    float64 // PriceProvider为fixed时目标代币使用的价格(USD)，优先于稳定币和成交均价定价
+
+	IgnoreFees bool // 不把网络手续费计入成本和收入
+
+	IncludeEvents bool // 在每笔交易明细中附带解析出的swap事件，用于排查多跳路由
+
+	MinUSDValue float64 // 美元价值（不含手续费）低于该值的订单视为粉尘交易，不参与计算，0表示不过滤
+
+	// 盈亏计价单位，见Denomination*，为空时为USD；以SOL计价时结果和交易明细中的(USD)字段均为SOL数量
+	Denomination string
+
+	// 计算前已持有的数量和总成本(USD)，例如转入的代币；作为一笔早于所有订单的买入计入第一个持仓
+	InitialQuantity float64
+	InitialCostUSD  float64
+}
+
+// PnLCalculation 单次PnL计算的结果
+type PnLCalculation struct {
+	Results        []PnLResult
+	Warnings       []string           // 计算过程中被跳过的订单等提示
+	OrdersBySource map[string]int     // 参与计算的订单按解析器来源计数
+	RoundTrips     []RoundTrip        // 检测到的同代币短间隔往返交易
+	UnmatchedSells []UnmatchedSell    // 没有持仓时的卖出，成本未知，收入单独列出
+	RealizedLots   []RealizedLotEvent // FIFO卖出与买入批次的匹配记录（未分类持有期），平均成本法时为空
+}
+
 // GetUserJupiterOrdersByToken 获取用户在Jupiter上的订单并计算PnL
 func (s *PnlService) CalculatePnL(ctx context.Context, txList []*Transaction, user, mint string) ([]PnLResult, error) {
+	calc, err := s.CalculatePnLWithOptions(ctx, txList, user, mint, PnLOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return calc.Results, nil
+}
+
+// CalculatePnLWithOptions 按opts计算PnL
+func (s *PnlService) CalculatePnLWithOptions(ctx context.Context, txList []*Transaction, user, mint string, opts PnLOptions) (*PnLCalculation, error) {
 	// 1. 获取所有相关订单
-	orders, err := s.fetchJupiterOrders(ctx, txList, user, mint)
+	orders, err := s.fetchOrders(ctx, txList, user, mint)
 	if err != nil {
 		return nil, err
 	}
 
 	// 2. 按时间排序（从旧到新）
-	sort.Slice(orders, func(i, j int) bool {
-		return orders[i].BlockTime.Before(orders[j].BlockTime)
-	})
+	sortOrdersByTime(orders)
+
+	bySource := make(map[string]int)
+	for _, order := range orders {
+		bySource[order.Source]++
+	}
+
+	// 3. 标记短间隔往返交易，按需排除
+	roundTrips := detectRoundTrips(orders, mint, opts.RoundTripSlots)
+	if opts.ExcludeRoundTrips {
+		orders = excludeRoundTrips(orders, roundTrips)
+	}
 
-	// 3. 计算PnL
-	pnlResults, err := s.calculatePnL(ctx, orders, mint)
+	// 4. 计算PnL
+	calc, err := s.calculatePnL(ctx, orders, mint, opts)
 	if err != nil {
 		return nil, err
 	}
+	calc.OrdersBySource = bySource
+	calc.RoundTrips = roundTrips
 
-	return pnlResults, nil
+	// 5. 附带代币元数据，只用于展示
+	if len(calc.Results) > 0 {
+		if meta := s.tokenMetadata(ctx, mint); meta != nil {
+			for i := range calc.Results {
+				calc.Results[i].Token = meta
+			}
+		}
+	}
+	return calc, nil
 }
 
-func (s *PnlService) fetchJupiterOrders(ctx context.Context, txList []*Transaction, user, mint string) ([]Order, error) {
+// fetchOrders 解析交易列表中与目标代币相关的所有DEX订单
+func (s *PnlService) fetchOrders(ctx context.Context, txList []*Transaction, user, mint string) ([]Order, error) {
 	var orders []Order
 	if len(txList) == 0 {
 		return make([]Order, 0), nil
 	}
 
 	for _, tx := range txList {
-		fullAccountKeys, err := GetFullAccountKeys(tx.RawTx)
+		txOrders, err := s.parseOrders(ctx, tx, user, mint, nil)
 		if err != nil {
-			err = fmt.Errorf("err %w", err)
-			continue
+			return nil, err
 		}
+		orders = append(orders, txOrders...)
+	}
+	return orders, nil
 
-		insTree, err := ParseInstructionTreeByStackHeight(tx.RawTx)
-		if err != nil {
-			err = fmt.Errorf("err %w", err)
-			continue
-		}
-		route, event := FindNodesByProgramID(fullAccountKeys, insTree, s.jupiterPID)
+}
 
-		if len(route) > 1 {
-			fmt.Printf("交易有一个以上jupiter %s\n", tx.Signature)
-			err = fmt.Errorf("交易有一个以上jupiter %s", tx.Signature)
-			continue
-		}
+// parseOrders 从单笔交易中解析出与目标代币相关的订单：每个Jupiter route指令对应一个订单，
+// 不经过Jupiter的每个Raydium swap指令对应一个订单
+// 交易失败、不包含可识别的swap或与目标代币无关时返回空；mint为空时返回任意代币的订单；
+// diag不为nil时记录匹配到的Jupiter route账户和事件数据
+func (s *PnlService) parseOrders(ctx context.Context, tx *Transaction, user, mint string, diag *RouteDiagnostic) ([]Order, error) {
+	// 失败的交易仍包含指令和余额快照，但资金没有实际转移，不产生订单
+	if tx.RawTx.Meta != nil && tx.RawTx.Meta.Err != nil {
+		return nil, nil
+	}
 
-		if len(route) == 0 {
-			continue
-		}
+	fullAccountKeys, err := GetFullAccountKeys(tx.RawTx)
+	if err != nil {
+		return nil, nil
+	}
 
-		_, tokenChangeMap, err := GetBalanceChanges(tx.RawTx, fullAccountKeys)
-		if err != nil {
-			return nil, err
-		}
+	insTree, err := ParseInstructionTreeByStackHeight(tx.RawTx)
+	if err != nil {
+		return nil, nil
+	}
+	nodes, err := FindDEXNodes(fullAccountKeys, insTree, s.dexMatchers(), s.maxTreeDepth)
+	if err != nil {
+		s.log(ctx).Warn("指令树过深，跳过交易", "signature", tx.Signature, "error", err)
+		return nil, nil
+	}
+	route, event, raydium := nodes[0], nodes[1], nodes[2]
 
-		var buyTokenMint, sellTokenMint string
-		//指令对应的买卖token不准，所以改用事件 取第一个事件的input作为sellTokenMint，最后一个事件的outmint作为buyTokenMint
-		//sellTokenMint = fullAccountKeys[route[0].Accounts[13]]
-		//buyTokenMint = fullAccountKeys[route[0].Accounts[5]]
-
-		for i, node := range event {
-			var data JupiterSwapEventData
-			if i == 0 {
-				err := borsh.Deserialize(&data, node.Data[16:])
-				if err != nil {
-					return nil, fmt.Errorf("DecodeJupiter Deserialize(JupiterSwapEventData) %s %w", hex.EncodeToString(node.Data), err)
-				}
-				sellTokenMint = data.InputMint.String()
-			}
-			if i == len(event)-1 {
-				err := borsh.Deserialize(&data, node.Data[16:])
-				if err != nil {
-					return nil, fmt.Errorf("DecodeJupiter Deserialize(JupiterSwapEventData) %s %w", hex.EncodeToString(node.Data), err)
+	if len(route) == 0 && len(raydium) == 0 {
+		return nil, nil
+	}
+
+	tokenMap, tokenChangeMap, err := GetBalanceChanges(tx.RawTx, fullAccountKeys)
+	if err != nil {
+		return nil, err
+	}
+	// 交易中出现的代币精度直接写入缓存，避免之后再通过RPC查询
+	for _, info := range tokenMap {
+		s.decimals.set(info.Mint, info.Decimals)
+	}
+
+	orders, err := s.parseJupiterOrders(ctx, tx, user, mint, route, event, fullAccountKeys, tokenChangeMap, diag)
+	if err != nil {
+		return nil, err
+	}
+	raydiumOrders, err := s.parseRaydiumOrders(ctx, tx, user, mint, raydium, fullAccountKeys, tokenMap)
+	if err != nil {
+		return nil, err
+	}
+	orders = append(orders, raydiumOrders...)
+
+	// 手续费由第一个签名者（fee payer）支付，只在用户付费时计入
+	if len(orders) > 0 && tx.RawTx.Meta != nil && len(fullAccountKeys) > 0 && fullAccountKeys[0].String() == user {
+		orders[0].Fee = tx.RawTx.Meta.Fee
+	}
+	return orders, nil
+}
+
+// dexMatchers 识别的DEX指令，顺序对应parseOrders中FindDEXNodes的结果：Jupiter route、Jupiter swap事件、Raydium swap
+func (s *PnlService) dexMatchers() []DEXMatcher {
+	return append(jupiterMatchers(s.jupiterPID, s.jupiterDiscs), DEXMatcher{ProgramID: RaydiumAMMProgramID, Match: isRaydiumSwap})
+}
+
+// parseJupiterOrders 由匹配到的Jupiter route指令和swap事件构造订单，每个route对应一个订单
+func (s *PnlService) parseJupiterOrders(ctx context.Context, tx *Transaction, user, mint string, route, event []*StackInstructionNode, fullAccountKeys []solana.PublicKey, tokenChangeMap map[string]map[string]*TokenChange, diag *RouteDiagnostic) ([]Order, error) {
+	if len(route) == 0 {
+		return nil, nil
+	}
+
+	if diag != nil {
+		for _, r := range route {
+			for _, idx := range r.Accounts {
+				if int(idx) < len(fullAccountKeys) {
+					diag.RouteAccounts = append(diag.RouteAccounts, fullAccountKeys[idx].String())
 				}
-				buyTokenMint = data.OutputMint.String()
 			}
 		}
+	}
 
-		if buyTokenMint == "So11111111111111111111111111111111111111112" {
-			buyTokenMint = "SOL"
+	var orders []Order
+	for i, group := range groupEventsByRoute(route, event) {
+		order, err := s.parseRouteOrder(ctx, tx, user, mint, route[i], group, tokenChangeMap, len(route) > 1, diag)
+		if err != nil {
+			return nil, err
 		}
-		if sellTokenMint == "So11111111111111111111111111111111111111112" {
-			sellTokenMint = "SOL"
+		if order != nil {
+			orders = append(orders, *order)
 		}
+	}
+	return orders, nil
+}
 
-		if sellTokenMint == mint {
-			sellTokenChange := tokenChangeMap[user][mint]
-			sellToken := OrderTokenInfo{
-				Mint: sellTokenMint,
-				UiTokenAmount: rpc.UiTokenAmount{
-					Amount:         sellTokenChange.Amount,
-					Decimals:       sellTokenChange.Decimals,
-					UiAmountString: sellTokenChange.UiAmountString,
-				},
+// groupEventsByRoute 按指令树位置将swap事件分配给所属的route：事件是route的子孙节点时属于该route
+// 只有一个route时所有事件都属于它，兼容事件不在route之下的交易
+func groupEventsByRoute(route, event []*StackInstructionNode) [][]*StackInstructionNode {
+	groups := make([][]*StackInstructionNode, len(route))
+	if len(route) == 1 {
+		groups[0] = event
+		return groups
+	}
+	position := make(map[*StackInstructionNode]int, len(route))
+	for i, r := range route {
+		position[r] = i
+	}
+	for _, e := range event {
+		for parent := e.Parent; parent != nil; parent = parent.Parent {
+			if i, ok := position[parent]; ok {
+				groups[i] = append(groups[i], e)
+				break
 			}
+		}
+	}
+	return groups
+}
 
-			buyTokenChange := tokenChangeMap[user][buyTokenMint]
-			buyToken := OrderTokenInfo{
-				Mint: buyTokenMint,
-				UiTokenAmount: rpc.UiTokenAmount{
-					Amount:         buyTokenChange.Amount,
-					Decimals:       buyTokenChange.Decimals,
-					UiAmountString: buyTokenChange.UiAmountString,
-				},
-			}
-			newOrder := Order{
-				Signature: tx.Signature,
-				Slot:      tx.Slot,
-				BlockTime: tx.BlockTime,
-				SellToken: sellToken,
-				BuyToken:  buyToken,
-			}
+// parseRouteOrder 由一个route及其swap事件构造订单
+// 交易只有一个route时买卖数量取用户的余额变化；有多个route时余额变化是所有swap的合计，
+// 数量改为取该route首个事件的输入和最后一个事件的输出
+func (s *PnlService) parseRouteOrder(ctx context.Context, tx *Transaction, user, mint string, route *StackInstructionNode, event []*StackInstructionNode, tokenChangeMap map[string]map[string]*TokenChange, multiRoute bool, diag *RouteDiagnostic) (*Order, error) {
+	if len(event) == 0 {
+		return nil, nil
+	}
 
-			orders = append(orders, newOrder)
-		} else if buyTokenMint == mint {
-			buyTokenChange := tokenChangeMap[user][mint]
-			buyToken := OrderTokenInfo{
-				Mint: buyTokenMint,
-				UiTokenAmount: rpc.UiTokenAmount{
-					Amount:         buyTokenChange.Amount,
-					Decimals:       buyTokenChange.Decimals,
-					UiAmountString: buyTokenChange.UiAmountString,
-				},
-			}
+	var buyTokenMint, sellTokenMint string
+	//指令对应的买卖token不准，所以改用事件 取第一个事件的input作为sellTokenMint，最后一个事件的outmint作为buyTokenMint
+	//sellTokenMint = fullAccountKeys[route[0].Accounts[13]]
+	//buyTokenMint = fullAccountKeys[route[0].Accounts[5]]
+
+	// 树遍历得到的事件顺序不一定是执行顺序，按指令全局索引排序后再取首尾
+	sort.SliceStable(event, func(i, j int) bool {
+		return event[i].Index < event[j].Index
+	})
+
+	var sellEventAmount, buyEventAmount uint64
+	var events []JupiterSwapEventData
+	var quote *RouteQuote
+	if args, err := DecodeJupiterRouteArgs(route.Data); err == nil {
+		quote = &RouteQuote{JupiterRouteArgs: *args}
+	}
 
-			sellTokenChange := tokenChangeMap[user][buyTokenMint]
-			sellToken := OrderTokenInfo{
-				Mint: sellTokenMint,
-				UiTokenAmount: rpc.UiTokenAmount{
-					Amount:         sellTokenChange.Amount,
-					Decimals:       sellTokenChange.Decimals,
-					UiAmountString: sellTokenChange.UiAmountString,
-				},
+	for i, node := range event {
+		var data JupiterSwapEventData
+		err := borsh.Deserialize(&data, node.Data[16:])
+		if err != nil {
+			return nil, fmt.Errorf("DecodeJupiter Deserialize(JupiterSwapEventData) %s %w", hex.EncodeToString(node.Data), err)
+		}
+		events = append(events, data)
+		if diag != nil {
+			diag.Events = append(diag.Events, data)
+		}
+		if i == 0 {
+			sellTokenMint = data.InputMint.String()
+			sellEventAmount = data.InputAmount
+			if quote != nil {
+				quote.ActualInAmount = data.InputAmount
 			}
-			newOrder := Order{
-				Signature: tx.Signature,
-				Slot:      tx.Slot,
-				BlockTime: tx.BlockTime,
-				SellToken: sellToken,
-				BuyToken:  buyToken,
+		}
+		if i == len(event)-1 {
+			buyTokenMint = data.OutputMint.String()
+			buyEventAmount = data.OutputAmount
+			if quote != nil {
+				quote.ActualOutAmount = data.OutputAmount
 			}
+		}
+	}
+
+	if buyTokenMint == "So11111111111111111111111111111111111111112" {
+		buyTokenMint = "SOL"
+	}
+	if sellTokenMint == "So11111111111111111111111111111111111111112" {
+		sellTokenMint = "SOL"
+	}
+
+	if diag != nil {
+		diag.SellMint = sellTokenMint
+		diag.BuyMint = buyTokenMint
+	}
+
+	// mint为空时不过滤，用于发现用户交易过的所有代币
+	if mint != "" && sellTokenMint != mint && buyTokenMint != mint {
+		return nil, nil
+	}
 
-			orders = append(orders, newOrder)
+	newOrder := Order{
+		Source:    OrderSourceJupiter,
+		Signature: tx.Signature,
+		Slot:      tx.Slot,
+		BlockTime: tx.BlockTime,
+		Quote:     quote,
+		Events:    events,
+	}
+	if multiRoute {
+		// 多个route时用事件数量，精度取交易中出现的代币精度
+		sellDecimals, ok1 := s.knownDecimals(sellTokenMint)
+		buyDecimals, ok2 := s.knownDecimals(buyTokenMint)
+		if !ok1 || !ok2 {
+			s.log(ctx).Debug("多route交易的代币精度未知，跳过该route", "signature", tx.Signature, "sellMint", sellTokenMint, "buyMint", buyTokenMint)
+			return nil, nil
 		}
+		newOrder.SellToken = eventOrderTokenInfo(sellTokenMint, sellEventAmount, sellDecimals)
+		newOrder.BuyToken = eventOrderTokenInfo(buyTokenMint, buyEventAmount, buyDecimals)
+		newOrder.Warnings = append(newOrder.Warnings, "交易包含多个Jupiter route，数量取自该route的swap事件")
+	} else {
+		// 买卖两边都取用户自身对应资产的余额变化
+		newOrder.SellToken = newOrderTokenInfo(sellTokenMint, tokenChangeMap[user][sellTokenMint])
+		newOrder.BuyToken = newOrderTokenInfo(buyTokenMint, tokenChangeMap[user][buyTokenMint])
 	}
-	return orders, nil
 
+	if s.isNegligibleOrder(newOrder) {
+		return nil, nil
+	}
+	s.setOrderQuote(&newOrder, mint)
+	if mint != "" {
+		newOrder.Execution = s.executionPrice(events, newOrder, mint)
+	}
+
+	// SOL腿核对：余额变化与事件数量应基本一致；多个route时余额变化无法对应到单个route，不核对
+	if !multiRoute && sellTokenMint == "SOL" {
+		s.reconcileSOLLeg(ctx, &newOrder, tx.RawTx.Meta.Fee, sellEventAmount, true)
+	}
+	if !multiRoute && buyTokenMint == "SOL" {
+		s.reconcileSOLLeg(ctx, &newOrder, tx.RawTx.Meta.Fee, buyEventAmount, false)
+	}
+	return &newOrder, nil
+}
+
+// GetTransactionOrders 获取单笔交易中与目标代币相关的订单，verbose为true时附带route账户和事件解析结果用于排查
+func (s *PnlService) GetTransactionOrders(ctx context.Context, signature, user, mint string, verbose bool) ([]Order, *RouteDiagnostic, error) {
+	sig, err := solana.SignatureFromBase58(signature)
+	if err != nil {
+		return nil, nil, fmt.Errorf("交易签名格式错误: %w", err)
+	}
+
+	txList, err := s.getBatchTransactions(ctx, []solana.Signature{sig})
+	if err != nil {
+		return nil, nil, fmt.Errorf("获取交易详情失败: %w", err)
+	}
+
+	var diag *RouteDiagnostic
+	if verbose {
+		diag = &RouteDiagnostic{}
+	}
+
+	orders := make([]Order, 0)
+	for _, tx := range txList {
+		txOrders, err := s.parseOrders(ctx, tx, user, mint, diag)
+		if err != nil {
+			return nil, nil, err
+		}
+		orders = append(orders, txOrders...)
+	}
+	return orders, diag, nil
+}
+
+// newOrderTokenInfo 由余额变化构造订单代币信息，余额无变化记录时数量为0
+func newOrderTokenInfo(mint string, change *TokenChange) OrderTokenInfo {
+	if change == nil {
+		return OrderTokenInfo{
+			Mint:          mint,
+			UiTokenAmount: rpc.UiTokenAmount{Amount: "0", UiAmountString: "0"},
+		}
+	}
+	// 订单两边记录成交数量，买卖方向由腿区分，取余额变化的绝对值
+	return OrderTokenInfo{
+		Mint: mint,
+		UiTokenAmount: rpc.UiTokenAmount{
+			Amount:         strings.TrimPrefix(change.Amount, "-"),
+			Decimals:       change.Decimals,
+			UiAmountString: strings.TrimPrefix(change.UiAmountString, "-"),
+		},
+	}
+}
+
+// eventOrderTokenInfo 由swap事件数量构造订单代币信息
+func eventOrderTokenInfo(mint string, amount uint64, decimals uint8) OrderTokenInfo {
+	raw := strconv.FormatUint(amount, 10)
+	return OrderTokenInfo{
+		Mint: mint,
+		UiTokenAmount: rpc.UiTokenAmount{
+			Amount:         raw,
+			Decimals:       decimals,
+			UiAmountString: formatTokenAmount(raw, decimals),
+		},
+	}
+}
+
+// setOrderQuote 以目标代币的对手方作为报价腿：买入目标代币时为卖出腿，卖出时为买入腿
+func (s *PnlService) setOrderQuote(order *Order, mint string) {
+	quote := order.SellToken
+	if order.SellToken.Mint == mint {
+		quote = order.BuyToken
+	}
+	order.QuoteMint = quote.Mint
+	order.QuoteAmount = quote.UiTokenAmount.UiAmountString
+	_, order.QuoteIsStable = s.stablecoins[quote.Mint]
+}
+
+// isNegligibleOrder 判断订单买卖两边的余额变化是否都不超过零变化阈值（自路由套利、失败腿等）
+func (s *PnlService) isNegligibleOrder(order Order) bool {
+	return isNegligibleAmount(order.BuyToken.UiTokenAmount, s.zeroEpsilon) &&
+		isNegligibleAmount(order.SellToken.UiTokenAmount, s.zeroEpsilon)
+}
+
+// isNegligibleAmount 按Amount和Decimals计算实际数量，判断其绝对值是否不超过epsilon
+func isNegligibleAmount(amount rpc.UiTokenAmount, epsilon float64) bool {
+	if amount.Amount == "" {
+		return true
+	}
+	raw, ok := new(big.Int).SetString(amount.Amount, 10)
+	if !ok {
+		return false
+	}
+	value := decimal.NewFromBigInt(raw, -int32(amount.Decimals)).Abs()
+	return value.LessThanOrEqual(decimal.NewFromFloat(epsilon))
 }
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+)
+
+// requestIDKey context中请求关联ID的key
+type requestIDKey struct{}
+
+// ContextWithRequestID 在ctx中记录请求关联ID，服务内的日志会带上requestId字段
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestIDFromContext 返回ctx中的请求关联ID，没有时返回空
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// LoggerWithRequestID ctx中有请求关联ID时返回带requestId字段的logger
+func LoggerWithRequestID(ctx context.Context, logger *slog.Logger) *slog.Logger {
+	if id := RequestIDFromContext(ctx); id != "" {
+		return logger.With("requestId", id)
+	}
+	return logger
+}
+
+// WithLogger 设置服务日志输出，nil时使用slog.Default()
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *PnlService) {
+		if logger != nil {
+			s.logger = logger
+		}
+	}
+}
+
+// log 返回带当前请求关联ID的logger
+func (s *PnlService) log(ctx context.Context) *slog.Logger {
+	return LoggerWithRequestID(ctx, s.logger)
+}
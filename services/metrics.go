@@ -0,0 +1,30 @@
+package services
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 服务内部的Prometheus指标，由MetricsCollectors返回给调用方注册
+var (
+	rpcRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dplabs_rpc_requests_total",
+		Help: "发往Solana RPC的请求数（含重试），按方法区分",
+	}, []string{"method"})
+	txCacheHitsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "dplabs_tx_cache_hits_total",
+		Help: "交易缓存命中次数",
+	})
+	txCacheMissesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "dplabs_tx_cache_misses_total",
+		Help: "交易缓存未命中次数",
+	})
+	okxRequestErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "dplabs_okx_request_errors_total",
+		Help: "OKX行情请求失败次数，不含调用方取消",
+	})
+)
+
+// MetricsCollectors 返回services包的Prometheus指标
+func MetricsCollectors() []prometheus.Collector {
+	return []prometheus.Collector{rpcRequestsTotal, txCacheHitsTotal, txCacheMissesTotal, okxRequestErrorsTotal}
+}
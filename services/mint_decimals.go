@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// SPL Token（及Token-2022）Mint账户中decimals字段的偏移：mint_authority(36) + supply(8)
+const mintDecimalsOffset = 44
+
+// cachedDecimals 缓存的代币精度
+type cachedDecimals struct {
+	decimals  uint8
+	fetchedAt time.Time
+}
+
+// decimalsCache mint -> decimals缓存，精度几乎不会变化，默认永不过期
+type decimalsCache struct {
+	mu      sync.RWMutex
+	entries map[string]cachedDecimals
+	ttl     time.Duration // 0表示不过期
+}
+
+func newDecimalsCache() *decimalsCache {
+	return &decimalsCache{
+		entries: make(map[string]cachedDecimals),
+	}
+}
+
+func (c *decimalsCache) get(mint string) (uint8, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, ok := c.entries[mint]
+	if !ok || (c.ttl > 0 && time.Since(entry.fetchedAt) > c.ttl) {
+		return 0, false
+	}
+	return entry.decimals, true
+}
+
+func (c *decimalsCache) set(mint string, decimals uint8) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[mint] = cachedDecimals{decimals: decimals, fetchedAt: time.Now()}
+}
+
+// WithDecimalsCacheTTL 设置代币精度缓存的过期时间，默认不过期
+func WithDecimalsCacheTTL(ttl time.Duration) Option {
+	return func(s *PnlService) {
+		if ttl > 0 {
+			s.decimals.ttl = ttl
+		}
+	}
+}
+
+// GetMintDecimals 获取代币精度，每个mint在进程内最多通过GetAccountInfo查询一次
+func (s *PnlService) GetMintDecimals(ctx context.Context, mint string) (uint8, error) {
+	if mint == "SOL" || mint == solana.SolMint.String() {
+		return 9, nil
+	}
+	if decimals, ok := s.decimals.get(mint); ok {
+		return decimals, nil
+	}
+
+	mintKey, err := solana.PublicKeyFromBase58(mint)
+	if err != nil {
+		return 0, fmt.Errorf("代币地址格式错误: %w", err)
+	}
+	account, err := s.rpcClient.GetAccountInfo(ctx, mintKey)
+	if err != nil {
+		return 0, fmt.Errorf("获取代币 %s 账户信息失败: %w", mint, err)
+	}
+	data := account.GetBinary()
+	if len(data) <= mintDecimalsOffset {
+		return 0, fmt.Errorf("代币 %s 账户数据长度%d不是有效的Mint账户", mint, len(data))
+	}
+
+	decimals := data[mintDecimalsOffset]
+	s.decimals.set(mint, decimals)
+	return decimals, nil
+}
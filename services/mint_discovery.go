@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"sync"
+)
+
+// 代币发现默认并发参数
+const (
+	DefaultMintDiscoveryConcurrency = 8  // 同时解析交易的goroutine数量
+	DefaultMintDiscoveryBatchSize   = 50 // 每个goroutine一次领取的交易数量
+)
+
+// mintDiscoveryCache 按钱包缓存每笔交易中买卖过的代币，重复的组合请求只解析新交易
+type mintDiscoveryCache struct {
+	mu      sync.Mutex
+	wallets map[string]map[string][]string // 钱包 -> 交易签名 -> 该交易买卖的代币
+}
+
+func newMintDiscoveryCache() *mintDiscoveryCache {
+	return &mintDiscoveryCache{wallets: make(map[string]map[string][]string)}
+}
+
+// lookup 返回已扫描交易的代币，未扫描的交易索引放入pending
+func (c *mintDiscoveryCache) lookup(user string, txList []*Transaction) (known map[int][]string, pending []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	known = make(map[int][]string)
+	scanned := c.wallets[user]
+	for i, tx := range txList {
+		if mints, ok := scanned[tx.Signature]; ok {
+			known[i] = mints
+			continue
+		}
+		pending = append(pending, i)
+	}
+	return known, pending
+}
+
+// store 记录新扫描交易的代币
+func (c *mintDiscoveryCache) store(user string, txList []*Transaction, found map[int][]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	scanned := c.wallets[user]
+	if scanned == nil {
+		scanned = make(map[string][]string)
+		c.wallets[user] = scanned
+	}
+	for i, mints := range found {
+		scanned[txList[i].Signature] = mints
+	}
+}
+
+// clear 清空所有钱包的扫描记录
+func (c *mintDiscoveryCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wallets = make(map[string]map[string][]string)
+}
+
+// WithMintDiscovery 设置钱包代币发现的并发数和每批交易数量，<=0时使用默认值
+func WithMintDiscovery(concurrency, batchSize int) Option {
+	return func(s *PnlService) {
+		if concurrency > 0 {
+			s.discoveryConcurrency = concurrency
+		}
+		if batchSize > 0 {
+			s.discoveryBatchSize = batchSize
+		}
+	}
+}
+
+// tradedMints 解析单笔交易中用户通过Jupiter或Raydium买卖的代币，SOL和稳定币作为报价资产不计入
+func (s *PnlService) tradedMints(ctx context.Context, tx *Transaction, user string) []string {
+	orders, err := s.parseOrders(ctx, tx, user, "", nil)
+	if err != nil {
+		return nil
+	}
+	var mints []string
+	for _, order := range orders {
+		for _, mint := range []string{order.BuyToken.Mint, order.SellToken.Mint} {
+			if mint == "SOL" || mint == "" {
+				continue
+			}
+			if _, stable := s.stablecoins[mint]; stable {
+				continue
+			}
+			mints = append(mints, mint)
+		}
+	}
+	return mints
+}
+
+// scanTradedMints 按批并行解析pending中的交易
+func (s *PnlService) scanTradedMints(ctx context.Context, txList []*Transaction, user string, pending []int) map[int][]string {
+	results := make([][]string, len(pending))
+	batches := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < s.discoveryConcurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range batches {
+				end := start + s.discoveryBatchSize
+				if end > len(pending) {
+					end = len(pending)
+				}
+				for j := start; j < end; j++ {
+					results[j] = s.tradedMints(ctx, txList[pending[j]], user)
+				}
+			}
+		}()
+	}
+	for start := 0; start < len(pending); start += s.discoveryBatchSize {
+		batches <- start
+	}
+	close(batches)
+	wg.Wait()
+
+	found := make(map[int][]string, len(pending))
+	for j, i := range pending {
+		found[i] = results[j]
+	}
+	return found
+}
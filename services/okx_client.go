@@ -8,15 +8,35 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
 	"net/http"
 	"net/url"
 	"reflect"
 	"strconv"
 	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// OKX请求默认限制
+const (
+	DefaultOKXMaxResponseBytes = 1 << 20 // 响应体最大1MB
+	DefaultOKXRequestTimeout   = 10 * time.Second
+	DefaultOKXRateLimit        = 10 // 每秒请求数
+	DefaultOKXRateBurst        = 1
 )
 
+// NewOKXRateLimiter 创建OKX请求限流器，perSecond<=0时返回nil表示不限流
+func NewOKXRateLimiter(perSecond float64, burst int) *rate.Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), burst)
+}
+
 type OKXClient struct {
 	BaseUrl              string
 	MarketHistoricalPath string
@@ -24,15 +44,45 @@ type OKXClient struct {
 	ApiKey               string
 	PassPhrase           string
 	SecretKey            string
+	MaxResponseBytes     int64         // 响应体大小上限，<=0时使用DefaultOKXMaxResponseBytes
+	RequestTimeout       time.Duration // 单次请求超时，<=0时使用DefaultOKXRequestTimeout
+	HTTPClient           *http.Client  // 发送请求的客户端，为nil时按RequestTimeout创建
+	// Limiter 每次HTTP请求前等待的令牌桶，为nil时不限流；OKXClient按值复制，副本共享同一个限流器
+	Limiter *rate.Limiter
+	// HistoricalBar 历史价格使用的K线粒度（见OKXBarFallbacks），为空或不支持时使用1s
+	// 该粒度没有包含交易时间的K线时依次回退到更粗的粒度
+	HistoricalBar string
+}
+
+// OKXBarFallbacks 历史价格K线粒度从细到粗的回退顺序
+var OKXBarFallbacks = []string{"1s", "1m", "1H", "1D"}
+
+// okxBarDurations K线粒度对应的时间窗口
+var okxBarDurations = map[string]time.Duration{
+	"1s": time.Second,
+	"1m": time.Minute,
+	"1H": time.Hour,
+	"1D": 24 * time.Hour,
+}
+
+// historicalBars 从HistoricalBar开始的回退粒度列表
+func (o OKXClient) historicalBars() []string {
+	for i, bar := range OKXBarFallbacks {
+		if bar == o.HistoricalBar {
+			return OKXBarFallbacks[i:]
+		}
+	}
+	return OKXBarFallbacks
 }
 
+// OKXTokenPriceRequest OKX行情接口的query参数，空字段不发送
 type OKXTokenPriceRequest struct {
 	ChainIndex           string `param:"chainIndex"`
 	TokenContractAddress string `param:"tokenContractAddress"`
-	after                string `param:"after"`
-	before               string `param:"before"`
-	bar                  string `param:"bar"`
-	limit                string `param:"limit"`
+	After                string `param:"after"`  // 返回该时间（毫秒）之前的K线
+	Before               string `param:"before"` // 返回该时间（毫秒）之后的K线
+	Bar                  string `param:"bar"`    // K线粒度，如1s、1m、1H
+	Limit                string `param:"limit"`  // 返回条数
 }
 
 func (r OKXTokenPriceRequest) String() string {
@@ -171,6 +221,8 @@ func parseInt(s string) (int, error) {
 // 错误处理相关定义
 var (
 	ErrInvalidRecordLength = errors.New("invalid record length (expected 8 fields)")
+	ErrOKXResponseTooLarge = errors.New("OKX响应超过大小上限")
+	ErrOKXInvalidResponse  = errors.New("OKX响应格式错误")
 )
 
 // wrapError 包装字段解析错误
@@ -178,86 +230,105 @@ func wrapError(field string, err error) error {
 	return fmt.Errorf("parse %s failed: %w", field, err)
 }
 
+// OKXAPIError OKX返回的业务错误（code不为"0"）
+type OKXAPIError struct {
+	Code string
+	Msg  string
+}
+
+func (e *OKXAPIError) Error() string {
+	return fmt.Sprintf("OKX接口返回错误: code=%s msg=%s", e.Code, e.Msg)
+}
+
+// MarketCandles 解析后的K线记录及OKX原始响应，便于排查OKX数据问题
+type MarketCandles struct {
+	Records []MarketRecord  `json:"records"`
+	Raw     *MarketResponse `json:"raw"`
+}
+
 func (o OKXClient) GetTokenHistoricalPriceByTimeLatest(ctx context.Context, mint string, stime string) ([]MarketRecord, error) {
+	candles, err := o.GetTokenHistoricalCandles(ctx, mint, stime)
+	if err != nil {
+		return nil, err
+	}
+	return candles.Records, nil
+}
+
+// GetTokenHistoricalCandles 查询stime之前最近的1s K线，同时返回解析结果和原始响应
+func (o OKXClient) GetTokenHistoricalCandles(ctx context.Context, mint string, stime string) (*MarketCandles, error) {
 	// 构建请求参数结构体
 	reqParams := OKXTokenPriceRequest{
 		ChainIndex:           "501",
 		TokenContractAddress: mint,
-		after:                stime,
-		bar:                  "1s",
+		After:                stime,
+		Bar:                  "1s",
 	}
-	// 生成时间戳（UTC格式）
-	timestamp := time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
-
-	// 构建签名内容
-	method := "GET"
-	signatureContent := timestamp + method + o.MarketHistoricalPath + "?" + reqParams.String()
-
-	// 计算HMAC-SHA256签名
-	h := hmac.New(sha256.New, []byte(o.SecretKey))
-	h.Write([]byte(signatureContent))
-	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
-
-	// 构建完整URL
-	fullURL := fmt.Sprintf("%s%s?%s", o.BaseUrl, o.MarketHistoricalPath, reqParams.String())
+	return o.getMarketCandles(ctx, o.MarketHistoricalPath, reqParams)
+}
 
-	// 创建HTTP请求
-	req, err := http.NewRequest(method, fullURL, nil)
-	if err != nil {
-		err := fmt.Errorf("OKXApprove创建请求失败", err)
-		return nil, err
+// GetCandles 按请求参数查询历史K线，调用方可以指定K线粒度和分页窗口；ChainIndex为空时使用Solana
+func (o OKXClient) GetCandles(ctx context.Context, reqParams OKXTokenPriceRequest) (*MarketCandles, error) {
+	if reqParams.ChainIndex == "" {
+		reqParams.ChainIndex = "501"
 	}
+	return o.getMarketCandles(ctx, o.MarketHistoricalPath, reqParams)
+}
 
-	// 设置请求头
-	req.Header.Set("OK-ACCESS-KEY", o.ApiKey)
-	req.Header.Set("OK-ACCESS-SIGN", signature)
-	req.Header.Set("OK-ACCESS-PASSPHRASE", o.PassPhrase)
-	req.Header.Set("OK-ACCESS-TIMESTAMP", timestamp)
-	req.Header.Set("Content-Type", "application/json")
-
-	// 发送请求
-	client := &http.Client{}
-	resp, err := client.Do(req)
+func (o OKXClient) GetTokenCurrentPrice(ctx context.Context, mint string) ([]MarketRecord, error) {
+	// 构建请求参数结构体
+	reqParams := OKXTokenPriceRequest{
+		ChainIndex:           "501",
+		TokenContractAddress: mint,
+	}
+	candles, err := o.getMarketCandles(ctx, o.MarketCurrentPath, reqParams)
 	if err != nil {
-		err := fmt.Errorf("OKXApprove发送请求失败:", err)
 		return nil, err
 	}
-	defer resp.Body.Close()
+	return candles.Records, nil
+}
 
-	// 读取响应内容
-	body, err := ioutil.ReadAll(resp.Body)
+// Ping 检查OKX接口地址是否可达，收到任意HTTP响应即视为可达，不消耗行情接口配额
+func (o OKXClient) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, o.BaseUrl, nil)
 	if err != nil {
-		err := fmt.Errorf("OKXApprove读取响应失败", err)
-		return nil, err
+		return fmt.Errorf("创建OKX请求失败: %w", err)
 	}
-	// 1. 解析JSON到MarketResponse
-	var response MarketResponse
-	if err := json.Unmarshal(body, &response); err != nil {
-		log.Fatalf("JSON解析失败: %v", err)
+	client := o.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: DefaultOKXRequestTimeout}
 	}
-
-	// 2. 将原始数据转换为MarketRecord切片
-	records, err := response.ParseRecords()
+	resp, err := client.Do(req)
 	if err != nil {
-		log.Fatalf("数据转换失败: %v", err)
+		return fmt.Errorf("OKX不可达: %w", err)
 	}
-
-	return records, nil
-
+	resp.Body.Close()
+	return nil
 }
 
-func (o OKXClient) GetTokenCurrentPrice(ctx context.Context, mint string) ([]MarketRecord, error) {
-	// 构建请求参数结构体
-	reqParams := OKXTokenPriceRequest{
-		ChainIndex:           "501",
-		TokenContractAddress: mint,
+// getMarketCandles 签名并请求OKX行情接口，code不为"0"时返回包含OKX msg的OKXAPIError
+func (o OKXClient) getMarketCandles(ctx context.Context, path string, reqParams OKXTokenPriceRequest) (candles *MarketCandles, err error) {
+	defer func() {
+		if err != nil && ctx.Err() == nil {
+			okxRequestErrorsTotal.Inc()
+		}
+	}()
+
+	// 按限流器等待，避免逐笔定价时触发OKX的429；等待结束后再签名，排队期间时间戳不会过期
+	if o.Limiter != nil {
+		if err := o.Limiter.Wait(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("OKX请求限流等待失败: %w", err)
+		}
 	}
+
 	// 生成时间戳（UTC格式）
 	timestamp := time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
 
 	// 构建签名内容
 	method := "GET"
-	signatureContent := timestamp + method + o.MarketCurrentPath + "?" + reqParams.String()
+	signatureContent := timestamp + method + path + "?" + reqParams.String()
 
 	// 计算HMAC-SHA256签名
 	h := hmac.New(sha256.New, []byte(o.SecretKey))
@@ -265,13 +336,12 @@ func (o OKXClient) GetTokenCurrentPrice(ctx context.Context, mint string) ([]Mar
 	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
 
 	// 构建完整URL
-	fullURL := fmt.Sprintf("%s%s?%s", o.BaseUrl, o.MarketCurrentPath, reqParams.String())
-	fmt.Printf("url== %s\n", fullURL)
+	fullURL := fmt.Sprintf("%s%s?%s", o.BaseUrl, path, reqParams.String())
 
-	// 创建HTTP请求
-	req, err := http.NewRequest(method, fullURL, nil)
+	// 创建HTTP请求，ctx取消或超时时请求立即中止
+	req, err := http.NewRequestWithContext(ctx, method, fullURL, nil)
 	if err != nil {
-		err := fmt.Errorf("OKXApprove创建请求失败", err)
+		err := fmt.Errorf("OKXApprove创建请求失败: %w", err)
 		return nil, err
 	}
 
@@ -283,32 +353,51 @@ func (o OKXClient) GetTokenCurrentPrice(ctx context.Context, mint string) ([]Mar
 	req.Header.Set("Content-Type", "application/json")
 
 	// 发送请求
-	client := &http.Client{}
+	client := o.HTTPClient
+	if client == nil {
+		timeout := o.RequestTimeout
+		if timeout <= 0 {
+			timeout = DefaultOKXRequestTimeout
+		}
+		client = &http.Client{Timeout: timeout}
+	}
 	resp, err := client.Do(req)
 	if err != nil {
-		err := fmt.Errorf("OKXApprove发送请求失败:", err)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		err := fmt.Errorf("OKXApprove发送请求失败: %w", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	// 读取响应内容
-	body, err := ioutil.ReadAll(resp.Body)
+	// 读取响应内容（多读1字节用于判断是否超过上限）
+	maxBytes := o.MaxResponseBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultOKXMaxResponseBytes
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
 	if err != nil {
-		err := fmt.Errorf("OKXApprove读取响应失败", err)
+		err := fmt.Errorf("OKXApprove读取响应失败: %w", err)
 		return nil, err
 	}
+	if int64(len(body)) > maxBytes {
+		return nil, fmt.Errorf("%w: 上限%d字节", ErrOKXResponseTooLarge, maxBytes)
+	}
 	// 1. 解析JSON到MarketResponse
 	var response MarketResponse
 	if err := json.Unmarshal(body, &response); err != nil {
-		log.Fatalf("JSON解析失败: %v", err)
+		return nil, fmt.Errorf("%w: JSON解析失败: %v", ErrOKXInvalidResponse, err)
+	}
+	if response.Code != "0" {
+		return nil, &OKXAPIError{Code: response.Code, Msg: response.Msg}
 	}
 
 	// 2. 将原始数据转换为MarketRecord切片
 	records, err := response.ParseRecords()
 	if err != nil {
-		log.Fatalf("数据转换失败: %v", err)
+		return nil, fmt.Errorf("%w: 数据转换失败: %v", ErrOKXInvalidResponse, err)
 	}
 
-	return records, nil
-
+	return &MarketCandles{Records: records, Raw: &response}, nil
 }
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// InstructionView 可序列化的指令树节点（StackInstructionNode含父节点指针，无法直接输出JSON）
+type InstructionView struct {
+	Index       int                `json:"index"`       // 指令索引，虚拟根节点为-1
+	StackHeight uint64             `json:"stackHeight"` // 栈高度
+	ProgramID   string             `json:"programId"`   // 程序ID（base58）
+	Data        string             `json:"data"`        // 指令数据（base58）
+	Accounts    []string           `json:"accounts"`    // 涉及的账户（base58）
+	Children    []*InstructionView `json:"children,omitempty"`
+}
+
+// ParsedTransaction 离线解析一笔交易的结果
+type ParsedTransaction struct {
+	Signature      string                             `json:"signature"`
+	Slot           uint64                             `json:"slot"`
+	BlockTime      time.Time                          `json:"blockTime"`
+	User           string                             `json:"user"`           // 解析订单所用的用户地址
+	Orders         []Order                            `json:"orders"`         // 解析出的订单
+	BalanceChanges map[string]map[string]*TokenChange `json:"balanceChanges"` // 所有者地址 -> 资产标识(SOL或Mint) -> 变化
+	Instructions   *InstructionView                   `json:"instructions"`   // 指令树
+}
+
+// ParseRawTransaction 解析调用方提供的getTransaction结果，不访问RPC，用于离线分析
+// user为空时使用手续费支付者；mint为空时返回任意代币的订单
+func (s *PnlService) ParseRawTransaction(ctx context.Context, raw *rpc.GetTransactionResult, user, mint string) (*ParsedTransaction, error) {
+	if raw == nil || raw.Transaction == nil || raw.Meta == nil {
+		return nil, errors.New("交易数据不完整: 需要transaction和meta")
+	}
+	parsedTx, err := raw.Transaction.GetTransaction()
+	if err != nil {
+		return nil, fmt.Errorf("解析交易消息失败: %w", err)
+	}
+	accountKeys, err := GetFullAccountKeys(raw)
+	if err != nil {
+		return nil, fmt.Errorf("获取账户列表失败: %w", err)
+	}
+	if user == "" && len(accountKeys) > 0 {
+		user = accountKeys[0].String()
+	}
+
+	tx := &Transaction{Slot: raw.Slot, RawTx: raw}
+	if len(parsedTx.Signatures) > 0 {
+		tx.Signature = parsedTx.Signatures[0].String()
+	}
+	if raw.BlockTime != nil {
+		tx.BlockTime = time.Unix(int64(*raw.BlockTime), 0)
+	}
+
+	_, changes, err := GetBalanceChanges(raw, accountKeys)
+	if err != nil {
+		return nil, fmt.Errorf("解析余额变化失败: %w", err)
+	}
+	tree, err := ParseInstructionTreeByStackHeight(raw)
+	if err != nil {
+		return nil, fmt.Errorf("解析指令树失败: %w", err)
+	}
+	instructions, err := s.instructionView(tree, accountKeys)
+	if err != nil {
+		return nil, err
+	}
+	orders, err := s.fetchOrders(ctx, []*Transaction{tx}, user, mint)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ParsedTransaction{
+		Signature:      tx.Signature,
+		Slot:           tx.Slot,
+		BlockTime:      tx.BlockTime,
+		User:           user,
+		Orders:         orders,
+		BalanceChanges: changes,
+		Instructions:   instructions,
+	}, nil
+}
+
+// instructionView 将指令树转换为可序列化的结构，账户和程序索引替换为地址
+func (s *PnlService) instructionView(root *StackInstructionNode, accountKeys []solana.PublicKey) (*InstructionView, error) {
+	keyAt := func(index uint16) string {
+		if int(index) < len(accountKeys) {
+			return accountKeys[index].String()
+		}
+		return fmt.Sprintf("#%d", index)
+	}
+
+	views := make(map[*StackInstructionNode]*InstructionView)
+	err := walkInstructionTree(root, s.maxTreeDepth, func(node *StackInstructionNode, _ int) bool {
+		view := &InstructionView{
+			Index:       node.Index,
+			StackHeight: node.StackHeight,
+			Data:        solana.Base58(node.Data).String(),
+			Accounts:    make([]string, 0, len(node.Accounts)),
+		}
+		// 虚拟根节点没有程序
+		if node.Index >= 0 {
+			view.ProgramID = keyAt(node.ProgramIDIndex)
+		}
+		for _, account := range node.Accounts {
+			view.Accounts = append(view.Accounts, keyAt(account))
+		}
+		views[node] = view
+		if parent, ok := views[node.Parent]; ok {
+			parent.Children = append(parent.Children, view)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return views[root], nil
+}
@@ -4,26 +4,238 @@ import (
 	"context"
 	"fmt"
 	"github.com/gagliardetto/solana-go/rpc"
-	"math"
-	"strconv"
+	"github.com/shopspring/decimal"
+	"math/big"
+	"sort"
+	"time"
 )
 
 // Position 跟踪持仓状态（新增AverageCost字段记录历史平均成本）
+// 数量和金额用decimal累计，避免大量小额交易的float64误差，只在生成PnLResult时转换为float64
 type Position struct {
-	TotalAmount     float64 // 当前持仓数量（平仓后为0）
-	TotalCostUSD    float64 // 当前持仓成本（平仓后为0）
-	RealizedPnL     float64 // 已实现盈亏
-	TotalInvestment float64 // 该持仓的总投入成本（历史累计，平仓后不变）
-	TotalQuantity   float64 // 该持仓的总数量（历史累计，平仓后不变）
-	AverageCost     float64 // 平均成本（历史值，平仓后保留）
-	Transactions    []Order // 相关交易记录
-	IsClosed        bool    // 是否已平仓
+	TotalAmount     decimal.Decimal // 当前持仓数量（平仓后为0）
+	TotalCostUSD    decimal.Decimal // 当前持仓成本（平仓后为0）
+	RealizedPnL     decimal.Decimal // 已实现盈亏
+	TotalInvestment decimal.Decimal // 该持仓的总投入成本（历史累计，平仓后不变）
+	TotalQuantity   decimal.Decimal // 该持仓的总数量（历史累计，平仓后不变）
+	InitialQuantity decimal.Decimal // 计算前已持有的数量（PnLOptions.InitialQuantity），只有第一个持仓可能非0
+	AverageCost     decimal.Decimal // 平均成本（历史值，平仓后保留）
+	Transactions    []Order         // 相关交易记录
+	IsClosed        bool            // 是否已平仓
+
+	method CostBasisMethod // 卖出时的成本计算方法，为空时按平均成本
+	lots   []*TaxLot       // 剩余买入批次，FIFO卖出时按顺序消耗
+
+	realizedLots []RealizedLotEvent // FIFO卖出与买入批次的匹配记录
+
+	ProbeFilter *ProbeBuyFilterReport // 试探性买入过滤对该持仓的影响，未过滤时为nil
+	legs        []positionLeg         // 每笔交易的数量和美元价值，用于过滤后重算
+}
+
+// UnmatchedSell 没有对应持仓的卖出，成本未知，不计入任何持仓的已实现盈亏
+type UnmatchedSell struct {
+	Signature   string    `json:"signature"`   // 卖出交易签名
+	BlockTime   time.Time `json:"blockTime"`   // 卖出时间
+	Quantity    float64   `json:"quantity"`    // 卖出数量
+	ProceedsUSD float64   `json:"proceedsUSD"` // 卖出收入(USD)
+}
+
+// 交易方向
+const (
+	TradeSideBuy  = "buy"
+	TradeSideSell = "sell"
+)
+
+// TradeDetail 持仓中单笔交易对盈亏的贡献，用于交易日志
+type TradeDetail struct {
+	Signature   string    `json:"signature"`
+	BlockTime   time.Time `json:"blockTime"`
+	Side        string    `json:"side"`        // buy或sell
+	Quantity    float64   `json:"quantity"`    // 目标代币数量
+	ValueUSD    float64   `json:"valueUSD"`    // 买入成本或卖出收入(USD)，已计入手续费
+	PriceUSD    float64   `json:"priceUSD"`    // 成交单价(USD)
+	RealizedPnL float64   `json:"realizedPnL"` // 该笔卖出的已实现盈亏，买入为0
+
+	Events []JupiterSwapEventData `json:"events,omitempty"` // 该笔交易每一跳的swap事件，仅PnLOptions.IncludeEvents时返回
+}
+
+// positionLeg 持仓中一笔交易的数量和美元价值
+type positionLeg struct {
+	order    Order
+	isBuy    bool
+	amount   decimal.Decimal
+	usdValue decimal.Decimal
+	realized decimal.Decimal // 卖出的已实现盈亏
+	initial  bool            // 计算前已持有的初始持仓，没有对应订单
+}
+
+// tradeDetails 按交易顺序列出持仓中每笔交易的明细，初始持仓没有对应交易，不列出；includeEvents时附带swap事件
+func (p *Position) tradeDetails(includeEvents bool) []TradeDetail {
+	var trades []TradeDetail
+	for _, leg := range p.legs {
+		if leg.initial {
+			continue
+		}
+		trade := TradeDetail{
+			Signature:   leg.order.Signature,
+			BlockTime:   leg.order.BlockTime,
+			Side:        TradeSideSell,
+			Quantity:    leg.amount.InexactFloat64(),
+			ValueUSD:    leg.usdValue.InexactFloat64(),
+			RealizedPnL: leg.realized.InexactFloat64(),
+		}
+		if leg.isBuy {
+			trade.Side = TradeSideBuy
+		}
+		if includeEvents {
+			trade.Events = leg.order.Events
+		}
+		if leg.amount.IsPositive() {
+			trade.PriceUSD = leg.usdValue.Div(leg.amount).InexactFloat64()
+		}
+		trades = append(trades, trade)
+	}
+	return trades
+}
+
+// applyInitial 计入计算前已持有的数量和成本，按一笔没有订单的买入处理
+func (p *Position) applyInitial(amount, costUSD decimal.Decimal) {
+	p.TotalAmount = p.TotalAmount.Add(amount)
+	p.TotalCostUSD = p.TotalCostUSD.Add(costUSD)
+	p.TotalInvestment = p.TotalInvestment.Add(costUSD)
+	p.TotalQuantity = p.TotalQuantity.Add(amount)
+	p.InitialQuantity = p.InitialQuantity.Add(amount)
+	p.AverageCost = p.TotalInvestment.Div(p.TotalQuantity)
+	p.lots = append(p.lots, &TaxLot{Quantity: amount, CostUSD: costUSD})
+	p.legs = append(p.legs, positionLeg{isBuy: true, amount: amount, usdValue: costUSD, initial: true})
+}
+
+// initialPosition 按opts生成初始持仓，未指定初始数量时返回nil；costUSD为换算到计价单位后的初始成本
+func initialPosition(opts PnLOptions, costUSD decimal.Decimal, method CostBasisMethod) (*Position, error) {
+	if opts.InitialQuantity < 0 {
+		return nil, fmt.Errorf("初始持仓数量不能为负数")
+	}
+	if opts.InitialCostUSD < 0 {
+		return nil, fmt.Errorf("初始持仓成本不能为负数")
+	}
+	if opts.InitialQuantity == 0 {
+		if opts.InitialCostUSD > 0 {
+			return nil, fmt.Errorf("指定初始持仓成本时初始持仓数量必须大于0")
+		}
+		return nil, nil
+	}
+	pos := &Position{method: method}
+	pos.applyInitial(decimal.NewFromFloat(opts.InitialQuantity), costUSD)
+	return pos, nil
+}
+
+// applyBuy 买入：更新总投入、总数量和平均成本
+func (p *Position) applyBuy(order Order, amount, usdValue decimal.Decimal) {
+	p.TotalAmount = p.TotalAmount.Add(amount)
+	p.TotalCostUSD = p.TotalCostUSD.Add(usdValue)
+	// 累计总投入和总数量（用于计算历史平均成本）
+	p.TotalInvestment = p.TotalInvestment.Add(usdValue)
+	p.TotalQuantity = p.TotalQuantity.Add(amount)
+	// 重新计算平均成本（总投入 / 总数量）
+	if p.TotalQuantity.IsPositive() {
+		p.AverageCost = p.TotalInvestment.Div(p.TotalQuantity)
+	}
+	if amount.IsPositive() {
+		p.lots = append(p.lots, &TaxLot{Signature: order.Signature, AcquiredAt: order.BlockTime, Quantity: amount, CostUSD: usdValue})
+	}
+	p.Transactions = append(p.Transactions, order)
+	p.legs = append(p.legs, positionLeg{order: order, isBuy: true, amount: amount, usdValue: usdValue})
+}
+
+// applySell 卖出：平均成本不变（基于历史总投入和总数量）；FIFO方法见applySellFIFO
+func (p *Position) applySell(order Order, amount, usdValue decimal.Decimal) {
+	if p.method == CostBasisFIFO {
+		p.applySellFIFO(order, amount, usdValue)
+		return
+	}
+
+	// 平均成本使用历史计算值（不随卖出变化）
+	cost := p.averageCostOf(amount)
+
+	// 计算此次卖出的实现盈亏
+	realized := usdValue.Sub(cost)
+	p.RealizedPnL = p.RealizedPnL.Add(realized)
+
+	// 更新当前持仓（仅减少数量和成本，不改变历史总投入/数量）
+	p.TotalAmount = p.TotalAmount.Sub(amount)
+	p.TotalCostUSD = p.TotalCostUSD.Sub(cost)
+	p.Transactions = append(p.Transactions, order)
+	p.legs = append(p.legs, positionLeg{order: order, amount: amount, usdValue: usdValue, realized: realized})
+}
+
+// averageCostOf amount数量按平均成本计算的成本，先乘后除，避免平均成本很小时除法舍入被数量放大
+func (p *Position) averageCostOf(amount decimal.Decimal) decimal.Decimal {
+	if !p.TotalQuantity.IsPositive() {
+		return decimal.Zero
+	}
+	return amount.Mul(p.TotalInvestment).Div(p.TotalQuantity)
+}
+
+// CalculatePnLFromOrders 直接用调用方提供的订单计算目标代币的PnL，不获取和解析交易
+// 供自行解析交易的集成方和测试使用；订单按时间排序后计算，不修改传入的切片
+func (s *PnlService) CalculatePnLFromOrders(ctx context.Context, orders []Order, mint string) ([]PnLResult, error) {
+	sorted := make([]Order, len(orders))
+	copy(sorted, orders)
+	sortOrdersByTime(sorted)
+
+	calc, err := s.calculatePnL(ctx, sorted, mint, PnLOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return calc.Results, nil
+}
+
+// sortOrdersByTime 按时间从旧到新排序，同一时间按slot排序
+func sortOrdersByTime(orders []Order) {
+	sort.SliceStable(orders, func(i, j int) bool {
+		if orders[i].BlockTime.Equal(orders[j].BlockTime) {
+			return orders[i].Slot < orders[j].Slot
+		}
+		return orders[i].BlockTime.Before(orders[j].BlockTime)
+	})
 }
 
 // calculatePnL 计算PnL（修正平均成本和总投资记录逻辑）
-func (s *PnlService) calculatePnL(ctx context.Context, orders []Order, targetMint string) ([]PnLResult, error) {
+func (s *PnlService) calculatePnL(ctx context.Context, orders []Order, targetMint string, opts PnLOptions) (*PnLCalculation, error) {
 	var positions []*Position
 	var currentPosition *Position
+	var warnings []string
+	var unmatched []UnmatchedSell
+
+	prices, err := s.priceProviderFor(opts, targetMint)
+	if err != nil {
+		return nil, err
+	}
+	method, err := s.costBasisMethodFor(opts)
+	if err != nil {
+		return nil, err
+	}
+	if !ValidDenomination(opts.Denomination) {
+		return nil, fmt.Errorf("不支持的计价单位: %s", opts.Denomination)
+	}
+
+	// 以SOL计价时初始持仓成本按第一笔订单时的SOL价格换算，没有订单时按当前时间
+	initialCost := decimal.NewFromFloat(opts.InitialCostUSD)
+	if opts.InitialCostUSD > 0 {
+		at := time.Now()
+		if len(orders) > 0 {
+			at = orders[0].BlockTime
+		}
+		if initialCost, err = denominate(ctx, prices, opts, initialCost, at); err != nil {
+			return nil, err
+		}
+	}
+
+	// 计算前已持有的数量作为第一个持仓的起点，之前没有买入的卖出也会计入该持仓
+	currentPosition, err = initialPosition(opts, initialCost, method)
+	if err != nil {
+		return nil, err
+	}
 
 	for _, order := range orders {
 		isBuy := order.BuyToken.Mint == targetMint
@@ -39,52 +251,84 @@ func (s *PnlService) calculatePnL(ctx context.Context, orders []Order, targetMin
 			return nil, err
 		}
 
-		usdValue, _, err := s.getTokenUSDValue(ctx, order, isBuy, amount)
+		usdValue, pricing, err := s.getTokenUSDValue(ctx, order, isBuy, amount, prices)
 		if err != nil {
-			return nil, err
+			if !opts.SkipUnpriceable {
+				return nil, err
+			}
+			// 只排除无法定价的订单，其余订单继续参与成本计算
+			warnings = append(warnings, fmt.Sprintf("订单 %s 无法定价，已从成本计算中排除: %v", order.Signature, err))
+			continue
+		}
+		order.Pricing = &pricing
+
+		// 粉尘订单不参与计算，避免极端精度代币的小额交易拉偏平均成本
+		if opts.MinUSDValue > 0 && usdValue.LessThan(decimal.NewFromFloat(opts.MinUSDValue)) {
+			s.log(ctx).Info("订单美元价值低于下限，已跳过", "signature", order.Signature, "usdValue", usdValue, "minUsdValue", opts.MinUSDValue)
+			continue
+		}
+
+		// 网络手续费买入时计入成本，卖出时从收入中扣除；手续费无法定价时不影响订单本身
+		if order.Fee > 0 && !opts.IgnoreFees {
+			feeUSD, err := s.feeUSDValue(ctx, order, prices)
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("订单 %s 的手续费无法定价，未计入成本: %v", order.Signature, err))
+			} else if isBuy {
+				usdValue = usdValue.Add(feeUSD)
+			} else {
+				usdValue = usdValue.Sub(feeUSD)
+			}
+		}
+
+		// 以SOL计价时按交易时的SOL价格换算，之后持仓的成本和盈亏都以SOL累计
+		usdValue, err = denominate(ctx, prices, opts, usdValue, order.BlockTime)
+		if err != nil {
+			if !opts.SkipUnpriceable {
+				return nil, err
+			}
+			warnings = append(warnings, fmt.Sprintf("订单 %s 无法按SOL计价，已从成本计算中排除: %v", order.Signature, err))
+			continue
 		}
 
 		// 初始化新持仓（如果当前没有持仓且是买入操作）
 		if currentPosition == nil && isBuy {
 			currentPosition = &Position{
-				TotalAmount:     0,
-				TotalCostUSD:    0,
-				RealizedPnL:     0,
-				TotalInvestment: 0, // 总投入成本（累计）
-				TotalQuantity:   0, // 总数量（累计）
-				AverageCost:     0, // 平均成本（初始为0）
-				IsClosed:        false,
+				IsClosed: false,
+				method:   method,
 			}
 		}
 
 		// 处理买入：更新总投入、总数量和平均成本
 		if isBuy && currentPosition != nil {
-			currentPosition.TotalAmount += amount
-			currentPosition.TotalCostUSD += usdValue
-			// 累计总投入和总数量（用于计算历史平均成本）
-			currentPosition.TotalInvestment += usdValue
-			currentPosition.TotalQuantity += amount
-			// 重新计算平均成本（总投入 / 总数量）
-			currentPosition.AverageCost = currentPosition.TotalInvestment / currentPosition.TotalQuantity
-			currentPosition.Transactions = append(currentPosition.Transactions, order)
+			currentPosition.applyBuy(order, amount, usdValue)
+		}
+
+		// 没有持仓时的卖出（查询窗口之前买入或通过转账、空投获得）没有成本依据，单独记录收入；
+		// 终身模型平仓后、下一次买入前的卖出同样没有持仓
+		if isSell && (currentPosition == nil || !currentPosition.TotalAmount.IsPositive()) {
+			unmatched = append(unmatched, UnmatchedSell{
+				Signature:   order.Signature,
+				BlockTime:   order.BlockTime,
+				Quantity:    amount.InexactFloat64(),
+				ProceedsUSD: usdValue.InexactFloat64(),
+			})
+			warnings = append(warnings, fmt.Sprintf("订单 %s 卖出前没有买入记录，收入未计入已实现盈亏，可通过initialQuantity指定初始持仓", order.Signature))
+			continue
 		}
 
 		// 处理卖出：平均成本不变（基于历史总投入和总数量）
 		if isSell && currentPosition != nil {
-			// 平均成本使用历史计算值（不随卖出变化）
-			averageCost := currentPosition.AverageCost
-
-			// 计算此次卖出的实现盈亏
-			realized := usdValue - (amount * averageCost)
-			currentPosition.RealizedPnL += realized
-
-			// 更新当前持仓（仅减少数量和成本，不改变历史总投入/数量）
-			currentPosition.TotalAmount -= amount
-			currentPosition.TotalCostUSD -= amount * averageCost
-			currentPosition.Transactions = append(currentPosition.Transactions, order)
+			currentPosition.applySell(order, amount, usdValue)
 
 			// 如果持仓数量为0，标记为已平仓并添加到持仓列表
-			if currentPosition.TotalAmount <= 0 {
+			if !currentPosition.TotalAmount.IsPositive() {
+				if opts.PositionModel == PositionModelLifetime {
+					// 终身模型：持仓归零但保留累计成本，之后的买入继续计入同一持仓
+					currentPosition.TotalAmount = decimal.Zero
+					currentPosition.TotalCostUSD = decimal.Zero
+					currentPosition.lots = nil
+					continue
+				}
 				currentPosition.IsClosed = true
 				positions = append(positions, currentPosition)
 				currentPosition = nil
@@ -94,15 +338,34 @@ func (s *PnlService) calculatePnL(ctx context.Context, orders []Order, targetMin
 
 	// 添加最后未平仓的持仓
 	if currentPosition != nil {
+		// 终身模型下最后一笔卖出后持仓为0即视为已平仓
+		if opts.PositionModel == PositionModelLifetime && !currentPosition.TotalAmount.IsPositive() {
+			currentPosition.IsClosed = true
+		}
 		positions = append(positions, currentPosition)
 	}
 
+	// 忽略主建仓前的小额试探性买入
+	if s.probeBuyFraction > 0 {
+		for i, pos := range positions {
+			positions[i] = filterProbeBuys(pos, s.probeBuyFraction)
+		}
+	}
+
 	// 计算每个持仓的PnL结果
-	return s.calculatePositionPnL(ctx, positions, targetMint)
+	results, err := s.calculatePositionPnL(ctx, positions, targetMint, prices, opts)
+	if err != nil {
+		return nil, err
+	}
+	var realizedLots []RealizedLotEvent
+	for _, pos := range positions {
+		realizedLots = append(realizedLots, pos.realizedLots...)
+	}
+	return &PnLCalculation{Results: results, Warnings: warnings, UnmatchedSells: unmatched, RealizedLots: realizedLots}, nil
 }
 
 // 辅助函数：解析代币数量（改用Amount和Decimals计算，更可靠）
-func parseTokenAmount(order Order, isBuy bool) (float64, error) {
+func parseTokenAmount(order Order, isBuy bool) (decimal.Decimal, error) {
 	var tokenAmount rpc.UiTokenAmount
 	if isBuy {
 		tokenAmount = order.BuyToken.UiTokenAmount
@@ -111,20 +374,25 @@ func parseTokenAmount(order Order, isBuy bool) (float64, error) {
 	}
 
 	// 用原始Amount和Decimals计算实际数量（避免UiAmountString的格式问题）
-	amountInt, err := strconv.ParseInt(tokenAmount.Amount, 10, 64)
-	if err != nil {
-		return 0, fmt.Errorf("解析数量失败: %w", err)
+	// 大供应量代币的原始数量可能超过int64，用big.Int解析后按精度移位
+	raw, ok := new(big.Int).SetString(tokenAmount.Amount, 10)
+	if !ok {
+		return decimal.Zero, fmt.Errorf("解析数量失败: %q不是合法的整数", tokenAmount.Amount)
 	}
-	decimals := float64(tokenAmount.Decimals)
-	return float64(amountInt) / math.Pow(10, decimals), nil
+	return decimal.NewFromBigInt(raw, -int32(tokenAmount.Decimals)), nil
 }
 
 // calculatePositionPnL 计算每个持仓的PnL结果（修正百分比计算和格式）
-func (s *PnlService) calculatePositionPnL(ctx context.Context, positions []*Position, targetMint string) ([]PnLResult, error) {
+func (s *PnlService) calculatePositionPnL(ctx context.Context, positions []*Position, targetMint string, prices PriceProvider, opts PnLOptions) ([]PnLResult, error) {
 	var results []PnLResult
 
 	// 获取当前代币价格（用于计算未实现盈亏）
-	currentPrice, err := s.getCurrentTokenPrice(ctx, targetMint)
+	currentPrice, err := prices.CurrentPrice(ctx, targetMint)
+	if err != nil {
+		return nil, err
+	}
+	// 持仓成本已换算为计价单位，当前市值同样按当前价格换算
+	denominator, err := currentDenominator(ctx, prices, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -135,29 +403,67 @@ func (s *PnlService) calculatePositionPnL(ctx context.Context, positions []*Posi
 
 		// 已实现盈亏百分比：（已实现盈亏 / 总投入成本）* 100（保留两位小数）
 		var pnlPercentage float64
-		if pos.TotalInvestment > 0 {
-			pnlPercentage = (pos.RealizedPnL / pos.TotalInvestment) * 100
+		if pos.TotalInvestment.IsPositive() {
+			pnlPercentage = pos.RealizedPnL.Div(pos.TotalInvestment).Mul(decimal.NewFromInt(100)).InexactFloat64()
 		}
 
 		// 已实现盈亏值：保留两位小数
 		profitLossValue := truncateToDecimals(pos.RealizedPnL, 10)
 
 		// 未实现盈亏：持仓中按当前价格计算，平仓后为0（保留两位小数）
-		var unrealizedProfitLossValue float64
+		var unrealizedProfitLossValue, roundedUnrealized float64
 		if !pos.IsClosed {
-			unrealized := pos.TotalAmount*currentPrice - pos.TotalCostUSD
+			unrealized := pos.TotalAmount.Mul(decimal.NewFromFloat(currentPrice.Price)).Div(decimal.NewFromFloat(denominator)).Sub(pos.TotalCostUSD)
 			unrealizedProfitLossValue = truncateToDecimals(unrealized, 2)
+			roundedUnrealized = roundToDecimals(unrealized, 2)
 		} else {
 			unrealizedProfitLossValue = 0 // 平仓后无未实现盈亏
 		}
 
-		// 格式化结果
+		// 格式化结果：主字段为截断值（兼容旧行为），Rounded为相同小数位的四舍五入值
 		result := PnLResult{
 			AverageCost:               averageCost,
 			ProfitLossPercentage:      fmt.Sprintf("%.2f%%", pnlPercentage),
 			ProfitLossValue:           profitLossValue,
 			UnrealizedProfitLossValue: unrealizedProfitLossValue,
 			IsClosed:                  pos.IsClosed,
+			TotalInvestment:           pos.TotalInvestment.InexactFloat64(),
+			InitialQuantity:           pos.InitialQuantity.InexactFloat64(),
+			ProbeFilter:               pos.ProbeFilter,
+			Trades:                    pos.tradeDetails(opts.IncludeEvents),
+			Rounded: &RoundedPnL{
+				AverageCost:               roundToDecimals(pos.AverageCost, 9),
+				ProfitLossValue:           roundToDecimals(pos.RealizedPnL, 10),
+				UnrealizedProfitLossValue: roundedUnrealized,
+			},
+		}
+
+		if !pos.IsClosed {
+			result.CurrentPrice = &currentPrice
+		}
+
+		for _, order := range pos.Transactions {
+			for _, warning := range order.Warnings {
+				result.Warnings = append(result.Warnings, order.Signature+": "+warning)
+			}
+			if order.Pricing != nil {
+				result.Pricing = append(result.Pricing, OrderPricing{Signature: order.Signature, BlockTime: order.BlockTime, PriceInfo: *order.Pricing})
+			}
+		}
+
+		// 持有时长：首笔交易到最后一笔交易（持仓中则到当前时间）
+		if len(pos.Transactions) > 0 {
+			result.OpenedAt = pos.Transactions[0].BlockTime
+			result.LastTradeAt = pos.Transactions[len(pos.Transactions)-1].BlockTime
+			end := time.Now()
+			if pos.IsClosed {
+				end = result.LastTradeAt
+			}
+			holding := end.Sub(result.OpenedAt)
+			result.HoldingSeconds = int64(holding.Seconds())
+			if pos.IsClosed {
+				result.Annualized = AnnualizeReturn(pos.RealizedPnL.InexactFloat64(), pos.TotalInvestment.InexactFloat64(), holding)
+			}
 		}
 
 		results = append(results, result)
@@ -166,12 +472,18 @@ func (s *PnlService) calculatePositionPnL(ctx context.Context, positions []*Posi
 	return results, nil
 }
 
-// 辅助函数：截断到指定小数位（不四舍五入）
-func truncateToDecimals(value float64, decimals int) float64 {
-	if decimals <= 0 {
-		return math.Trunc(value)
+// 辅助函数：截断到指定小数位（不四舍五入），转换为float64用于响应
+func truncateToDecimals(value decimal.Decimal, decimals int32) float64 {
+	if decimals < 0 {
+		decimals = 0
 	}
+	return value.Truncate(decimals).InexactFloat64()
+}
 
-	shift := math.Pow(10, float64(decimals))
-	return math.Trunc(value*shift) / shift
+// 辅助函数：四舍五入到指定小数位，转换为float64用于响应
+func roundToDecimals(value decimal.Decimal, decimals int32) float64 {
+	if decimals < 0 {
+		decimals = 0
+	}
+	return value.Round(decimals).InexactFloat64()
 }
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// 价格缓存默认参数
+const (
+	DefaultPriceCacheTTL        = time.Hour   // 按秒区分的K线价格不会变化，过期只为限制内存占用
+	DefaultCurrentPriceCacheTTL = time.Second // 当前价格按代币缓存，超过该时间重新查询
+	DefaultPriceCacheSweepSize  = 10000       // 条目数达到该值后清理过期条目，清理后阈值调整为剩余条目数的两倍
+)
+
+// priceCacheKey 价格缓存key：代币 + 截断到秒的查询时间，当前价格每个代币只有一个条目（unix为0）
+type priceCacheKey struct {
+	mint    string
+	unix    int64
+	current bool
+}
+
+// cachedPriceEntry 缓存的价格查询结果
+type cachedPriceEntry struct {
+	price     PriceInfo
+	fetchedAt time.Time
+}
+
+// priceCache 缓存默认价格提供方的查询结果，同一代币同一秒的多次定价（如成交均价和手续费都用SOL价格）只请求一次
+type priceCache struct {
+	mu      sync.Mutex
+	entries map[priceCacheKey]cachedPriceEntry
+	ttl     time.Duration // <=0表示不缓存
+	sweepAt int           // 条目数达到该值时清理过期条目
+}
+
+func newPriceCache(ttl time.Duration) *priceCache {
+	return &priceCache{
+		entries: make(map[priceCacheKey]cachedPriceEntry),
+		ttl:     ttl,
+		sweepAt: DefaultPriceCacheSweepSize,
+	}
+}
+
+// ttlFor 条目的缓存时间，当前价格不超过DefaultCurrentPriceCacheTTL
+func (c *priceCache) ttlFor(key priceCacheKey) time.Duration {
+	if key.current && c.ttl > DefaultCurrentPriceCacheTTL {
+		return DefaultCurrentPriceCacheTTL
+	}
+	return c.ttl
+}
+
+func (c *priceCache) get(key priceCacheKey) (PriceInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return PriceInfo{}, false
+	}
+	if time.Since(entry.fetchedAt) > c.ttlFor(key) {
+		delete(c.entries, key)
+		return PriceInfo{}, false
+	}
+	return entry.price, true
+}
+
+func (c *priceCache) set(key priceCacheKey, price PriceInfo) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	// 条目数达到阈值时清理过期条目，避免长时间运行后map只增不减；
+	// 阈值随剩余条目数翻倍，未过期条目很多时不会每次写入都遍历整个map
+	if len(c.entries) >= c.sweepAt {
+		for k, entry := range c.entries {
+			if now.Sub(entry.fetchedAt) > c.ttlFor(k) {
+				delete(c.entries, k)
+			}
+		}
+		c.sweepAt = max(DefaultPriceCacheSweepSize, 2*len(c.entries))
+	}
+	c.entries[key] = cachedPriceEntry{price: price, fetchedAt: now}
+}
+
+// WithPriceCacheTTL 设置价格查询结果的缓存时间，<=0时不缓存
+func WithPriceCacheTTL(ttl time.Duration) Option {
+	return func(s *PnlService) {
+		s.prices.ttl = ttl
+	}
+}
+
+// cachedPrice 按代币和截断到秒的时间缓存fetch的结果，当前价格只按代币缓存；查询失败不缓存
+func (s *PnlService) cachedPrice(mint string, timestamp time.Time, current bool, fetch func(time.Time) (PriceInfo, error)) (PriceInfo, error) {
+	key := priceCacheKey{mint: mint, unix: timestamp.Unix(), current: current}
+	if current {
+		key.unix = 0
+	}
+	if price, ok := s.prices.get(key); ok {
+		return price, nil
+	}
+
+	price, err := fetch(time.Unix(timestamp.Unix(), 0))
+	if err != nil {
+		return PriceInfo{}, err
+	}
+	s.prices.set(key, price)
+	return price, nil
+}
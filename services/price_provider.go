@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// 可在单次请求中指定的价格提供方
+const (
+	PriceProviderOKX     = "okx"     // OKX K线（默认）
+	PriceProviderJupiter = "jupiter" // Jupiter Price API，只有实时价格，历史查询也返回当前价格
+	PriceProviderFixed   = "fixed"   // 目标代币的所有查询返回同一个固定价格，用于回测
+)
+
+// DefaultJupiterPriceURL Jupiter Price API地址
+const DefaultJupiterPriceURL = "https://api.jup.ag/price/v2"
+
+// Jupiter请求默认限制
+const (
+	DefaultJupiterMaxResponseBytes = 1 << 20 // 响应体最大1MB
+	DefaultJupiterRequestTimeout   = 10 * time.Second
+)
+
+// PriceProvider 历史价格和当前价格的来源，稳定币报价腿不经过价格提供方
+// 没有价格数据时应返回包装ErrNoPriceData的错误，以便计算时区分缺失价格和请求失败
+type PriceProvider interface {
+	HistoricalPrice(ctx context.Context, mint string, timestamp time.Time) (PriceInfo, error)
+	CurrentPrice(ctx context.Context, mint string) (PriceInfo, error)
+}
+
+// WithPriceProvider 设置默认价格提供方，替换OKX；查询结果同样经过价格缓存和当前价格后台刷新
+func WithPriceProvider(provider PriceProvider) Option {
+	return func(s *PnlService) {
+		if provider != nil {
+			s.priceSource = provider
+		}
+	}
+}
+
+// WithJupiterPriceURL 设置Jupiter Price API地址，为空时使用DefaultJupiterPriceURL
+func WithJupiterPriceURL(url string) Option {
+	return func(s *PnlService) {
+		if url != "" {
+			s.jupiterPriceURL = url
+		}
+	}
+}
+
+// priceProviderFor 按opts选择本次计算使用的价格提供方，未指定时使用默认价格提供方
+// fixed只对targetMint生效，其他代币（手续费和报价腿的SOL等）仍使用默认价格提供方
+func (s *PnlService) priceProviderFor(opts PnLOptions, targetMint string) (PriceProvider, error) {
+	switch opts.PriceProvider {
+	case "":
+		return defaultPriceProvider{s: s}, nil
+	case PriceProviderOKX:
+		// 默认价格提供方被替换时，显式指定okx直接查询OKX，不经过缓存
+		if _, ok := s.priceSource.(OKXClient); !ok {
+			return s.okxMarketClient, nil
+		}
+		return defaultPriceProvider{s: s}, nil
+	case PriceProviderJupiter:
+		return jupiterPriceProvider{baseURL: s.jupiterPriceURL}, nil
+	case PriceProviderFixed:
+		if opts.FixedPrice <= 0 {
+			return nil, fmt.Errorf("固定价格必须大于0")
+		}
+		return fixedPriceProvider{mint: targetMint, price: opts.FixedPrice, fallback: defaultPriceProvider{s: s}}, nil
+	default:
+		return nil, fmt.Errorf("不支持的价格提供方: %s", opts.PriceProvider)
+	}
+}
+
+// defaultPriceProvider 服务的默认价格提供方（默认OKX）加上价格缓存，当前价格优先使用后台刷新的缓存
+type defaultPriceProvider struct {
+	s *PnlService
+}
+
+func (p defaultPriceProvider) HistoricalPrice(ctx context.Context, mint string, timestamp time.Time) (PriceInfo, error) {
+	return p.s.getHistoricalTokenPrice(ctx, mint, timestamp)
+}
+
+func (p defaultPriceProvider) CurrentPrice(ctx context.Context, mint string) (PriceInfo, error) {
+	return p.s.getCurrentTokenPrice(ctx, mint)
+}
+
+// HistoricalPrice 实现PriceProvider：时间窗口包含timestamp的K线收盘价
+// 从HistoricalBar开始查询，没有K线或最近的K线不包含timestamp时回退到更粗的粒度；
+// 所有粒度都不包含时使用最细粒度上timestamp之前最近的K线
+func (o OKXClient) HistoricalPrice(ctx context.Context, mint string, timestamp time.Time) (PriceInfo, error) {
+	// after返回早于该时间的K线，加1毫秒使开始时间恰好等于timestamp的K线也被返回
+	after := strconv.FormatInt(timestamp.UnixMilli()+1, 10)
+	var nearest *MarketRecord
+	for _, bar := range o.historicalBars() {
+		candles, err := o.GetCandles(ctx, OKXTokenPriceRequest{TokenContractAddress: mint, After: after, Bar: bar, Limit: "1"})
+		if err != nil {
+			return PriceInfo{}, err
+		}
+		if len(candles.Records) == 0 {
+			continue
+		}
+		latest := candles.Records[0]
+		if timestamp.Before(latest.Timestamp.Add(okxBarDurations[bar])) {
+			return PriceInfo{Source: PriceSourceHistorical, Provider: PriceProviderOKX, Price: latest.Close, CandleTime: &latest.Timestamp}, nil
+		}
+		if nearest == nil {
+			nearest = &latest
+		}
+	}
+	if nearest == nil {
+		return PriceInfo{}, noPriceDataError(mint, timestamp)
+	}
+	return PriceInfo{Source: PriceSourceHistorical, Provider: PriceProviderOKX, Price: nearest.Close, CandleTime: &nearest.Timestamp}, nil
+}
+
+// CurrentPrice 实现PriceProvider：查询当前时间之前最近的1s K线
+func (o OKXClient) CurrentPrice(ctx context.Context, mint string) (PriceInfo, error) {
+	price, err := o.HistoricalPrice(ctx, mint, time.Now())
+	price.Source = PriceSourceCurrent
+	return price, err
+}
+
+// fixedPriceProvider mint在所有时间都返回同一个价格，其他代币查询fallback
+type fixedPriceProvider struct {
+	mint     string
+	price    float64
+	fallback PriceProvider
+}
+
+func (p fixedPriceProvider) HistoricalPrice(ctx context.Context, mint string, timestamp time.Time) (PriceInfo, error) {
+	if mint != p.mint {
+		return p.fallback.HistoricalPrice(ctx, mint, timestamp)
+	}
+	return PriceInfo{Source: PriceSourceHistorical, Provider: PriceProviderFixed, Price: p.price}, nil
+}
+
+func (p fixedPriceProvider) CurrentPrice(ctx context.Context, mint string) (PriceInfo, error) {
+	if mint != p.mint {
+		return p.fallback.CurrentPrice(ctx, mint)
+	}
+	return PriceInfo{Source: PriceSourceCurrent, Provider: PriceProviderFixed, Price: p.price}, nil
+}
+
+// jupiterPriceProvider Jupiter Price API只提供实时价格，历史查询同样返回当前价格并标记为current
+type jupiterPriceProvider struct {
+	baseURL string
+}
+
+// jupiterPriceResponse Jupiter Price API v2响应，未知代币的data项为null
+type jupiterPriceResponse struct {
+	Data map[string]*struct {
+		ID    string `json:"id"`
+		Price string `json:"price"`
+	} `json:"data"`
+}
+
+func (p jupiterPriceProvider) HistoricalPrice(ctx context.Context, mint string, _ time.Time) (PriceInfo, error) {
+	return p.CurrentPrice(ctx, mint)
+}
+
+func (p jupiterPriceProvider) CurrentPrice(ctx context.Context, mint string) (PriceInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"?ids="+url.QueryEscape(mint), nil)
+	if err != nil {
+		return PriceInfo{}, fmt.Errorf("创建Jupiter价格请求失败: %w", err)
+	}
+	client := &http.Client{Timeout: DefaultJupiterRequestTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return PriceInfo{}, fmt.Errorf("请求Jupiter价格失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return PriceInfo{}, fmt.Errorf("Jupiter价格接口返回状态码%d", resp.StatusCode)
+	}
+
+	var body jupiterPriceResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, DefaultJupiterMaxResponseBytes)).Decode(&body); err != nil {
+		return PriceInfo{}, fmt.Errorf("解析Jupiter价格失败: %w", err)
+	}
+	entry := body.Data[mint]
+	if entry == nil {
+		return PriceInfo{}, fmt.Errorf("Jupiter没有代币 %s 的价格", mint)
+	}
+	price, err := strconv.ParseFloat(entry.Price, 64)
+	if err != nil {
+		return PriceInfo{}, fmt.Errorf("解析Jupiter价格失败: %w", err)
+	}
+	return PriceInfo{Source: PriceSourceCurrent, Provider: PriceProviderJupiter, Price: price}, nil
+}
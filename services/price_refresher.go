@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// cachedPrice 缓存的当前价格
+type cachedPrice struct {
+	price     PriceInfo
+	updatedAt time.Time
+}
+
+// currentPriceCache 当前价格缓存，由后台刷新任务定期更新
+type currentPriceCache struct {
+	mu     sync.RWMutex
+	prices map[string]cachedPrice
+	maxAge time.Duration // 超过该时长的价格视为过期
+}
+
+func newCurrentPriceCache() *currentPriceCache {
+	return &currentPriceCache{
+		prices: make(map[string]cachedPrice),
+	}
+}
+
+func (c *currentPriceCache) get(mint string) (PriceInfo, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, ok := c.prices[mint]
+	if !ok || c.maxAge <= 0 || time.Since(entry.updatedAt) > c.maxAge {
+		return PriceInfo{}, false
+	}
+	return entry.price, true
+}
+
+func (c *currentPriceCache) set(mint string, price PriceInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.prices[mint] = cachedPrice{price: price, updatedAt: time.Now()}
+}
+
+// StartPriceRefresher 启动后台任务，按interval定期刷新watchlist中代币的当前价格
+// PnL请求计算未实现盈亏时优先读取缓存价格，避免每次请求都调用OKX；ctx取消时退出
+func (s *PnlService) StartPriceRefresher(ctx context.Context, watchlist []string, interval time.Duration) {
+	if interval <= 0 || len(watchlist) == 0 {
+		return
+	}
+	// 允许错过一次刷新，超过两个周期仍未更新则回退到实时查询
+	s.currentPrices.mu.Lock()
+	s.currentPrices.maxAge = 2 * interval
+	s.currentPrices.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		s.refreshCurrentPrices(ctx, watchlist)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.refreshCurrentPrices(ctx, watchlist)
+			}
+		}
+	}()
+}
+
+// refreshCurrentPrices 刷新一轮watchlist价格，单个代币失败不影响其他代币
+func (s *PnlService) refreshCurrentPrices(ctx context.Context, watchlist []string) {
+	for _, mint := range watchlist {
+		price, err := s.fetchCurrentTokenPrice(ctx, mint)
+		if err != nil {
+			s.log(ctx).Warn("刷新代币当前价格失败", "mint", mint, "error", err)
+			continue
+		}
+		s.currentPrices.set(mint, price)
+	}
+}
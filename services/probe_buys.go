@@ -0,0 +1,67 @@
+package services
+
+import "github.com/shopspring/decimal"
+
+// ProbeBuyFilterReport 试探性买入过滤对持仓结果的影响
+type ProbeBuyFilterReport struct {
+	IgnoredBuys           int      `json:"ignoredBuys"`           // 被忽略的买入笔数
+	IgnoredSignatures     []string `json:"ignoredSignatures"`     // 被忽略的买入交易签名
+	IgnoredQuantity       float64  `json:"ignoredQuantity"`       // 被忽略的买入数量
+	IgnoredCostUSD        float64  `json:"ignoredCostUSD"`        // 被忽略的买入成本(USD)
+	UnfilteredAverageCost float64  `json:"unfilteredAverageCost"` // 不过滤时的平均成本
+	UnfilteredRealizedPnL float64  `json:"unfilteredRealizedPnL"` // 不过滤时的已实现盈亏
+}
+
+// filterProbeBuys 忽略持仓中最大一笔买入之前、数量小于最大买入fraction倍的买入，
+// 用剩余交易重新计算持仓，使平均成本反映真正的建仓价格；没有需要忽略的买入时原样返回
+func filterProbeBuys(pos *Position, fraction float64) *Position {
+	largest := -1
+	for i, leg := range pos.legs {
+		if leg.isBuy && (largest < 0 || leg.amount.GreaterThan(pos.legs[largest].amount)) {
+			largest = i
+		}
+	}
+	if largest < 0 {
+		return pos
+	}
+
+	threshold := pos.legs[largest].amount.Mul(decimal.NewFromFloat(fraction))
+	filtered := &Position{IsClosed: pos.IsClosed, method: pos.method}
+	report := &ProbeBuyFilterReport{
+		UnfilteredAverageCost: pos.AverageCost.InexactFloat64(),
+		UnfilteredRealizedPnL: pos.RealizedPnL.InexactFloat64(),
+	}
+	var ignoredQuantity, ignoredCost decimal.Decimal
+	for i, leg := range pos.legs {
+		// 初始持仓不是试探性买入，始终保留
+		if leg.initial {
+			filtered.applyInitial(leg.amount, leg.usdValue)
+			continue
+		}
+		if leg.isBuy && i < largest && leg.amount.LessThan(threshold) {
+			report.IgnoredBuys++
+			report.IgnoredSignatures = append(report.IgnoredSignatures, leg.order.Signature)
+			ignoredQuantity = ignoredQuantity.Add(leg.amount)
+			ignoredCost = ignoredCost.Add(leg.usdValue)
+			continue
+		}
+		if leg.isBuy {
+			filtered.applyBuy(leg.order, leg.amount, leg.usdValue)
+		} else {
+			filtered.applySell(leg.order, leg.amount, leg.usdValue)
+		}
+	}
+	if report.IgnoredBuys == 0 {
+		return pos
+	}
+	report.IgnoredQuantity = ignoredQuantity.InexactFloat64()
+	report.IgnoredCostUSD = ignoredCost.InexactFloat64()
+
+	// 已平仓持仓中被忽略的数量也已卖出，剩余数量按0处理
+	if filtered.IsClosed || filtered.TotalAmount.IsNegative() {
+		filtered.TotalAmount = decimal.Zero
+		filtered.TotalCostUSD = decimal.Zero
+	}
+	filtered.ProbeFilter = report
+	return filtered
+}
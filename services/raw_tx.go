@@ -0,0 +1,52 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// NewTransactionResultFromRaw 由base64编码的原始交易和meta JSON构造GetTransactionResult
+// 便于不经过PnlService，直接使用GetFullAccountKeys、ParseInstructionTreeByStackHeight等解析函数
+// blockTime为0时表示时间未知
+func NewTransactionResultFromRaw(txBase64 string, metaJSON []byte, slot uint64, blockTime int64) (*rpc.GetTransactionResult, error) {
+	if _, err := base64.StdEncoding.DecodeString(txBase64); err != nil {
+		return nil, fmt.Errorf("交易base64解码失败: %w", err)
+	}
+
+	// 按getTransaction(encoding=base64)的返回格式解析交易数据
+	envelope := new(rpc.TransactionResultEnvelope)
+	envelopeJSON, err := json.Marshal([]string{txBase64, string(solana.EncodingBase64)})
+	if err != nil {
+		return nil, err
+	}
+	if err := envelope.UnmarshalJSON(envelopeJSON); err != nil {
+		return nil, fmt.Errorf("解析交易数据失败: %w", err)
+	}
+	if _, err := envelope.GetTransaction(); err != nil {
+		return nil, fmt.Errorf("解析交易消息失败: %w", err)
+	}
+
+	result := &rpc.GetTransactionResult{
+		Slot:        slot,
+		Transaction: envelope,
+	}
+
+	if len(metaJSON) > 0 {
+		meta := new(rpc.TransactionMeta)
+		if err := json.Unmarshal(metaJSON, meta); err != nil {
+			return nil, fmt.Errorf("解析交易meta失败: %w", err)
+		}
+		result.Meta = meta
+	}
+
+	if blockTime != 0 {
+		bt := solana.UnixTimeSeconds(blockTime)
+		result.BlockTime = &bt
+	}
+
+	return result, nil
+}
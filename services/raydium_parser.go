@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// RaydiumAMMProgramID Raydium AMM v4程序ID
+var RaydiumAMMProgramID = solana.MustPublicKeyFromBase58("675kPX9MHTjS2zt1qfr1NYHuzeLXfQM9H24wFSUt1Mp8")
+
+// Raydium AMM v4 swap指令的首字节，之后是两个u64参数（输入数量和输出数量的限制）
+const (
+	raydiumSwapBaseIn    = 9
+	raydiumSwapBaseOut   = 11
+	raydiumSwapBaseInV2  = 16 // 不含OpenBook市场账户的精简版本
+	raydiumSwapBaseOutV2 = 17
+)
+
+// SPL Token转账指令的首字节
+const (
+	tokenTransfer        = 3  // 账户：源、目标、授权
+	tokenTransferChecked = 12 // 账户：源、mint、目标、授权
+)
+
+// isRaydiumSwap 指令数据为Raydium AMM v4的swap指令
+func isRaydiumSwap(data []byte) bool {
+	if len(data) < 17 {
+		return false
+	}
+	switch data[0] {
+	case raydiumSwapBaseIn, raydiumSwapBaseOut, raydiumSwapBaseInV2, raydiumSwapBaseOutV2:
+		return true
+	}
+	return false
+}
+
+// tokenTransferOf 解析SPL Token转账指令的源账户、目标账户和数量，不是转账指令时ok为false
+func tokenTransferOf(node *StackInstructionNode, keys []solana.PublicKey) (source, destination solana.PublicKey, amount uint64, ok bool) {
+	if int(node.ProgramIDIndex) >= len(keys) || len(node.Data) < 9 {
+		return
+	}
+	program := keys[node.ProgramIDIndex]
+	if !program.Equals(solana.TokenProgramID) && !program.Equals(solana.Token2022ProgramID) {
+		return
+	}
+	var srcIdx, dstIdx int
+	switch node.Data[0] {
+	case tokenTransfer:
+		srcIdx, dstIdx = 0, 1
+	case tokenTransferChecked:
+		srcIdx, dstIdx = 0, 2
+	default:
+		return
+	}
+	if dstIdx >= len(node.Accounts) || int(node.Accounts[srcIdx]) >= len(keys) || int(node.Accounts[dstIdx]) >= len(keys) {
+		return
+	}
+	return keys[node.Accounts[srcIdx]], keys[node.Accounts[dstIdx]], binary.LittleEndian.Uint64(node.Data[1:9]), true
+}
+
+// hasAncestorProgram 判断节点是否由programID的指令直接或间接调用
+func hasAncestorProgram(node *StackInstructionNode, keys []solana.PublicKey, programID solana.PublicKey) bool {
+	for parent := node.Parent; parent != nil; parent = parent.Parent {
+		if parent.Index >= 0 && int(parent.ProgramIDIndex) < len(keys) && keys[parent.ProgramIDIndex].Equals(programID) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRaydiumOrders 由Raydium swap指令构造订单，每个swap对应一个订单
+// swap指令最后三个账户为用户的源代币账户、目标代币账户和所有者；数量取swap内部的两笔代币转账，
+// mint取池子一侧金库账户的代币余额记录（用户的wSOL临时账户在交易内创建并关闭，没有余额记录）
+// 由Jupiter route调用的swap已计入Jupiter订单，所有者不是user的swap不属于该用户，都跳过
+func (s *PnlService) parseRaydiumOrders(ctx context.Context, tx *Transaction, user, mint string, swaps []*StackInstructionNode, keys []solana.PublicKey, tokenMap map[string]*TokenInfo) ([]Order, error) {
+	var orders []Order
+	for _, swap := range swaps {
+		n := len(swap.Accounts)
+		if n < 3 || int(swap.Accounts[n-1]) >= len(keys) || int(swap.Accounts[n-2]) >= len(keys) || int(swap.Accounts[n-3]) >= len(keys) {
+			continue
+		}
+		if hasAncestorProgram(swap, keys, s.jupiterPID) {
+			continue
+		}
+		userSource, userDest, owner := keys[swap.Accounts[n-3]], keys[swap.Accounts[n-2]], keys[swap.Accounts[n-1]]
+		if owner.String() != user {
+			continue
+		}
+
+		var amountIn, amountOut uint64
+		var poolIn, poolOut solana.PublicKey
+		for _, child := range swap.Children {
+			source, destination, amount, ok := tokenTransferOf(child, keys)
+			if !ok {
+				continue
+			}
+			if source.Equals(userSource) {
+				amountIn, poolIn = amount, destination
+			} else if destination.Equals(userDest) {
+				amountOut, poolOut = amount, source
+			}
+		}
+		inInfo, outInfo := tokenMap[poolIn.String()], tokenMap[poolOut.String()]
+		if amountIn == 0 || amountOut == 0 || inInfo == nil || outInfo == nil {
+			s.log(ctx).Debug("Raydium swap缺少转账或代币信息，跳过", "signature", tx.Signature, "index", swap.Index)
+			continue
+		}
+
+		inMint, err1 := solana.PublicKeyFromBase58(inInfo.Mint)
+		outMint, err2 := solana.PublicKeyFromBase58(outInfo.Mint)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		sellTokenMint, buyTokenMint := eventMint(inMint), eventMint(outMint)
+		if mint != "" && sellTokenMint != mint && buyTokenMint != mint {
+			continue
+		}
+
+		order := Order{
+			Source:    OrderSourceRaydium,
+			Signature: tx.Signature,
+			Slot:      tx.Slot,
+			BlockTime: tx.BlockTime,
+			SellToken: eventOrderTokenInfo(sellTokenMint, amountIn, inInfo.Decimals),
+			BuyToken:  eventOrderTokenInfo(buyTokenMint, amountOut, outInfo.Decimals),
+		}
+		if s.isNegligibleOrder(order) {
+			continue
+		}
+		// 以swap的转账数量作为单个事件，用于计算成交均价和调试输出
+		var amm solana.PublicKey
+		if int(swap.Accounts[1]) < len(keys) {
+			amm = keys[swap.Accounts[1]]
+		}
+		order.Events = []JupiterSwapEventData{{Amm: amm, InputMint: inMint, InputAmount: amountIn, OutputMint: outMint, OutputAmount: amountOut}}
+		s.setOrderQuote(&order, mint)
+		if mint != "" {
+			order.Execution = s.executionPrice(order.Events, order, mint)
+		}
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gagliardetto/solana-go/rpc/jsonrpc"
+)
+
+// RPC重试默认参数
+const (
+	DefaultRPCMaxAttempts    = 4                      // 包含第一次请求
+	DefaultRPCRetryBaseDelay = 200 * time.Millisecond // 第一次重试前的等待时间，之后每次翻倍
+)
+
+// 节点暂时无法提供数据的JSON-RPC错误码
+const (
+	rpcBlockNotAvailable       = -32004 // 区块尚不可用
+	rpcNodeUnhealthy           = -32005 // 节点落后
+	rpcBlockStatusNotAvailable = -32014 // 区块状态尚不可用
+)
+
+// WithRPCRetry 设置单笔交易请求的最大尝试次数和初始退避时间，<=0时使用默认值；maxAttempts为1表示不重试
+func WithRPCRetry(maxAttempts int, baseDelay time.Duration) Option {
+	return func(s *PnlService) {
+		if maxAttempts > 0 {
+			s.rpcMaxAttempts = maxAttempts
+		}
+		if baseDelay > 0 {
+			s.rpcRetryBaseDelay = baseDelay
+		}
+	}
+}
+
+// retryRPC 执行fn，遇到限流、超时、节点落后等暂时性错误时按指数退避加随机抖动重试，
+// 等待期间ctx取消则立即返回ctx的错误；非暂时性错误直接返回
+func (s *PnlService) retryRPC(ctx context.Context, fn func() error) error {
+	delay := s.rpcRetryBaseDelay
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || attempt >= s.rpcMaxAttempts || !isTransientRPCError(err) {
+			return err
+		}
+
+		// 在[delay/2, delay)之间随机等待，避免并发请求同时重试
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// isTransientRPCError 判断RPC错误是否可能在重试后恢复：HTTP 429/5xx、网络超时和节点暂时无法提供数据
+func isTransientRPCError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var httpErr *jsonrpc.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Code == http.StatusTooManyRequests || httpErr.Code >= http.StatusInternalServerError
+	}
+	var rpcErr *jsonrpc.RPCError
+	if errors.As(err, &rpcErr) {
+		switch rpcErr.Code {
+		case rpcBlockNotAvailable, rpcNodeUnhealthy, rpcBlockStatusNotAvailable:
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,83 @@
+package services
+
+// RoundTrip 同一代币在相近slot内一买一卖的往返交易，可能是被夹（sandwich）造成的
+type RoundTrip struct {
+	Mint             string `json:"mint"`             // 往返交易的代币
+	OpenSignature    string `json:"openSignature"`    // 先发生的一笔交易签名
+	CloseSignature   string `json:"closeSignature"`   // 后发生的反向交易签名
+	OpenSlot         uint64 `json:"openSlot"`         // 先发生交易的slot
+	CloseSlot        uint64 `json:"closeSlot"`        // 反向交易的slot
+	OpenedWithBuy    bool   `json:"openedWithBuy"`    // 先买后卖为true，先卖后买为false
+	SlotDistance     uint64 `json:"slotDistance"`     // 两笔交易间隔的slot数
+	OpenQuoteAmount  string `json:"openQuoteAmount"`  // 先发生交易的报价腿数量
+	CloseQuoteAmount string `json:"closeQuoteAmount"` // 反向交易的报价腿数量
+
+	// 两笔订单在检测时订单切片中的位置；同一交易可能有多个route订单，排除时按订单而不是签名
+	openIndex, closeIndex int
+}
+
+// detectRoundTrips 在按时间排序的订单中查找目标代币间隔不超过window个slot的反向交易对，
+// 每笔订单最多属于一个往返；window为0时不检测
+func detectRoundTrips(orders []Order, mint string, window uint64) []RoundTrip {
+	if window == 0 {
+		return nil
+	}
+
+	var roundTrips []RoundTrip
+	paired := make([]bool, len(orders))
+	for i, open := range orders {
+		openIsBuy := open.BuyToken.Mint == mint
+		if paired[i] || (!openIsBuy && open.SellToken.Mint != mint) {
+			continue
+		}
+		for j := i + 1; j < len(orders); j++ {
+			closing := orders[j]
+			if closing.Slot > open.Slot+window {
+				break
+			}
+			if paired[j] {
+				continue
+			}
+			// 反向交易：先买则找卖出，先卖则找买入
+			if (openIsBuy && closing.SellToken.Mint != mint) || (!openIsBuy && closing.BuyToken.Mint != mint) {
+				continue
+			}
+			paired[i], paired[j] = true, true
+			roundTrips = append(roundTrips, RoundTrip{
+				Mint:             mint,
+				OpenSignature:    open.Signature,
+				CloseSignature:   closing.Signature,
+				OpenSlot:         open.Slot,
+				CloseSlot:        closing.Slot,
+				OpenedWithBuy:    openIsBuy,
+				SlotDistance:     closing.Slot - open.Slot,
+				OpenQuoteAmount:  open.QuoteAmount,
+				CloseQuoteAmount: closing.QuoteAmount,
+				openIndex:        i,
+				closeIndex:       j,
+			})
+			break
+		}
+	}
+	return roundTrips
+}
+
+// excludeRoundTrips 移除属于往返交易的订单，roundTrips须由detectRoundTrips在同一orders上检测得到；
+// 同一交易中不属于往返的其他route订单保留
+func excludeRoundTrips(orders []Order, roundTrips []RoundTrip) []Order {
+	if len(roundTrips) == 0 {
+		return orders
+	}
+	flagged := make([]bool, len(orders))
+	for _, rt := range roundTrips {
+		flagged[rt.openIndex] = true
+		flagged[rt.closeIndex] = true
+	}
+	kept := make([]Order, 0, len(orders))
+	for i, order := range orders {
+		if !flagged[i] {
+			kept = append(kept, order)
+		}
+	}
+	return kept
+}
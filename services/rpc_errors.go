@@ -0,0 +1,69 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gagliardetto/solana-go/rpc/jsonrpc"
+)
+
+// jsonRPCMethodNotFound JSON-RPC规范中方法不存在的错误码
+const jsonRPCMethodNotFound = -32601
+
+// RequiredRPCMethods 服务依赖的Solana RPC方法
+var RequiredRPCMethods = []string{
+	"getSignaturesForAddress", // 分页获取用户交易签名
+	"getTransaction",          // 获取交易详情（需支持maxSupportedTransactionVersion）
+	"getAccountInfo",          // 代币列表未覆盖时查询代币精度
+}
+
+// UnsupportedRPCMethodError RPC节点不支持服务依赖的方法（部分精简/专用节点不提供getSignaturesForAddress等历史查询）
+type UnsupportedRPCMethodError struct {
+	Method string
+	Err    error
+}
+
+func (e *UnsupportedRPCMethodError) Error() string {
+	return fmt.Sprintf("RPC节点不支持%s方法，请改用提供完整历史查询的全节点或归档节点: %v", e.Method, e.Err)
+}
+
+func (e *UnsupportedRPCMethodError) Unwrap() error {
+	return e.Err
+}
+
+// wrapUnsupportedMethod 若err表示节点不支持method，包装为UnsupportedRPCMethodError，否则原样返回
+func wrapUnsupportedMethod(method string, err error) error {
+	if isMethodNotSupported(err) {
+		return &UnsupportedRPCMethodError{Method: method, Err: err}
+	}
+	return err
+}
+
+// isMethodNotSupported 判断RPC错误是否为方法不存在/不支持，不同服务商的错误码和提示不完全一致
+func isMethodNotSupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	var message string
+	var rpcErr *jsonrpc.RPCError
+	var httpErr *jsonrpc.HTTPError
+	switch {
+	case errors.As(err, &rpcErr):
+		if rpcErr.Code == jsonRPCMethodNotFound {
+			return true
+		}
+		message = rpcErr.Message
+	case errors.As(err, &httpErr):
+		message = httpErr.Error()
+	default:
+		return false
+	}
+	message = strings.ToLower(message)
+	for _, hint := range []string{"method not found", "not supported", "not available", "unsupported method"} {
+		if strings.Contains(message, hint) {
+			return true
+		}
+	}
+	return false
+}
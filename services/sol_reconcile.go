@@ -0,0 +1,56 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// TokenAccountRentLamports 创建/关闭一个代币账户（如临时wSOL账户、ATA）引起的租金变化
+const TokenAccountRentLamports = 2039280
+
+// WithSOLReconciliation 启用SOL腿核对：余额变化推导的SOL数量（扣除手续费后）与事件中的SOL数量
+// 相差超过tolerance比例加一个代币账户租金时，在订单上记录警告，tolerance<=0表示不核对
+func WithSOLReconciliation(tolerance float64) Option {
+	return func(s *PnlService) {
+		if tolerance > 0 {
+			s.solTolerance = tolerance
+		}
+	}
+}
+
+// reconcileSOLLeg 核对订单SOL腿：paid为true表示用户支付SOL（卖出腿），否则为收到SOL（买入腿）
+// 用户SOL余额变化包含手续费，支付时减去、收到时加回后再与事件数量比较
+func (s *PnlService) reconcileSOLLeg(ctx context.Context, order *Order, fee, eventLamports uint64, paid bool) {
+	if s.solTolerance <= 0 {
+		return
+	}
+
+	leg := order.BuyToken
+	if paid {
+		leg = order.SellToken
+	}
+	balanceLamports, err := strconv.ParseUint(leg.UiTokenAmount.Amount, 10, 64)
+	if err != nil {
+		return
+	}
+
+	adjusted := float64(balanceLamports) + float64(fee)
+	if paid {
+		adjusted = float64(balanceLamports) - float64(fee)
+	}
+	diff := math.Abs(adjusted - float64(eventLamports))
+	allowance := s.solTolerance*float64(eventLamports) + TokenAccountRentLamports
+	if diff <= allowance {
+		return
+	}
+
+	warning := fmt.Sprintf("SOL余额变化(扣除手续费后%s SOL)与事件数量(%s SOL)相差%s SOL，超出容差，可能存在解析错误",
+		formatTokenAmount(strconv.FormatFloat(adjusted, 'f', 0, 64), 9),
+		formatTokenAmount(strconv.FormatUint(eventLamports, 10), 9),
+		formatTokenAmount(strconv.FormatFloat(diff, 'f', 0, 64), 9),
+	)
+	order.Warnings = append(order.Warnings, warning)
+	s.log(ctx).Warn("SOL余额变化与事件数量不一致", "signature", order.Signature, "warning", warning)
+}
@@ -2,12 +2,17 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/gagliardetto/solana-go"
 	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/gagliardetto/solana-go/rpc/jsonrpc"
+	"golang.org/x/sync/singleflight"
 )
 
 // JupiterTransaction 存储与Jupiter相关的交易关键信息
@@ -19,75 +24,373 @@ type Transaction struct {
 }
 
 type PnlService struct {
-	rpcClient       *rpc.Client
-	jupiterPID      solana.PublicKey // Jupiter程序ID
-	okxMarketClient OKXClient
-	batchSize       int                     // 批量查询大小（建议50-100）
-	concurrency     int                     // 并发数（批量接口不可用时使用）
-	useBatchAPI     bool                    // 是否使用批量交易查询API
-	cache           map[string]*Transaction // 交易缓存
-	cacheMutex      sync.RWMutex
+	rpcClient         *rpc.Client
+	jupiterPID        solana.PublicKey // Jupiter程序ID
+	okxMarketClient   OKXClient
+	batchSize         int                          // 批量查询大小（建议50-100）
+	concurrency       int                          // 同时进行的getTransaction请求上限
+	rpcMaxAttempts    int                          // 单笔getTransaction的最大尝试次数
+	rpcRetryBaseDelay time.Duration                // 第一次重试前的等待时间
+	useBatchAPI       bool                         // 是否使用批量交易查询API
+	cache             Cache                        // 交易缓存，key包含确认级别
+	cacheSize         int                          // 进程内交易缓存的最大条目数
+	nonJupiter        *signatureMemo               // 已确认不调用Jupiter和Raydium的交易签名，不再获取
+	confirmedTTL      time.Duration                // 非finalized交易的缓存时间
+	inflight          map[string]*transactionFetch // 正在获取中的交易签名，避免并发请求重复获取同一笔交易
+	inflightMutex     sync.Mutex
+	rangeFetches      singleflight.Group      // 按钱包、limit和时间范围合并并发的交易列表获取
+	zeroEpsilon       float64                 // 买卖两边变化量均不超过该值的订单视为无效（自路由/失败腿）
+	currentPrices     *currentPriceCache      // 后台刷新的当前价格缓存
+	priceSource       PriceProvider           // 默认价格提供方，默认为OKX
+	prices            *priceCache             // 默认价格提供方的查询结果缓存
+	signatureBudget   time.Duration           // 签名分页查询的最长耗时，0表示不限制
+	stablecoins       map[string]struct{}     // 按1:1美元计价的稳定币mint
+	decimals          *decimalsCache          // mint -> decimals缓存
+	tokenList         *tokenList              // 代币列表（symbol、名称、精度）
+	metadataSource    MetadataProvider        // 代币元数据提供方，默认为代币列表
+	metadata          *metadataCache          // 查到的代币元数据
+	maxTreeDepth      int                     // 指令树最大深度，超过则跳过该交易
+	jupiterDiscs      discriminatorSet        // 识别Jupiter route指令和swap事件的discriminator
+	txFetch           TransactionFetchOptions // getTransaction请求参数
+	probeBuyFraction  float64                 // 小于最大买入该比例的建仓前买入视为试探性买入并忽略，0表示不过滤
+	maxRPCCalls       int                     // 单次请求预计RPC调用上限，0表示不限制
+	maxOKXCalls       int                     // 单次请求预计OKX调用上限，0表示不限制
+	solTolerance      float64                 // SOL腿核对的相对容差，0表示不核对
+
+	mintDiscovery        *mintDiscoveryCache // 按钱包缓存已扫描交易中买卖过的代币
+	discoveryConcurrency int                 // 代币发现的并发数
+	discoveryBatchSize   int                 // 代币发现每批交易数量
+	jupiterPriceURL      string              // Jupiter Price API地址，请求指定jupiter价格时使用
+	costBasis            CostBasisMethod     // 请求未指定时使用的成本计算方法
+	logger               *slog.Logger        // 日志输出，默认slog.Default()
+}
+
+// TransactionFetchOptions getTransaction的可配置请求参数，不同RPC服务商对编码和版本的支持不同
+type TransactionFetchOptions struct {
+	Encoding                       solana.EncodingType // 返回编码，为空时使用节点默认(json)
+	Commitment                     rpc.CommitmentType  // 确认级别，签名列表请求使用同一级别
+	MaxSupportedTransactionVersion *uint64             // 支持的最大交易版本，nil时只返回legacy交易
+}
+
+// DefaultTransactionFetchOptions 默认请求参数：finalized确认级别，支持v0交易
+func DefaultTransactionFetchOptions() TransactionFetchOptions {
+	maxVersion := uint64(0)
+	return TransactionFetchOptions{
+		Commitment:                     rpc.CommitmentFinalized,
+		MaxSupportedTransactionVersion: &maxVersion,
+	}
+}
+
+// DefaultRPCConcurrency 默认同时进行的getTransaction请求上限
+const DefaultRPCConcurrency = 16
+
+// DefaultConfirmedCacheTTL 非finalized交易默认缓存时间，confirmed交易仍可能变化或被丢弃
+const DefaultConfirmedCacheTTL = 30 * time.Second
+
+// transactionFetch 一次进行中的交易获取，其他请求同一签名的goroutine等待done后直接使用结果
+type transactionFetch struct {
+	signature solana.Signature
+	done      chan struct{}
+	tx        *Transaction
+	err       error
+}
+
+// transactionCacheKey 缓存key由确认级别和签名组成，避免confirmed结果被返回给要求finalized的调用方
+func transactionCacheKey(commitment rpc.CommitmentType, signature string) string {
+	return string(commitment) + ":" + signature
+}
+
+// Option PnlService可选配置
+type Option func(*PnlService)
+
+// WithZeroChangeEpsilon 设置零余额变化阈值，两边变化量均不超过该值的订单会被忽略
+func WithZeroChangeEpsilon(epsilon float64) Option {
+	return func(s *PnlService) {
+		if epsilon >= 0 {
+			s.zeroEpsilon = epsilon
+		}
+	}
+}
+
+// WithRPCConcurrency 设置同时进行的getTransaction请求上限，<=0时使用DefaultRPCConcurrency
+func WithRPCConcurrency(n int) Option {
+	return func(s *PnlService) {
+		if n > 0 {
+			s.concurrency = n
+		}
+	}
+}
+
+// WithBatchAPI 设置是否以JSON-RPC批量请求获取交易，每批最多batchSize笔；
+// 批量请求失败时回退为并发单笔请求
+func WithBatchAPI(enabled bool) Option {
+	return func(s *PnlService) {
+		s.useBatchAPI = enabled
+	}
+}
+
+// WithSignatureFetchBudget 设置签名分页查询的最长耗时，超时后返回已获取的部分签名并标记为截断
+func WithSignatureFetchBudget(budget time.Duration) Option {
+	return func(s *PnlService) {
+		if budget > 0 {
+			s.signatureBudget = budget
+		}
+	}
+}
+
+// WithStablecoins 设置按1:1美元计价的稳定币mint列表，覆盖DefaultStablecoinMints
+func WithStablecoins(mints []string) Option {
+	return func(s *PnlService) {
+		if len(mints) == 0 {
+			return
+		}
+		s.stablecoins = make(map[string]struct{}, len(mints))
+		for _, mint := range mints {
+			s.stablecoins[mint] = struct{}{}
+		}
+	}
+}
+
+// WithMaxInstructionDepth 设置指令树最大深度，<=0表示不限制
+func WithMaxInstructionDepth(depth int) Option {
+	return func(s *PnlService) {
+		s.maxTreeDepth = depth
+	}
+}
+
+// WithTransactionFetchOptions 设置getTransaction请求参数，未设置的字段使用默认值
+func WithTransactionFetchOptions(opts TransactionFetchOptions) Option {
+	return func(s *PnlService) {
+		if opts.Encoding != "" {
+			s.txFetch.Encoding = opts.Encoding
+		}
+		if opts.Commitment != "" {
+			s.txFetch.Commitment = opts.Commitment
+		}
+		if opts.MaxSupportedTransactionVersion != nil {
+			s.txFetch.MaxSupportedTransactionVersion = opts.MaxSupportedTransactionVersion
+		}
+	}
+}
+
+// WithCommitment 设置签名列表和交易请求的确认级别，默认finalized；confirmed更快但有小概率被回滚
+// 节点的这两个方法只支持confirmed和finalized，其他值忽略
+func WithCommitment(commitment rpc.CommitmentType) Option {
+	return func(s *PnlService) {
+		if commitment == rpc.CommitmentConfirmed || commitment == rpc.CommitmentFinalized {
+			s.txFetch.Commitment = commitment
+		}
+	}
+}
+
+// WithProbeBuyFraction 忽略最大一笔买入之前、数量小于其fraction倍的试探性买入，fraction需在(0,1)之间
+func WithProbeBuyFraction(fraction float64) Option {
+	return func(s *PnlService) {
+		if fraction > 0 && fraction < 1 {
+			s.probeBuyFraction = fraction
+		}
+	}
+}
+
+// WithConfirmedCacheTTL 设置非finalized交易的缓存时间，<=0时不缓存非finalized交易
+func WithConfirmedCacheTTL(ttl time.Duration) Option {
+	return func(s *PnlService) {
+		s.confirmedTTL = ttl
+	}
+}
+
+// getTransactionOpts 构造getTransaction请求参数，所有获取交易的调用统一使用
+func (s *PnlService) getTransactionOpts() *rpc.GetTransactionOpts {
+	return &rpc.GetTransactionOpts{
+		Encoding:                       s.txFetch.Encoding,
+		Commitment:                     s.txFetch.Commitment,
+		MaxSupportedTransactionVersion: s.txFetch.MaxSupportedTransactionVersion,
+	}
 }
 
 // NewPnlService 创建新的Solana服务实例
-func NewPnlService(rpcURL string, jupiterProgramID string, config OKXClient) (*PnlService, error) {
+func NewPnlService(rpcURL string, jupiterProgramID string, config OKXClient, opts ...Option) (*PnlService, error) {
 	pid, _ := solana.PublicKeyFromBase58(jupiterProgramID)
 
-	cache := make(map[string]*Transaction)
+	s := &PnlService{
+		rpcClient:         rpc.New(rpcURL),
+		jupiterPID:        pid,
+		okxMarketClient:   config,
+		batchSize:         50,
+		concurrency:       DefaultRPCConcurrency,
+		rpcMaxAttempts:    DefaultRPCMaxAttempts,
+		rpcRetryBaseDelay: DefaultRPCRetryBaseDelay,
+		cacheSize:         DefaultTransactionCacheSize,
+		nonJupiter:        newSignatureMemo(DefaultNonJupiterMemoSize),
+		confirmedTTL:      DefaultConfirmedCacheTTL,
+		inflight:          make(map[string]*transactionFetch),
+		currentPrices:     newCurrentPriceCache(),
+		priceSource:       config,
+		prices:            newPriceCache(DefaultPriceCacheTTL),
+		decimals:          newDecimalsCache(),
+		tokenList:         newTokenList(),
+		metadata:          newMetadataCache(),
+		maxTreeDepth:      DefaultMaxInstructionDepth,
+		jupiterDiscs:      newDiscriminatorSet(DefaultJupiterDiscriminators()),
+		txFetch:           DefaultTransactionFetchOptions(),
+
+		mintDiscovery:        newMintDiscoveryCache(),
+		discoveryConcurrency: DefaultMintDiscoveryConcurrency,
+		discoveryBatchSize:   DefaultMintDiscoveryBatchSize,
+		jupiterPriceURL:      DefaultJupiterPriceURL,
+		costBasis:            CostBasisAverage,
+		logger:               slog.Default(),
+	}
+	WithStablecoins(DefaultStablecoinMints)(s)
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.cache == nil {
+		s.cache = NewMemoryCache(s.cacheSize)
+	}
+	if s.metadataSource == nil {
+		s.metadataSource = tokenListMetadataProvider{list: s.tokenList}
+	}
 
-	return &PnlService{
-		rpcClient:       rpc.New(rpcURL),
-		jupiterPID:      pid,
-		okxMarketClient: config,
-		batchSize:       50,
-		concurrency:     100,
-		cache:           cache,
-	}, nil
+	return s, nil
 }
 
 // GetJupiterTransactions 获取用户与Jupiter交互的交易（包含关键信息）
-func (s *PnlService) GetTransactions(ctx context.Context, userAddress string, limit int) ([]*Transaction, error) {
-	signatures, err := s.getPaginatedSignatures(ctx, userAddress, limit)
+// truncated为true表示签名分页查询超出耗时预算，返回的只是部分交易
+func (s *PnlService) GetTransactions(ctx context.Context, userAddress string, limit int) (transactions []*Transaction, truncated bool, err error) {
+	return s.GetTransactionsInRange(ctx, userAddress, limit, TimeRange{})
+}
+
+// TimeRange 交易时间范围，零值表示该方向不限制
+type TimeRange struct {
+	Start time.Time // 不早于该时间（包含）
+	End   time.Time // 不晚于该时间（包含）
+
+	// Until 客户端已同步到的签名，只获取比它更新的交易（不包含该签名），用于增量同步；零值表示不限制
+	Until solana.Signature
+}
+
+// contains 判断时间是否在范围内
+func (r TimeRange) contains(t time.Time) bool {
+	return (r.Start.IsZero() || !t.Before(r.Start)) && (r.End.IsZero() || !t.After(r.End))
+}
+
+// GetTransactionsInRange 获取时间范围内最多limit笔交易，签名分页越过Start或到达Until后停止
+func (s *PnlService) GetTransactionsInRange(ctx context.Context, userAddress string, limit int, timeRange TimeRange) (transactions []*Transaction, truncated bool, err error) {
+	// 预计成本超过上限时在发起任何调用前拒绝
+	if err := s.checkCostCap(limit); err != nil {
+		return nil, false, err
+	}
+
+	// 同一钱包、limit和时间范围的并发请求（如看板轮询）共用一次签名查询和交易获取
+	key := fmt.Sprintf("%s|%d|%d|%d|%s", userAddress, limit, timeRange.Start.Unix(), timeRange.End.Unix(), timeRange.Until)
+	for {
+		ch := s.rangeFetches.DoChan(key, func() (interface{}, error) {
+			transactions, truncated, err := s.fetchTransactionsInRange(ctx, userAddress, limit, timeRange)
+			return rangeFetchResult{transactions: transactions, truncated: truncated}, err
+		})
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case res := <-ch:
+			// 发起获取的请求被取消时其余请求重新获取，而不是返回别人的取消错误
+			if res.Err != nil && res.Shared && ctx.Err() == nil && (errors.Is(res.Err, context.Canceled) || errors.Is(res.Err, context.DeadlineExceeded)) {
+				continue
+			}
+			if res.Err != nil {
+				return nil, false, res.Err
+			}
+			// 结果由多个请求共享，返回切片副本，调用方可以各自过滤和排序
+			result := res.Val.(rangeFetchResult)
+			return append([]*Transaction(nil), result.transactions...), result.truncated, nil
+		}
+	}
+}
+
+// rangeFetchResult 一次交易列表获取的结果，在合并的并发请求间共享
+type rangeFetchResult struct {
+	transactions []*Transaction
+	truncated    bool
+}
+
+// fetchTransactionsInRange 获取时间范围内最多limit笔交易，GetTransactionsInRange合并并发请求后调用
+func (s *PnlService) fetchTransactionsInRange(ctx context.Context, userAddress string, limit int, timeRange TimeRange) (transactions []*Transaction, truncated bool, err error) {
+	signatures, truncated, err := s.getPaginatedSignatures(ctx, userAddress, limit, timeRange)
 	if err != nil {
-		return nil, fmt.Errorf("获取交易签名失败: %w", err)
+		return nil, false, fmt.Errorf("获取交易签名失败: %w", err)
 	}
+	signatures = s.skipNonDEXSignatures(signatures)
 	if len(signatures) == 0 {
-		return nil, nil
+		return nil, truncated, nil
 	}
 
 	// 2. 批量获取交易详情（核心优化点）
-	transactions, err := s.getBatchTransactions(ctx, signatures)
+	transactions, err = s.getBatchTransactions(ctx, signatures)
 	if err != nil {
-		return nil, fmt.Errorf("批量获取交易失败: %w", err)
+		return nil, false, fmt.Errorf("批量获取交易失败: %w", err)
 	}
+	// 只有调用Jupiter或Raydium的交易可能产生订单，其余交易记下签名，之后不再获取
+	transactions = s.keepDEXTransactions(transactions)
 
 	// 按时间排序交易
 	sortTransactionsByTime(transactions)
 
-	return transactions, nil
+	return transactions, truncated, nil
+}
+
+// ExcludeSignatures 过滤掉指定签名的交易，用于在解析订单前排除已知有问题或重复的交易
+func ExcludeSignatures(txList []*Transaction, signatures []string) []*Transaction {
+	if len(signatures) == 0 {
+		return txList
+	}
+	excluded := make(map[string]struct{}, len(signatures))
+	for _, sig := range signatures {
+		excluded[sig] = struct{}{}
+	}
+
+	filtered := make([]*Transaction, 0, len(txList))
+	for _, tx := range txList {
+		if _, ok := excluded[tx.Signature]; ok {
+			continue
+		}
+		filtered = append(filtered, tx)
+	}
+	return filtered
+}
+
+// LatestSlotAndTime 返回交易列表中最新的slot和区块时间，用于描述数据新鲜度
+func LatestSlotAndTime(txList []*Transaction) (uint64, time.Time) {
+	var latestSlot uint64
+	var latestTime time.Time
+	for _, tx := range txList {
+		if tx == nil {
+			continue
+		}
+		if tx.Slot > latestSlot {
+			latestSlot = tx.Slot
+		}
+		if tx.BlockTime.After(latestTime) {
+			latestTime = tx.BlockTime
+		}
+	}
+	return latestSlot, latestTime
 }
 
 // 按时间排序交易
 func sortTransactionsByTime(txs []*Transaction) {
-	for i := 0; i < len(txs); i++ {
-		for j := i + 1; j < len(txs); j++ {
-			if txs[j].BlockTime.Before(txs[i].BlockTime) {
-				txs[i], txs[j] = txs[j], txs[i]
-			} else if txs[j].BlockTime.Equal(txs[i].BlockTime) && txs[j].Slot < txs[i].Slot {
-				// 时间相同则按slot排序
-				txs[i], txs[j] = txs[j], txs[i]
-			}
+	sort.SliceStable(txs, func(i, j int) bool {
+		if !txs[i].BlockTime.Equal(txs[j].BlockTime) {
+			return txs[i].BlockTime.Before(txs[j].BlockTime)
 		}
-	}
+		// 时间相同则按slot排序
+		return txs[i].Slot < txs[j].Slot
+	})
 }
 
 // checkAndExtractJupiterTx 验证是否为Jupiter交易，并提取关键信息
 func (s *PnlService) getTransactions(ctx context.Context, signature solana.Signature) (*Transaction, bool, error) {
-	maxVersion := uint64(0)
 	// 获取原始交易数据
-	rawTx, err := s.rpcClient.GetTransaction(ctx, signature, &rpc.GetTransactionOpts{
-		MaxSupportedTransactionVersion: &maxVersion,
-	})
+	rawTx, err := s.rpcClient.GetTransaction(ctx, signature, s.getTransactionOpts())
 	if err != nil {
 		return nil, false, fmt.Errorf("获取交易详情失败: %w", err)
 	}
@@ -111,17 +414,35 @@ func (s *PnlService) getTransactions(ctx context.Context, signature solana.Signa
 	return txInfo, true, nil
 }
 
-func (s *PnlService) getPaginatedSignatures(ctx context.Context, user string, limit int) ([]solana.Signature, error) {
+// getPaginatedSignatures 分页获取签名，配置了耗时预算时超时即停止并返回已获取的签名（truncated=true）
+// 签名按时间倒序返回：晚于timeRange.End的签名跳过，早于timeRange.Start时停止分页；没有blockTime的签名保留
+func (s *PnlService) getPaginatedSignatures(ctx context.Context, user string, limit int, timeRange TimeRange) ([]solana.Signature, bool, error) {
 	userAddr, err := solana.PublicKeyFromBase58(user)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+
+	pageCtx := ctx
+	if s.signatureBudget > 0 {
+		var cancel context.CancelFunc
+		pageCtx, cancel = context.WithTimeout(ctx, s.signatureBudget)
+		defer cancel()
 	}
 
 	var allSignatures []solana.Signature
 	var before solana.Signature
 	pageSize := s.batchSize
+	truncated := false
+
+	reachedStart := false
+
+	for len(allSignatures) < limit && !reachedStart {
+		// 超出耗时预算（而非调用方取消）时返回已获取的部分
+		if pageCtx.Err() != nil && ctx.Err() == nil {
+			truncated = true
+			break
+		}
 
-	for len(allSignatures) < limit {
 		// 计算当前页需要的数量
 		remaining := limit - len(allSignatures)
 		if pageSize > remaining {
@@ -129,17 +450,23 @@ func (s *PnlService) getPaginatedSignatures(ctx context.Context, user string, li
 		}
 
 		// 获取一页签名
+		rpcRequestsTotal.WithLabelValues("getSignaturesForAddress").Inc()
 		sigs, err := s.rpcClient.GetSignaturesForAddressWithOpts(
-			ctx,
+			pageCtx,
 			userAddr,
 			&rpc.GetSignaturesForAddressOpts{
 				Limit:      &pageSize,
 				Before:     before,
-				Commitment: rpc.CommitmentFinalized,
+				Until:      timeRange.Until, // 节点只返回比Until更新的签名，到达后该页不满，分页随之结束
+				Commitment: s.txFetch.Commitment,
 			},
 		)
 		if err != nil {
-			return nil, err
+			if pageCtx.Err() != nil && ctx.Err() == nil {
+				truncated = true
+				break
+			}
+			return nil, false, wrapUnsupportedMethod("getSignaturesForAddress", err)
 		}
 		if len(sigs) == 0 {
 			break // 没有更多签名
@@ -147,7 +474,17 @@ func (s *PnlService) getPaginatedSignatures(ctx context.Context, user string, li
 
 		// 提取签名
 		for _, sig := range sigs {
+			if sig.BlockTime != nil && !timeRange.contains(sig.BlockTime.Time()) {
+				if !timeRange.Start.IsZero() && sig.BlockTime.Time().Before(timeRange.Start) {
+					reachedStart = true
+					break
+				}
+				continue
+			}
 			allSignatures = append(allSignatures, sig.Signature)
+			if len(allSignatures) == limit {
+				break
+			}
 		}
 
 		// 准备下一页
@@ -157,71 +494,219 @@ func (s *PnlService) getPaginatedSignatures(ctx context.Context, user string, li
 		}
 	}
 
-	return allSignatures, nil
+	return allSignatures, truncated, nil
 }
 
 func (s *PnlService) getBatchTransactions(ctx context.Context, signatures []solana.Signature) ([]*Transaction, error) {
 	// 先查缓存
-	cached, remaining := s.getCachedTransactions(signatures)
+	cached, remaining := s.getCachedTransactions(ctx, signatures)
 	if len(remaining) == 0 {
+		txCacheHitsTotal.Add(float64(len(cached)))
 		return cached, nil
 	}
-	var newTransactions []*Transaction
-	var err error
 
-	newTransactions, err = s.concurrentGetTransactions(ctx, remaining)
-	if err != nil {
-		return nil, err
+	// 其他请求正在获取的签名只等待结果，其余由本次请求获取
+	owned, recached, waiting := s.claimFetches(ctx, remaining)
+	cached = append(cached, recached...)
+	// 登记时会再查一次缓存，按最终结果计数，每个签名只计一次
+	txCacheHitsTotal.Add(float64(len(cached)))
+	txCacheMissesTotal.Add(float64(len(owned) + len(waiting)))
+
+	var newTransactions []*Transaction
+	if len(owned) > 0 {
+		fetched, err := s.fetchTransactions(ctx, owned)
+		if err == nil {
+			// 缓存结果
+			s.cacheTransactions(ctx, fetched)
+		}
+		s.finishFetches(owned, fetched, err)
+		if err != nil {
+			return nil, err
+		}
+		newTransactions = fetched
 	}
 
-	// 缓存结果
-	s.cacheTransactions(newTransactions)
+	// 负责获取的请求被取消或超时时，本次请求的ctx仍有效则重新登记并获取这些签名
+	var retry []solana.Signature
+	for _, fetch := range waiting {
+		select {
+		case <-fetch.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if fetch.err != nil && ctx.Err() == nil && (errors.Is(fetch.err, context.Canceled) || errors.Is(fetch.err, context.DeadlineExceeded)) {
+			retry = append(retry, fetch.signature)
+			continue
+		}
+		if fetch.err != nil {
+			return nil, fetch.err
+		}
+		newTransactions = append(newTransactions, fetch.tx)
+	}
+	if len(retry) > 0 {
+		retried, err := s.getBatchTransactions(ctx, retry)
+		if err != nil {
+			return nil, err
+		}
+		newTransactions = append(newTransactions, retried...)
+	}
 
 	return append(cached, newTransactions...), nil
 }
 
-//// batchGetTransactions 使用批量API获取交易
-//func (s *PnlService) batchGetTransactions(ctx context.Context, signatures []solana.Signature) ([]*rpc.GetTransactionResult, error) {
-//	var allResults []*rpc.GetTransactionResult
-//
-//	// 分批次处理（避免单次请求过大）
-//	for i := 0; i < len(signatures); i += s.batchSize {
-//		end := i + s.batchSize
-//		if end > len(signatures) {
-//			end = len(signatures)
-//		}
-//		batch := signatures[i:end]
-//		results, err := s.rpcClient.GetTransaction(
-//			ctx,
-//			batch,
-//			rpc.CommitmentFinalized,
-//		)
-//		if err != nil {
-//			return nil, err
-//		}
-//
-//		// 验证结果数量
-//		if len(results) != len(batch) {
-//			return nil, errors.New("批量查询结果数量不匹配")
-//		}
-//
-//		allResults = append(allResults, results...)
-//	}
-//
-//	return allResults, nil
-//}
+// claimFetches 登记本次请求负责获取的签名；已有其他请求在获取的签名返回其transactionFetch用于等待，
+// 登记前再查一次缓存，避免刚完成的获取被重复执行
+func (s *PnlService) claimFetches(ctx context.Context, signatures []solana.Signature) (owned []solana.Signature, cached []*Transaction, waiting []*transactionFetch) {
+	s.inflightMutex.Lock()
+	defer s.inflightMutex.Unlock()
 
-// 缓存相关方法
-func (s *PnlService) getCachedTransactions(signatures []solana.Signature) ([]*Transaction, []solana.Signature) {
-	s.cacheMutex.RLock()
-	defer s.cacheMutex.RUnlock()
+	cached, signatures = s.getCachedTransactions(ctx, signatures)
+	for _, sig := range signatures {
+		key := sig.String()
+		if fetch, ok := s.inflight[key]; ok {
+			waiting = append(waiting, fetch)
+			continue
+		}
+		s.inflight[key] = &transactionFetch{signature: sig, done: make(chan struct{})}
+		owned = append(owned, sig)
+	}
+	return owned, cached, waiting
+}
+
+// finishFetches 公布本次请求获取的结果，唤醒等待同一签名的其他请求
+func (s *PnlService) finishFetches(signatures []solana.Signature, transactions []*Transaction, err error) {
+	bySignature := make(map[string]*Transaction, len(transactions))
+	for _, tx := range transactions {
+		if tx != nil {
+			bySignature[tx.Signature] = tx
+		}
+	}
+
+	s.inflightMutex.Lock()
+	defer s.inflightMutex.Unlock()
+
+	for _, sig := range signatures {
+		key := sig.String()
+		fetch, ok := s.inflight[key]
+		if !ok {
+			continue
+		}
+		delete(s.inflight, key)
+		fetch.tx, fetch.err = bySignature[key], err
+		close(fetch.done)
+	}
+}
+
+// fetchTransactions 从RPC获取交易，启用批量API时按批发送JSON-RPC批量请求，否则并发单笔请求
+func (s *PnlService) fetchTransactions(ctx context.Context, signatures []solana.Signature) ([]*Transaction, error) {
+	if s.useBatchAPI {
+		return s.batchGetTransactions(ctx, signatures)
+	}
+	return s.concurrentGetTransactions(ctx, signatures)
+}
+
+// batchGetTransactions 使用批量API获取交易，每批batchSize笔，一批只需一次HTTP请求
+// 整批请求失败（如节点不支持批量请求）时该批回退为并发单笔请求；批内返回错误或为空的交易也逐笔重新获取，
+// 由单笔请求负责重试和报告具体错误
+func (s *PnlService) batchGetTransactions(ctx context.Context, signatures []solana.Signature) ([]*Transaction, error) {
+	results := make([]*Transaction, 0, len(signatures))
+
+	// 分批次处理（避免单次请求过大）
+	for i := 0; i < len(signatures); i += s.batchSize {
+		end := i + s.batchSize
+		if end > len(signatures) {
+			end = len(signatures)
+		}
+		batch := signatures[i:end]
+
+		fetched, retry, err := s.callTransactionBatch(ctx, batch)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			s.log(ctx).Warn("批量获取交易失败，回退为单笔请求", "count", len(batch), "error", err)
+			retry = batch
+		}
+		results = append(results, fetched...)
+
+		if len(retry) > 0 {
+			single, err := s.concurrentGetTransactions(ctx, retry)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, single...)
+		}
+	}
+
+	return results, nil
+}
+
+// callTransactionBatch 以一次JSON-RPC批量请求获取batch中的交易，返回获取成功的交易和需要逐笔重新获取的签名
+func (s *PnlService) callTransactionBatch(ctx context.Context, batch []solana.Signature) ([]*Transaction, []solana.Signature, error) {
+	requests := make(jsonrpc.RPCRequests, len(batch))
+	for i, sig := range batch {
+		// 签名是字节数组，不能用jsonrpc.NewRequest（会被当作参数数组），直接构造请求；ID由RPCCallBatch按下标设置
+		requests[i] = &jsonrpc.RPCRequest{Method: "getTransaction", Params: s.getTransactionParams(sig)}
+	}
+
+	var responses jsonrpc.RPCResponses
+	err := s.retryRPC(ctx, func() error {
+		var err error
+		rpcRequestsTotal.WithLabelValues("getTransaction").Add(float64(len(batch)))
+		responses, err = s.rpcClient.RPCCallBatch(ctx, requests)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 批量响应可能乱序，按ID对应回签名
+	byID := responses.AsMap()
+	var transactions []*Transaction
+	var retry []solana.Signature
+	for i, sig := range batch {
+		resp := byID[i]
+		var rawTx *rpc.GetTransactionResult
+		if resp == nil || resp.Error != nil || resp.GetObject(&rawTx) != nil || rawTx == nil {
+			retry = append(retry, sig)
+			continue
+		}
+		transactions = append(transactions, newTransaction(sig, rawTx))
+	}
+	return transactions, retry, nil
+}
+
+// getTransactionParams 构造getTransaction的请求参数，与rpc.Client.GetTransaction按getTransactionOpts发送的参数一致
+func (s *PnlService) getTransactionParams(sig solana.Signature) []interface{} {
+	opts := s.getTransactionOpts()
+	obj := rpc.M{}
+	if opts.Encoding != "" {
+		obj["encoding"] = opts.Encoding
+	}
+	if opts.Commitment != "" {
+		obj["commitment"] = opts.Commitment
+	}
+	if opts.MaxSupportedTransactionVersion != nil {
+		obj["maxSupportedTransactionVersion"] = *opts.MaxSupportedTransactionVersion
+	}
+	params := []interface{}{sig}
+	if len(obj) > 0 {
+		params = append(params, obj)
+	}
+	return params
+}
 
+// 缓存相关方法
+// getCachedTransactions 按当前确认级别查询缓存；finalized交易不会再变化，可以满足任意确认级别的请求
+func (s *PnlService) getCachedTransactions(ctx context.Context, signatures []solana.Signature) ([]*Transaction, []solana.Signature) {
 	var cached []*Transaction
 	var remaining []solana.Signature
 
 	for _, sig := range signatures {
 		key := sig.String()
-		if tx, ok := s.cache[key]; ok {
+		if tx, ok := s.cache.Get(ctx, transactionCacheKey(rpc.CommitmentFinalized, key)); ok {
+			cached = append(cached, tx)
+		} else if tx, ok := s.cache.Get(ctx, transactionCacheKey(s.txFetch.Commitment, key)); ok {
 			cached = append(cached, tx)
 		} else {
 			remaining = append(remaining, sig)
@@ -231,17 +716,51 @@ func (s *PnlService) getCachedTransactions(signatures []solana.Signature) ([]*Tr
 	return cached, remaining
 }
 
-func (s *PnlService) cacheTransactions(transactions []*Transaction) {
-	s.cacheMutex.Lock()
-	defer s.cacheMutex.Unlock()
+// cacheTransactions 按当前确认级别写入缓存，非finalized交易带过期时间
+func (s *PnlService) cacheTransactions(ctx context.Context, transactions []*Transaction) {
+	commitment := s.txFetch.Commitment
+	finalized := commitment == rpc.CommitmentFinalized
+	if !finalized && s.confirmedTTL <= 0 {
+		return
+	}
 
+	var ttl time.Duration
+	if !finalized {
+		ttl = s.confirmedTTL
+	}
 	for _, tx := range transactions {
 		if tx != nil {
-			s.cache[tx.Signature] = tx
+			s.cache.Set(ctx, transactionCacheKey(commitment, tx.Signature), tx, ttl)
 		}
 	}
 }
 
+// ClearTransactionCache 清空交易缓存及由交易派生的代币发现记录，返回清除的交易缓存条目数
+func (s *PnlService) ClearTransactionCache(ctx context.Context) int {
+	s.mintDiscovery.clear()
+	s.nonJupiter.clear()
+	return s.cache.Clear(ctx)
+}
+
+// newTransaction 将getTransaction结果转换为自定义Transaction结构体
+func newTransaction(signature solana.Signature, rawTx *rpc.GetTransactionResult) *Transaction {
+	// 转换时修复时间转换
+	var blockTime time.Time
+	if rawTx.BlockTime != nil {
+		// 解引用UnixTimeSeconds指针获取int64值
+		blockTime = time.Unix(int64(*rawTx.BlockTime), 0)
+	} else {
+		// 处理BlockTime为nil的情况（极少数）
+		blockTime = time.Time{}
+	}
+	return &Transaction{
+		Signature: signature.String(),
+		Slot:      rawTx.Slot,
+		BlockTime: blockTime,
+		RawTx:     rawTx,
+	}
+}
+
 // concurrentGetTransactions
 func (s *PnlService) concurrentGetTransactions(ctx context.Context, signatures []solana.Signature) ([]*Transaction, error) {
 	resultChan := make(chan struct {
@@ -251,8 +770,8 @@ func (s *PnlService) concurrentGetTransactions(ctx context.Context, signatures [
 	}, len(signatures))
 	var wg sync.WaitGroup
 
-	// 控制并发数
-	semaphore := make(chan struct{}, len(signatures))
+	// 控制并发数，避免大limit时同时发出上千个请求被RPC节点限流
+	semaphore := make(chan struct{}, s.concurrency)
 
 	for i, sig := range signatures {
 		wg.Add(1)
@@ -261,17 +780,18 @@ func (s *PnlService) concurrentGetTransactions(ctx context.Context, signatures [
 			semaphore <- struct{}{}
 			defer func() { <-semaphore }()
 
-			var zero uint64 = 0
-
-			// 使用单个查询方法
-			rawTx, err := s.rpcClient.GetTransaction(
-				ctx,
-				signature,
-				&rpc.GetTransactionOpts{
-					Commitment:                     rpc.CommitmentFinalized,
-					MaxSupportedTransactionVersion: &zero,
-				},
-			)
+			// 使用单个查询方法，限流等暂时性错误退避后重试
+			var rawTx *rpc.GetTransactionResult
+			err := s.retryRPC(ctx, func() error {
+				var err error
+				rpcRequestsTotal.WithLabelValues("getTransaction").Inc()
+				rawTx, err = s.rpcClient.GetTransaction(
+					ctx,
+					signature,
+					s.getTransactionOpts(),
+				)
+				return err
+			})
 
 			if err != nil {
 				resultChan <- struct {
@@ -282,21 +802,7 @@ func (s *PnlService) concurrentGetTransactions(ctx context.Context, signatures [
 				return
 			}
 
-			// 转换为自定义Transaction结构体时修复时间转换
-			var blockTime time.Time
-			if rawTx.BlockTime != nil {
-				// 解引用UnixTimeSeconds指针获取int64值
-				blockTime = time.Unix(int64(*rawTx.BlockTime), 0)
-			} else {
-				// 处理BlockTime为nil的情况（极少数）
-				blockTime = time.Time{}
-			}
-			tx := &Transaction{
-				Signature: signature.String(),
-				Slot:      rawTx.Slot,
-				BlockTime: blockTime,
-				RawTx:     rawTx,
-			}
+			tx := newTransaction(signature, rawTx)
 
 			resultChan <- struct {
 				index int
@@ -317,15 +823,14 @@ func (s *PnlService) concurrentGetTransactions(ctx context.Context, signatures [
 	var firstErr error
 
 	for res := range resultChan {
-		if res.tx.Signature == "487tDZsjDh7jZ3DzAfZXZE699Pt22DsRRMZ7pRqHAHNaxkcc41rBjnsoTZQYoV983XnkR5rxn5tDdNWTL5V41AVu" {
-			fmt.Printf("找到了22")
-		}
-		if res.err != nil && firstErr == nil {
-			firstErr = res.err
-		}
-		if res.tx != nil {
-			results[res.index] = res.tx
+		// 失败的请求没有tx，只记录第一个错误
+		if res.err != nil {
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			continue
 		}
+		results[res.index] = res.tx
 	}
 
 	if firstErr != nil {
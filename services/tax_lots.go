@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// 持有期分类
+const (
+	HoldingShortTerm = "short"
+	HoldingLongTerm  = "long"
+)
+
+// DefaultLongTermThreshold 默认长期持有阈值（一年）
+const DefaultLongTermThreshold = 365 * 24 * time.Hour
+
+// TaxLot FIFO持仓批次（一次买入）
+type TaxLot struct {
+	Signature  string          // 买入交易签名
+	AcquiredAt time.Time       // 买入时间
+	Quantity   decimal.Decimal // 剩余数量
+	CostUSD    decimal.Decimal // 剩余数量的成本（USD）
+}
+
+// RealizedLotEvent 一次卖出与某个买入批次匹配后产生的已实现盈亏记录，金额按decimal计算后转换为float64用于响应
+type RealizedLotEvent struct {
+	AcquisitionSignature string    `json:"acquisitionSignature"` // 买入交易签名
+	DisposalSignature    string    `json:"disposalSignature"`    // 卖出交易签名
+	AcquiredAt           time.Time `json:"acquiredAt"`           // 买入时间
+	DisposedAt           time.Time `json:"disposedAt"`           // 卖出时间
+	Quantity             float64   `json:"quantity"`             // 匹配数量
+	Proceeds             float64   `json:"proceeds"`             // 卖出收入（USD）
+	CostBasis            float64   `json:"costBasis"`            // 成本（USD）
+	GainLoss             float64   `json:"gainLoss"`             // 盈亏（USD）
+	HoldingPeriod        string    `json:"holdingPeriod"`        // 持有期分类：short/long
+}
+
+// CalculateRealizedLots 按FIFO计算PnL，返回每一笔已实现的批次级盈亏（用于税务导出）
+// 与CalculatePnLWithOptions使用同一套持仓计算，opts中的价格提供方、初始持仓、手续费、往返交易排除、
+// 计价单位（以SOL计价时金额为SOL数量）以及服务的试探性买入过滤都同样生效，批次盈亏合计与method=fifo的已实现盈亏一致
+// 持有时间不少于longTermThreshold的记为长期，longTermThreshold<=0时使用DefaultLongTermThreshold；
+// 初始持仓批次没有买入交易，AcquisitionSignature为空、AcquiredAt为零值，按长期持有处理
+func (s *PnlService) CalculateRealizedLots(ctx context.Context, txList []*Transaction, user, mint string, opts PnLOptions, longTermThreshold time.Duration) ([]RealizedLotEvent, error) {
+	if longTermThreshold <= 0 {
+		longTermThreshold = DefaultLongTermThreshold
+	}
+
+	opts.CostBasisMethod = CostBasisFIFO
+	calc, err := s.CalculatePnLWithOptions(ctx, txList, user, mint, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]RealizedLotEvent, 0, len(calc.RealizedLots))
+	for _, event := range calc.RealizedLots {
+		event.HoldingPeriod = HoldingShortTerm
+		if event.DisposedAt.Sub(event.AcquiredAt) >= longTermThreshold {
+			event.HoldingPeriod = HoldingLongTerm
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
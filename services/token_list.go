@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultTokenListURL 默认的Jupiter代币列表地址
+const DefaultTokenListURL = "https://token.jup.ag/strict"
+
+// TokenListEntry 代币列表中的代币信息
+type TokenListEntry struct {
+	Address  string `json:"address"`
+	Symbol   string `json:"symbol"`
+	Name     string `json:"name"`
+	Decimals uint8  `json:"decimals"`
+}
+
+// tokenList mint -> 代币信息，由后台任务定期整体替换
+type tokenList struct {
+	mu        sync.RWMutex
+	tokens    map[string]TokenListEntry
+	updatedAt time.Time
+}
+
+func newTokenList() *tokenList {
+	return &tokenList{
+		tokens: make(map[string]TokenListEntry),
+	}
+}
+
+func (l *tokenList) get(mint string) (TokenListEntry, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	entry, ok := l.tokens[mint]
+	return entry, ok
+}
+
+func (l *tokenList) replace(tokens map[string]TokenListEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens = tokens
+	l.updatedAt = time.Now()
+}
+
+// TokenInfo 从已加载的代币列表中查询代币的symbol、名称和精度
+func (s *PnlService) TokenInfo(mint string) (TokenListEntry, bool) {
+	return s.tokenList.get(mint)
+}
+
+// StartTokenListLoader 启动时同步加载一次代币列表，之后按interval在后台刷新；interval<=0时只加载一次
+// 加载失败只记录日志，代币信息缺失时精度仍可通过RPC查询
+func (s *PnlService) StartTokenListLoader(ctx context.Context, url string, interval time.Duration) {
+	if url == "" {
+		return
+	}
+	if err := s.loadTokenList(ctx, url); err != nil {
+		s.log(ctx).Warn("加载代币列表失败", "url", url, "error", err)
+	}
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.loadTokenList(ctx, url); err != nil {
+					s.log(ctx).Warn("刷新代币列表失败", "url", url, "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// loadTokenList 下载代币列表并替换内存中的映射，同时写入精度缓存
+func (s *PnlService) loadTokenList(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求代币列表失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("代币列表返回状态码%d", resp.StatusCode)
+	}
+
+	var entries []TokenListEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return fmt.Errorf("解析代币列表失败: %w", err)
+	}
+
+	tokens := make(map[string]TokenListEntry, len(entries))
+	for _, entry := range entries {
+		if entry.Address == "" {
+			continue
+		}
+		tokens[entry.Address] = entry
+		s.decimals.set(entry.Address, entry.Decimals)
+	}
+	s.tokenList.replace(tokens)
+	return nil
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrNoTokenMetadata 元数据提供方没有该代币的信息
+var ErrNoTokenMetadata = errors.New("没有代币元数据")
+
+// TokenMetadata 代币的symbol、名称和精度，用于前端展示
+type TokenMetadata struct {
+	Mint     string `json:"mint"`
+	Symbol   string `json:"symbol"`
+	Name     string `json:"name"`
+	Decimals uint8  `json:"decimals"`
+}
+
+// MetadataProvider 由mint查询代币元数据，没有该代币时应返回包装ErrNoTokenMetadata的错误
+// 元数据只用于展示，查询失败不影响PnL计算
+type MetadataProvider interface {
+	TokenMetadata(ctx context.Context, mint string) (TokenMetadata, error)
+}
+
+// WithMetadataProvider 设置代币元数据提供方，替换默认的代币列表；查到的结果会被缓存
+func WithMetadataProvider(provider MetadataProvider) Option {
+	return func(s *PnlService) {
+		if provider != nil {
+			s.metadataSource = provider
+		}
+	}
+}
+
+// tokenListMetadataProvider 默认元数据提供方：StartTokenListLoader加载的代币列表
+type tokenListMetadataProvider struct {
+	list *tokenList
+}
+
+func (p tokenListMetadataProvider) TokenMetadata(_ context.Context, mint string) (TokenMetadata, error) {
+	entry, ok := p.list.get(mint)
+	if !ok {
+		return TokenMetadata{}, fmt.Errorf("%w: %s", ErrNoTokenMetadata, mint)
+	}
+	return TokenMetadata{Mint: mint, Symbol: entry.Symbol, Name: entry.Name, Decimals: entry.Decimals}, nil
+}
+
+// metadataCache mint -> 元数据，只缓存查到的结果，没有元数据的代币之后仍会重新查询
+type metadataCache struct {
+	mu      sync.RWMutex
+	entries map[string]TokenMetadata
+}
+
+func newMetadataCache() *metadataCache {
+	return &metadataCache{entries: make(map[string]TokenMetadata)}
+}
+
+func (c *metadataCache) get(mint string) (TokenMetadata, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	meta, ok := c.entries[mint]
+	return meta, ok
+}
+
+func (c *metadataCache) set(mint string, meta TokenMetadata) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[mint] = meta
+}
+
+// tokenMetadata 查询代币元数据，没有元数据或查询失败时返回nil
+func (s *PnlService) tokenMetadata(ctx context.Context, mint string) *TokenMetadata {
+	if meta, ok := s.metadata.get(mint); ok {
+		return &meta
+	}
+	meta, err := s.metadataSource.TokenMetadata(ctx, mint)
+	if err != nil {
+		if !errors.Is(err, ErrNoTokenMetadata) {
+			s.log(ctx).Warn("查询代币元数据失败", "mint", mint, "error", err)
+		}
+		return nil
+	}
+	meta.Mint = mint
+	s.metadata.set(mint, meta)
+	return &meta
+}
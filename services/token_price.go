@@ -2,14 +2,49 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strconv"
 	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/shopspring/decimal"
 )
 
-// 辅助函数：获取代币的USD价值
-func (s *PnlService) getTokenUSDValue(ctx context.Context, order Order, isBuy bool, amount float64) (float64, float64, error) {
-	// 获取交易时的代币价格（这里需要实现实际的价格获取逻辑）
-	// 实际应用中可能需要从价格API或Oracle获取
+// DefaultStablecoinMints 默认按1:1美元计价的稳定币
+var DefaultStablecoinMints = []string{
+	"EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v", // USDC
+	"Es9vMFrzaCERmJfrF4H2FYD4KCoNkY11McCe8BenwNYB", // USDT
+}
+
+// 价格来源
+const (
+	PriceSourceStablecoin = "stablecoin" // 报价腿为稳定币，按成交数量1:1计价
+	PriceSourceHistorical = "historical" // OKX交易时间之前最近的1s K线
+	PriceSourceCurrent    = "current"    // 查询时的最新K线（或后台刷新的缓存）
+	PriceSourceVWAP       = "vwap"       // swap事件成交均价（报价腿为SOL时乘以交易时的SOL价格）
+)
+
+// ErrNoPriceData OKX没有返回K线（新代币、流动性差或查询时间早于第一根K线）
+var ErrNoPriceData = errors.New("没有价格数据")
+
+// PriceInfo 一次定价使用的价格及来源
+type PriceInfo struct {
+	Source     string     `json:"source"`               // 价格来源，见PriceSource*
+	Provider   string     `json:"provider,omitempty"`   // 价格提供方，见PriceProvider*，稳定币定价时为空
+	Price      float64    `json:"price"`                // 单价(USD)
+	CandleTime *time.Time `json:"candleTime,omitempty"` // 使用的K线时间，稳定币定价时为空
+}
+
+// OrderPricing 持仓中一笔订单的定价信息
+type OrderPricing struct {
+	Signature string    `json:"signature"`
+	BlockTime time.Time `json:"blockTime"`
+	PriceInfo
+}
+
+// 辅助函数：获取代币的USD价值及定价来源
+func (s *PnlService) getTokenUSDValue(ctx context.Context, order Order, isBuy bool, amount decimal.Decimal, prices PriceProvider) (decimal.Decimal, PriceInfo, error) {
 	var tokenMint string
 	if isBuy {
 		tokenMint = order.BuyToken.Mint
@@ -17,30 +52,98 @@ func (s *PnlService) getTokenUSDValue(ctx context.Context, order Order, isBuy bo
 		tokenMint = order.SellToken.Mint
 	}
 
-	price, err := s.getHistoricalTokenPrice(ctx, tokenMint, order.BlockTime)
+	// 回测指定的固定价格优先于稳定币和成交均价，所有订单都按该价格计价
+	if fixed, ok := prices.(fixedPriceProvider); ok && tokenMint == fixed.mint {
+		price, err := fixed.HistoricalPrice(ctx, tokenMint, order.BlockTime)
+		return amount.Mul(decimal.NewFromFloat(price.Price)), price, err
+	}
+
+	// 对手方是稳定币时，成交的稳定币数量即为美元价值，无需查询OKX
+	if usdValue, ok := s.stablecoinLegValue(order, isBuy); ok && amount.IsPositive() {
+		return usdValue, PriceInfo{Source: PriceSourceStablecoin, Price: usdValue.Div(amount).InexactFloat64()}, nil
+	}
+
+	// 报价腿为SOL或稳定币时使用链上事件的成交均价，比目标代币的K线更接近实际成交
+	if exec := order.Execution; exec != nil && amount.IsPositive() {
+		if _, stable := s.stablecoins[exec.QuoteMint]; stable {
+			return amount.Mul(decimal.NewFromFloat(exec.Price)), PriceInfo{Source: PriceSourceVWAP, Price: exec.Price}, nil
+		}
+		if exec.QuoteMint == "SOL" {
+			sol, err := prices.HistoricalPrice(ctx, solana.SolMint.String(), order.BlockTime)
+			if err != nil {
+				return decimal.Zero, PriceInfo{}, err
+			}
+			price := decimal.NewFromFloat(exec.Price).Mul(decimal.NewFromFloat(sol.Price))
+			return amount.Mul(price), PriceInfo{Source: PriceSourceVWAP, Provider: sol.Provider, Price: price.InexactFloat64(), CandleTime: sol.CandleTime}, nil
+		}
+	}
+
+	// 获取交易时的代币价格（这里需要实现实际的价格获取逻辑）
+	// 实际应用中可能需要从价格API或Oracle获取
+	price, err := prices.HistoricalPrice(ctx, tokenMint, order.BlockTime)
 	if err != nil {
-		return 0, 0, err
+		return decimal.Zero, PriceInfo{}, err
 	}
 
-	return amount * price, price, nil
+	return amount.Mul(decimal.NewFromFloat(price.Price)), price, nil
 }
 
-// 辅助函数：获取历史代币价格
-func (s *PnlService) getHistoricalTokenPrice(ctx context.Context, mint string, timestamp time.Time) (float64, error) {
-
-	latest, err := s.okxMarketClient.GetTokenHistoricalPriceByTimeLatest(ctx, mint, strconv.FormatInt(timestamp.UnixMilli(), 10))
+// feeUSDValue 按交易时的SOL价格将订单的网络手续费换算为美元
+func (s *PnlService) feeUSDValue(ctx context.Context, order Order, prices PriceProvider) (decimal.Decimal, error) {
+	sol, err := prices.HistoricalPrice(ctx, solana.SolMint.String(), order.BlockTime)
 	if err != nil {
-		return 0, err
+		return decimal.Zero, err
 	}
-	return latest[0].Close, nil
+	// lamports按9位精度移位为SOL
+	return decimal.New(int64(order.Fee), -9).Mul(decimal.NewFromFloat(sol.Price)), nil
 }
 
-// 辅助函数：获取当前代币价格
-func (s *PnlService) getCurrentTokenPrice(ctx context.Context, mint string) (float64, error) {
-	latest, err := s.okxMarketClient.GetTokenHistoricalPriceByTimeLatest(ctx, mint, strconv.FormatInt(time.Now().UnixMilli(), 10))
+// stablecoinLegValue 若订单的对手方（报价腿）是稳定币，返回其数量作为美元价值
+func (s *PnlService) stablecoinLegValue(order Order, isBuy bool) (decimal.Decimal, bool) {
+	quote := order.SellToken
+	if !isBuy {
+		quote = order.BuyToken
+	}
+	if _, ok := s.stablecoins[quote.Mint]; !ok {
+		return decimal.Zero, false
+	}
 
-	if err != nil {
-		return 0, err
+	// 报价腿数量按!isBuy方向解析（买入时为卖出代币，卖出时为买入代币）
+	quoteAmount, err := parseTokenAmount(order, !isBuy)
+	if err != nil || !quoteAmount.IsPositive() {
+		return decimal.Zero, false
+	}
+	return quoteAmount, true
+}
+
+// 辅助函数：获取历史代币价格
+func (s *PnlService) getHistoricalTokenPrice(ctx context.Context, mint string, timestamp time.Time) (PriceInfo, error) {
+	return s.cachedPrice(mint, timestamp, false, func(t time.Time) (PriceInfo, error) {
+		return s.priceSource.HistoricalPrice(ctx, mint, t)
+	})
+}
+
+// 辅助函数：获取当前代币价格，优先使用后台刷新的缓存价格
+func (s *PnlService) getCurrentTokenPrice(ctx context.Context, mint string) (PriceInfo, error) {
+	if price, ok := s.currentPrices.get(mint); ok {
+		return price, nil
 	}
-	return latest[0].Close, nil
+	return s.fetchCurrentTokenPrice(ctx, mint)
+}
+
+// 辅助函数：从默认价格提供方查询当前代币价格，DefaultCurrentPriceCacheTTL内的重复查询使用缓存
+func (s *PnlService) fetchCurrentTokenPrice(ctx context.Context, mint string) (PriceInfo, error) {
+	return s.cachedPrice(mint, time.Now(), true, func(time.Time) (PriceInfo, error) {
+		return s.priceSource.CurrentPrice(ctx, mint)
+	})
+}
+
+// noPriceDataError OKX没有返回代币在timestamp之前的K线
+func noPriceDataError(mint string, timestamp time.Time) error {
+	return fmt.Errorf("%w: 代币 %s 在 %s", ErrNoPriceData, mint, timestamp.UTC().Format(time.RFC3339))
+}
+
+// GetOKXCandles 查询代币在timestamp之前最近的OKX K线及原始响应，用于排查OKX数据问题
+func (s *PnlService) GetOKXCandles(ctx context.Context, mint string, timestamp time.Time) (*MarketCandles, error) {
+	return s.okxMarketClient.GetTokenHistoricalCandles(ctx, mint, strconv.FormatInt(timestamp.UnixMilli(), 10))
 }
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache 交易缓存，key由transactionCacheKey生成（确认级别+签名）
+// 实现需要支持并发调用；Get未命中或出错时返回false，调用方会重新从RPC获取
+type Cache interface {
+	Get(ctx context.Context, key string) (*Transaction, bool)
+	Set(ctx context.Context, key string, tx *Transaction, ttl time.Duration) // ttl<=0表示不过期
+	Clear(ctx context.Context) int                                           // 清空缓存，返回清除的条目数
+}
+
+// WithTransactionCache 设置交易缓存，为nil时使用进程内LRU缓存
+func WithTransactionCache(cache Cache) Option {
+	return func(s *PnlService) {
+		if cache != nil {
+			s.cache = cache
+		}
+	}
+}
+
+// DefaultTransactionCacheSize 进程内交易缓存默认最大条目数
+const DefaultTransactionCacheSize = 10000
+
+// WithTransactionCacheSize 设置进程内交易缓存的最大条目数，<=0时使用DefaultTransactionCacheSize；
+// 通过WithTransactionCache指定了其他缓存时不生效
+func WithTransactionCacheSize(maxEntries int) Option {
+	return func(s *PnlService) {
+		if maxEntries > 0 {
+			s.cacheSize = maxEntries
+		}
+	}
+}
+
+// cachedTransaction 缓存的交易及其过期时间
+type cachedTransaction struct {
+	key       string
+	tx        *Transaction
+	expiresAt time.Time // 零值表示不过期（finalized）
+}
+
+// MemoryCache 进程内LRU交易缓存（默认），条目数超过上限时淘汰最久未使用的条目；
+// 随进程退出丢失，不在副本间共享
+type MemoryCache struct {
+	mu         sync.Mutex // Get也会调整使用顺序，读写都需要独占锁
+	maxEntries int
+	order      *list.List               // 最近使用的在前
+	entries    map[string]*list.Element // key -> order中的元素
+}
+
+// NewMemoryCache 创建最多保存maxEntries条交易的缓存，<=0时使用DefaultTransactionCacheSize
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	if maxEntries <= 0 {
+		maxEntries = DefaultTransactionCacheSize
+	}
+	return &MemoryCache{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// Get 已过期的条目视为未命中并删除
+func (c *MemoryCache) Get(_ context.Context, key string) (*Transaction, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cachedTransaction)
+	if !entry.expiresAt.IsZero() && !time.Now().Before(entry.expiresAt) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.tx, true
+}
+
+// Set 写入或更新条目，超过上限时淘汰最久未使用的条目
+func (c *MemoryCache) Set(_ context.Context, key string, tx *Transaction, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = &cachedTransaction{key: key, tx: tx, expiresAt: expiresAt}
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cachedTransaction{key: key, tx: tx, expiresAt: expiresAt})
+	if c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedTransaction).key)
+	}
+}
+
+func (c *MemoryCache) Clear(_ context.Context) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cleared := len(c.entries)
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	return cleared
+}
+
+// Len 当前缓存的条目数
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// DefaultRedisCachePrefix Redis交易缓存key前缀
+const DefaultRedisCachePrefix = "dplabs:tx:"
+
+// RedisCache 基于Redis的交易缓存，重启后保留并可在多个副本间共享
+type RedisCache struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration // 不过期条目（finalized交易）的TTL，0表示永久保存
+}
+
+// NewRedisCache 创建Redis交易缓存，ttl为finalized交易的保存时间，<=0表示不过期
+func NewRedisCache(client *redis.Client, ttl time.Duration) *RedisCache {
+	if ttl < 0 {
+		ttl = 0
+	}
+	return &RedisCache{client: client, prefix: DefaultRedisCachePrefix, ttl: ttl}
+}
+
+// log Redis读写失败不影响请求，只记录到slog.Default()
+func (c *RedisCache) log(ctx context.Context) *slog.Logger {
+	return LoggerWithRequestID(ctx, slog.Default())
+}
+
+// redisTransaction 交易在Redis中的JSON格式
+type redisTransaction struct {
+	Signature string          `json:"signature"`
+	Slot      uint64          `json:"slot"`
+	BlockTime time.Time       `json:"blockTime"`
+	RawTx     json.RawMessage `json:"rawTx"`
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string) (*Transaction, bool) {
+	data, err := c.client.Get(ctx, c.prefix+key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			c.log(ctx).Warn("读取Redis交易缓存失败", "key", key, "error", err)
+		}
+		return nil, false
+	}
+
+	var stored redisTransaction
+	if err := json.Unmarshal(data, &stored); err != nil {
+		c.log(ctx).Warn("解析Redis交易缓存失败", "key", key, "error", err)
+		return nil, false
+	}
+	tx := &Transaction{Signature: stored.Signature, Slot: stored.Slot, BlockTime: stored.BlockTime}
+	if err := json.Unmarshal(stored.RawTx, &tx.RawTx); err != nil {
+		c.log(ctx).Warn("解析Redis交易缓存失败", "key", key, "error", err)
+		return nil, false
+	}
+	return tx, true
+}
+
+// Set ttl<=0时使用缓存配置的TTL；写入失败只记录日志，不影响本次请求
+func (c *RedisCache) Set(ctx context.Context, key string, tx *Transaction, ttl time.Duration) {
+	rawTx, err := json.Marshal(tx.RawTx)
+	if err != nil {
+		c.log(ctx).Warn("序列化交易失败", "key", key, "error", err)
+		return
+	}
+	data, err := json.Marshal(redisTransaction{Signature: tx.Signature, Slot: tx.Slot, BlockTime: tx.BlockTime, RawTx: rawTx})
+	if err != nil {
+		c.log(ctx).Warn("序列化交易失败", "key", key, "error", err)
+		return
+	}
+	if ttl <= 0 {
+		ttl = c.ttl
+	}
+	if err := c.client.Set(ctx, c.prefix+key, data, ttl).Err(); err != nil {
+		c.log(ctx).Warn("写入Redis交易缓存失败", "key", key, "error", err)
+	}
+}
+
+// Clear 按前缀删除所有交易缓存
+func (c *RedisCache) Clear(ctx context.Context) int {
+	cleared := 0
+	iter := c.client.Scan(ctx, 0, c.prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		if err := c.client.Del(ctx, iter.Val()).Err(); err == nil {
+			cleared++
+		}
+	}
+	if err := iter.Err(); err != nil {
+		c.log(ctx).Warn("清空Redis交易缓存失败", "error", err)
+	}
+	return cleared
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"math"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// ExecutionPrice 由swap事件汇总的成交均价（VWAP）：报价代币合计 / 目标代币合计
+type ExecutionPrice struct {
+	QuoteMint   string  `json:"quoteMint"`   // 报价代币（SOL或稳定币）
+	BaseAmount  uint64  `json:"baseAmount"`  // 事件中目标代币成交数量合计（原始单位）
+	QuoteAmount uint64  `json:"quoteAmount"` // 事件中报价代币成交数量合计（原始单位）
+	Price       float64 `json:"price"`       // 每个目标代币对应的报价代币数量（已考虑小数位）
+}
+
+// eventMint 事件中的wSOL按订单的约定记为SOL
+func eventMint(mint solana.PublicKey) string {
+	if mint.Equals(solana.SolMint) {
+		return "SOL"
+	}
+	return mint.String()
+}
+
+// executionPrice 汇总swap所有事件计算目标代币以报价代币计的成交均价，多跳和拆单路由都计入
+// 只在报价腿为SOL或稳定币时计算；事件中缺少任一边或精度未知时返回nil
+func (s *PnlService) executionPrice(events []JupiterSwapEventData, order Order, mint string) *ExecutionPrice {
+	quoteMint := order.QuoteMint
+	if _, stable := s.stablecoins[quoteMint]; !stable && quoteMint != "SOL" {
+		return nil
+	}
+	isBuy := order.BuyToken.Mint == mint
+
+	var base, quote uint64
+	for _, event := range events {
+		in, out := eventMint(event.InputMint), eventMint(event.OutputMint)
+		if isBuy {
+			// 买入：支付的报价代币 -> 收到的目标代币
+			if in == quoteMint {
+				quote += event.InputAmount
+			}
+			if out == mint {
+				base += event.OutputAmount
+			}
+		} else {
+			// 卖出：支付的目标代币 -> 收到的报价代币
+			if in == mint {
+				base += event.InputAmount
+			}
+			if out == quoteMint {
+				quote += event.OutputAmount
+			}
+		}
+	}
+	if base == 0 || quote == 0 {
+		return nil
+	}
+
+	baseDecimals, ok := s.knownDecimals(mint)
+	if !ok {
+		return nil
+	}
+	quoteDecimals, ok := s.knownDecimals(quoteMint)
+	if !ok {
+		return nil
+	}
+
+	price := (float64(quote) / math.Pow10(int(quoteDecimals))) / (float64(base) / math.Pow10(int(baseDecimals)))
+	return &ExecutionPrice{QuoteMint: quoteMint, BaseAmount: base, QuoteAmount: quote, Price: price}
+}
+
+// knownDecimals 返回已知的代币精度（SOL或交易中出现过的代币），不发起RPC查询
+func (s *PnlService) knownDecimals(mint string) (uint8, bool) {
+	if mint == "SOL" {
+		return 9, true
+	}
+	return s.decimals.get(mint)
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// walletConcurrency 钱包汇总时同时计算的代币数量
+const walletConcurrency = 8
+
+// TokenPnL 钱包中单个代币的PnL
+type TokenPnL struct {
+	Mint       string      `json:"mint"`
+	Symbol     string      `json:"symbol,omitempty"` // 来自代币列表，未加载时为空
+	Realized   float64     `json:"realized"`         // 已实现盈亏合计(USD)
+	Unrealized float64     `json:"unrealized"`       // 未实现盈亏合计(USD)
+	Total      float64     `json:"total"`            // 已实现+未实现
+	Results    []PnLResult `json:"results"`          // 该代币各持仓的明细
+	Warnings   []string    `json:"warnings,omitempty"`
+}
+
+// UnpriceableToken 无法计算PnL的代币及原因
+type UnpriceableToken struct {
+	Mint   string `json:"mint"`
+	Symbol string `json:"symbol,omitempty"`
+	Error  string `json:"error"`
+}
+
+// WalletPnL 钱包级PnL汇总
+type WalletPnL struct {
+	TotalRealized   float64            `json:"totalRealized"`
+	TotalUnrealized float64            `json:"totalUnrealized"`
+	Total           float64            `json:"total"`
+	Tokens          []TokenPnL         `json:"tokens"`                // 按Total从高到低排列
+	Unpriceable     []UnpriceableToken `json:"unpriceable,omitempty"` // 无法定价的代币，不计入合计
+}
+
+// DiscoverTradedMints 找出用户在交易中通过Jupiter买卖过的代币，SOL和稳定币作为报价资产不计入
+// 已扫描过的交易直接使用按钱包缓存的结果，新交易按批并行解析，代币按首次出现的交易顺序返回
+func (s *PnlService) DiscoverTradedMints(ctx context.Context, txList []*Transaction, user string) []string {
+	known, pending := s.mintDiscovery.lookup(user, txList)
+	if len(pending) > 0 {
+		found := s.scanTradedMints(ctx, txList, user, pending)
+		s.mintDiscovery.store(user, txList, found)
+		for i, mints := range found {
+			known[i] = mints
+		}
+	}
+
+	seen := make(map[string]struct{})
+	var mints []string
+	for i := range txList {
+		for _, mint := range known[i] {
+			if _, ok := seen[mint]; ok {
+				continue
+			}
+			seen[mint] = struct{}{}
+			mints = append(mints, mint)
+		}
+	}
+	return mints
+}
+
+// CalculateWalletPnL 对用户交易过的所有代币并行计算PnL并汇总，交易列表在各代币之间共享
+// 单个代币计算失败（通常是无法定价）时列入Unpriceable，不影响其他代币
+func (s *PnlService) CalculateWalletPnL(ctx context.Context, txList []*Transaction, user string, opts PnLOptions) *WalletPnL {
+	mints := s.DiscoverTradedMints(ctx, txList, user)
+	// 初始持仓针对单个代币，不适用于钱包内的所有代币
+	opts.InitialQuantity, opts.InitialCostUSD = 0, 0
+
+	tokens := make([]TokenPnL, len(mints))
+	errs := make([]error, len(mints))
+	semaphore := make(chan struct{}, walletConcurrency)
+	var wg sync.WaitGroup
+	for i, mint := range mints {
+		wg.Add(1)
+		go func(i int, mint string) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			calc, err := s.CalculatePnLWithOptions(ctx, txList, user, mint, opts)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			token := TokenPnL{Mint: mint, Results: calc.Results, Warnings: calc.Warnings}
+			for _, r := range calc.Results {
+				token.Realized += r.ProfitLossValue
+				token.Unrealized += r.UnrealizedProfitLossValue
+			}
+			token.Total = token.Realized + token.Unrealized
+			tokens[i] = token
+		}(i, mint)
+	}
+	wg.Wait()
+
+	wallet := &WalletPnL{Tokens: make([]TokenPnL, 0, len(mints))}
+	for i, mint := range mints {
+		symbol := ""
+		if info, ok := s.TokenInfo(mint); ok {
+			symbol = info.Symbol
+		}
+		if errs[i] != nil {
+			wallet.Unpriceable = append(wallet.Unpriceable, UnpriceableToken{Mint: mint, Symbol: symbol, Error: errs[i].Error()})
+			continue
+		}
+		token := tokens[i]
+		token.Symbol = symbol
+		wallet.TotalRealized += token.Realized
+		wallet.TotalUnrealized += token.Unrealized
+		wallet.Tokens = append(wallet.Tokens, token)
+	}
+	wallet.Total = wallet.TotalRealized + wallet.TotalUnrealized
+
+	sort.SliceStable(wallet.Tokens, func(i, j int) bool {
+		return wallet.Tokens[i].Total > wallet.Tokens[j].Total
+	})
+	return wallet
+}
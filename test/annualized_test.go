@@ -0,0 +1,56 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/go-playground/assert/v2"
+	"github.com/zhinan22/DPLabsDemo/services"
+)
+
+func Test_AnnualizeReturn(t *testing.T) {
+	year := 365 * 24 * time.Hour
+	for _, tc := range []struct {
+		name       string
+		pnl        float64
+		investment float64
+		holding    time.Duration
+		want       *services.AnnualizedReturn
+	}{
+		{"一年", 10, 100, year, &services.AnnualizedReturn{Percentage: "10.00%"}},
+		// 半年10%按复利折算：1.1^2 - 1
+		{"半年", 10, 100, year / 2, &services.AnnualizedReturn{Percentage: "21.00%"}},
+		{"亏损", -50, 100, year, &services.AnnualizedReturn{Percentage: "-50.00%"}},
+		{"本金全部亏损", -100, 100, year / 2, &services.AnnualizedReturn{Percentage: "-100.00%"}},
+		// 低于最短持有时长只标记，不给出年化
+		{"持有过短", 10, 100, services.MinAnnualizeHolding - time.Second, &services.AnnualizedReturn{Flag: services.AnnualizedFlagTooShort}},
+		{"恰好最短持有", 0, 100, services.MinAnnualizeHolding, &services.AnnualizedReturn{Percentage: "0.00%"}},
+		// 一小时翻倍的年化溢出，截断到上限
+		{"截断", 100, 100, time.Hour, &services.AnnualizedReturn{Percentage: "1000000.00%", Flag: services.AnnualizedFlagCapped}},
+		{"没有投入", 10, 0, year, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, services.AnnualizeReturn(tc.pnl, tc.investment, tc.holding))
+		})
+	}
+}
+
+func Test_CalculatePnL_Annualized(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+
+	// 相隔1秒买卖平仓；一天后再次买入，一年后卖出
+	addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 110_000_000, 100_000_000, false),
+	)
+	addSwaps(t, rpcServer, 1700086400, stableSwap(user, token, 100_000_000, 100_000_000, true))
+	addSwaps(t, rpcServer, 1700086400+365*24*3600, stableSwap(user, token, 110_000_000, 100_000_000, false))
+
+	results := calculatePnL(t, svc, user, token)
+	assert.Equal(t, len(results), 2)
+	assert.Equal(t, results[0].Annualized, &services.AnnualizedReturn{Flag: services.AnnualizedFlagTooShort})
+	assert.Equal(t, results[1].Annualized, &services.AnnualizedReturn{Percentage: "10.00%"})
+}
@@ -0,0 +1,101 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/zhinan22/DPLabsDemo/util"
+)
+
+func Test_Int_Arithmetic(t *testing.T) {
+	var zero util.Int // 底层为nil
+	seven, three := util.New(7), util.New(-3)
+
+	cases := []struct {
+		name string
+		got  util.Int
+		want string
+	}{
+		{"add", seven.Add(three), "4"},
+		{"sub", seven.Sub(three), "10"},
+		{"mul", seven.Mul(three), "-21"},
+		{"div", seven.Div(three), "-2"},
+		{"neg", three.Neg(), "3"},
+		{"add nil operand", seven.Add(zero), "7"},
+		{"add nil receiver", zero.Add(seven), "7"},
+		{"sub nil receiver", zero.Sub(seven), "-7"},
+		{"mul nil", seven.Mul(zero), "0"},
+		{"div nil receiver", zero.Div(seven), "0"},
+		{"neg nil", zero.Neg(), "0"},
+		{"large", util.MustDecimal("18446744073709551615").Add(util.New(1)), "18446744073709551616"},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, tc.got.String())
+	}
+
+	// 不修改接收者和参数
+	assert.Equal(t, "7", seven.String())
+	assert.Equal(t, "-3", three.String())
+	assert.Equal(t, true, zero.Int == nil)
+
+	cmps := []struct {
+		a, b util.Int
+		want int
+	}{
+		{seven, three, 1},
+		{three, seven, -1},
+		{seven, util.New(7), 0},
+		{zero, util.New(0), 0},
+		{zero, three, 1},
+		{three, zero, -1},
+		{zero, zero, 0},
+	}
+	for _, tc := range cmps {
+		assert.Equal(t, tc.want, tc.a.Cmp(tc.b))
+	}
+}
+
+func Test_Int_Compare(t *testing.T) {
+	var zero util.Int // 底层为nil
+	seven, three := util.New(7), util.New(-3)
+
+	cases := []struct {
+		name                 string
+		a, b                 util.Int
+		equal, less, greater bool
+	}{
+		{"greater", seven, three, false, false, true},
+		{"less", three, seven, false, true, false},
+		{"equal value", seven, util.New(7), true, false, false},
+		{"nil vs zero", zero, util.New(0), true, false, false},
+		{"zero vs nil", util.New(0), zero, true, false, false},
+		{"nil vs nil", zero, zero, true, false, false},
+		{"nil vs negative", zero, three, false, false, true},
+		{"negative vs nil", three, zero, false, true, false},
+		{"nil vs positive", zero, seven, false, true, false},
+		{"large equal", util.MustDecimal("18446744073709551616"), util.NewUint64(18446744073709551615).Add(util.New(1)), true, false, false},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.equal, tc.a.Equal(tc.b))
+		assert.Equal(t, tc.less, tc.a.LessThan(tc.b))
+		assert.Equal(t, tc.greater, tc.a.GreaterThan(tc.b))
+	}
+
+	signs := []struct {
+		name     string
+		i        util.Int
+		sign     int
+		negative bool
+		positive bool
+	}{
+		{"nil", zero, 0, false, false},
+		{"zero", util.New(0), 0, false, false},
+		{"positive", seven, 1, false, true},
+		{"negative", three, -1, true, false},
+	}
+	for _, tc := range signs {
+		assert.Equal(t, tc.sign, tc.i.Sign())
+		assert.Equal(t, tc.negative, tc.i.IsNegative())
+		assert.Equal(t, tc.positive, tc.i.Positive())
+	}
+}
@@ -0,0 +1,31 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/go-playground/assert/v2"
+	"github.com/zhinan22/DPLabsDemo/config"
+)
+
+func Test_LoadConfig_TransactionFetch(t *testing.T) {
+	t.Setenv("RPC_TX_ENCODING", "base58")
+	t.Setenv("RPC_MAX_TX_VERSION", "0")
+	cfg, err := config.LoadConfig()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, solana.EncodingBase58, cfg.TransactionFetch.Encoding)
+	assert.Equal(t, uint64(0), *cfg.TransactionFetch.MaxSupportedTransactionVersion)
+
+	// jsonParsed无法解码为交易，无效的版本号不能被忽略
+	for _, env := range [][2]string{
+		{"RPC_TX_ENCODING", "jsonParsed"},
+		{"RPC_MAX_TX_VERSION", "v0"},
+		{"RPC_MAX_TX_VERSION", "-1"},
+	} {
+		t.Run(env[0]+"="+env[1], func(t *testing.T) {
+			t.Setenv(env[0], env[1])
+			_, err := config.LoadConfig()
+			assert.NotEqual(t, nil, err)
+		})
+	}
+}
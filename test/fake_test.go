@@ -0,0 +1,525 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// fakeSignature 假RPC返回的签名记录
+type fakeSignature struct {
+	Signature string
+	Slot      uint64
+	BlockTime int64
+}
+
+// fakeRPC 模拟Solana JSON-RPC节点，记录每个方法的调用情况
+type fakeRPC struct {
+	mu           sync.Mutex
+	signatures   []fakeSignature            // 按时间倒序（与getSignaturesForAddress一致）
+	txs          map[string]json.RawMessage // 签名 -> getTransaction结果
+	calls        map[string]int             // 方法 -> 调用次数
+	params       map[string][]json.RawMessage
+	disabled     map[string]bool // 模拟节点不支持的方法
+	failing      map[string]bool // getTransaction返回错误的签名
+	rateLimited  map[string]int  // getTransaction在成功前返回HTTP 429的剩余次数
+	delay        time.Duration   // 每个getTransaction请求的处理耗时
+	sigDelay     time.Duration   // 每个getSignaturesForAddress请求的处理耗时
+	inflight     int             // 正在处理的getTransaction请求数
+	peakInflight int             // 观察到的最大并发getTransaction请求数
+	httpRequests int             // 收到的HTTP请求数，批量请求只计一次
+	noBatch      bool            // 模拟不支持JSON-RPC批量请求的节点
+	server       *httptest.Server
+}
+
+type rpcRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+func newFakeRPC(t testing.TB) *fakeRPC {
+	f := &fakeRPC{
+		txs:         make(map[string]json.RawMessage),
+		calls:       make(map[string]int),
+		params:      make(map[string][]json.RawMessage),
+		disabled:    make(map[string]bool),
+		failing:     make(map[string]bool),
+		rateLimited: make(map[string]int),
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// addSignatures 生成n个签名，blockTime从start开始每个递增一秒，返回按时间正序的签名
+func (f *fakeRPC) addSignatures(n int, start time.Time) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var added []string
+	for i := 0; i < n; i++ {
+		sig := randomSignature()
+		added = append(added, sig)
+		// 保持倒序
+		f.signatures = append([]fakeSignature{{
+			Signature: sig,
+			Slot:      uint64(1000 + len(f.signatures)),
+			BlockTime: start.Add(time.Duration(i) * time.Second).Unix(),
+		}}, f.signatures...)
+	}
+	return added
+}
+
+func (f *fakeRPC) setTransaction(signature string, result json.RawMessage) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.txs[signature] = result
+}
+
+// disableMethod 模拟节点不支持某个RPC方法
+func (f *fakeRPC) disableMethod(method string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.disabled[method] = true
+}
+
+// enterTransaction 记录并发的getTransaction请求，并按delay模拟处理耗时
+func (f *fakeRPC) enterTransaction() {
+	f.mu.Lock()
+	f.inflight++
+	if f.inflight > f.peakInflight {
+		f.peakInflight = f.inflight
+	}
+	delay := f.delay
+	f.mu.Unlock()
+	time.Sleep(delay)
+}
+
+func (f *fakeRPC) leaveTransaction() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inflight--
+}
+
+// setTransactionDelay 设置getTransaction的处理耗时，用于观察并发数
+func (f *fakeRPC) setTransactionDelay(delay time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.delay = delay
+}
+
+// setSignatureDelay 设置每个getSignaturesForAddress请求的处理耗时，客户端取消请求时提前返回
+func (f *fakeRPC) setSignatureDelay(delay time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sigDelay = delay
+}
+
+// maxInflight 返回观察到的最大并发getTransaction请求数
+func (f *fakeRPC) maxInflight() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.peakInflight
+}
+
+// failTransaction 使该签名的getTransaction返回RPC错误
+func (f *fakeRPC) failTransaction(signature string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failing[signature] = true
+}
+
+// rateLimitTransaction 使该签名的前times次getTransaction返回HTTP 429，之后正常返回
+func (f *fakeRPC) rateLimitTransaction(signature string, times int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rateLimited[signature] = times
+}
+
+// takeRateLimit 消耗一次限流并计入调用次数，返回本次请求是否应被限流
+func (f *fakeRPC) takeRateLimit(req rpcRequest) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var sig string
+	if len(req.Params) > 0 {
+		_ = json.Unmarshal(req.Params[0], &sig)
+	}
+	if f.rateLimited[sig] == 0 {
+		return false
+	}
+	f.rateLimited[sig]--
+	f.calls[req.Method]++
+	return true
+}
+
+// disableBatch 模拟不支持JSON-RPC批量请求的节点，批量请求返回HTTP 403
+func (f *fakeRPC) disableBatch() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.noBatch = true
+}
+
+// requestCount 返回收到的HTTP请求数
+func (f *fakeRPC) requestCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.httpRequests
+}
+
+func (f *fakeRPC) callCount(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+// lastParams 返回某个方法最后一次调用的第index个参数
+func (f *fakeRPC) lastParams(method string, index int) map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var out map[string]interface{}
+	list := f.params[method]
+	if len(list) == 0 {
+		return nil
+	}
+	var params []json.RawMessage
+	_ = json.Unmarshal(list[len(list)-1], &params)
+	if index < len(params) {
+		_ = json.Unmarshal(params[index], &out)
+	}
+	return out
+}
+
+func (f *fakeRPC) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	f.httpRequests++
+	noBatch := f.noBatch
+	f.mu.Unlock()
+
+	if len(body) > 0 && body[0] == '[' {
+		if noBatch {
+			http.Error(w, "batch requests are not supported", http.StatusForbidden)
+			return
+		}
+		f.serveBatch(w, body)
+		return
+	}
+
+	var req rpcRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Method == "getSignaturesForAddress" {
+		f.mu.Lock()
+		delay := f.sigDelay
+		f.mu.Unlock()
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if req.Method == "getTransaction" {
+		f.enterTransaction()
+		defer f.leaveTransaction()
+		if f.takeRateLimit(req) {
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+	}
+	result, rpcErr := f.handle(req)
+
+	resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+	if rpcErr != nil {
+		resp["error"] = rpcErr
+	} else {
+		resp["result"] = result
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// serveBatch 处理JSON-RPC批量请求，逐个执行后按数组返回；不模拟限流和处理耗时
+func (f *fakeRPC) serveBatch(w http.ResponseWriter, body []byte) {
+	var reqs []rpcRequest
+	if err := json.Unmarshal(body, &reqs); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resps := make([]map[string]interface{}, 0, len(reqs))
+	for _, req := range reqs {
+		result, rpcErr := f.handle(req)
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+		if rpcErr != nil {
+			resp["error"] = rpcErr
+		} else {
+			resp["result"] = result
+		}
+		resps = append(resps, resp)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resps)
+}
+
+func (f *fakeRPC) handle(req rpcRequest) (interface{}, map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls[req.Method]++
+	raw, _ := json.Marshal(req.Params)
+	f.params[req.Method] = append(f.params[req.Method], raw)
+
+	if f.disabled[req.Method] {
+		return nil, map[string]interface{}{"code": -32601, "message": "Method not found"}
+	}
+
+	switch req.Method {
+	case "getHealth":
+		return "ok", nil
+	case "getSignaturesForAddress":
+		var opts struct {
+			Limit  int    `json:"limit"`
+			Before string `json:"before"`
+			Until  string `json:"until"`
+		}
+		if len(req.Params) > 1 {
+			_ = json.Unmarshal(req.Params[1], &opts)
+		}
+		var out []map[string]interface{}
+		started := opts.Before == ""
+		for _, sig := range f.signatures {
+			if !started {
+				started = sig.Signature == opts.Before
+				continue
+			}
+			if opts.Until != "" && sig.Signature == opts.Until {
+				break
+			}
+			if opts.Limit > 0 && len(out) >= opts.Limit {
+				break
+			}
+			out = append(out, map[string]interface{}{
+				"signature":          sig.Signature,
+				"slot":               sig.Slot,
+				"blockTime":          sig.BlockTime,
+				"err":                nil,
+				"memo":               nil,
+				"confirmationStatus": "finalized",
+			})
+		}
+		if out == nil {
+			out = []map[string]interface{}{}
+		}
+		return out, nil
+	case "getTransaction":
+		var sig string
+		if len(req.Params) > 0 {
+			_ = json.Unmarshal(req.Params[0], &sig)
+		}
+		if f.failing[sig] {
+			return nil, map[string]interface{}{"code": -32603, "message": "Internal error"}
+		}
+		if tx, ok := f.txs[sig]; ok {
+			// 与真实节点一致：未声明maxSupportedTransactionVersion时拒绝返回v0交易
+			var stored struct {
+				Version interface{} `json:"version"`
+			}
+			_ = json.Unmarshal(tx, &stored)
+			if version, isV0 := stored.Version.(float64); isV0 {
+				var opts struct {
+					MaxSupportedTransactionVersion *int `json:"maxSupportedTransactionVersion"`
+				}
+				if len(req.Params) > 1 {
+					_ = json.Unmarshal(req.Params[1], &opts)
+				}
+				if opts.MaxSupportedTransactionVersion == nil || *opts.MaxSupportedTransactionVersion < int(version) {
+					return nil, map[string]interface{}{
+						"code":    -32015,
+						"message": fmt.Sprintf("Transaction version (%d) is not supported by the requesting client. Please try the request again with the following configuration parameter: \"maxSupportedTransactionVersion\": %d", int(version), int(version)),
+					}
+				}
+			}
+			return tx, nil
+		}
+		// 默认返回一笔不含Jupiter指令的普通交易
+		for _, s := range f.signatures {
+			if s.Signature == sig {
+				return emptyTransaction(s), nil
+			}
+		}
+		return nil, nil
+	default:
+		return nil, map[string]interface{}{"code": -32601, "message": fmt.Sprintf("Method not found: %s", req.Method)}
+	}
+}
+
+// emptyTransaction 构造一笔只有一条system转账类指令的交易
+func emptyTransaction(sig fakeSignature) json.RawMessage {
+	payer := solana.NewWallet().PublicKey()
+	program := solana.SystemProgramID
+	tx, _ := solana.NewTransaction(
+		[]solana.Instruction{solana.NewInstruction(program, solana.AccountMetaSlice{solana.Meta(payer).SIGNER().WRITE()}, []byte{2, 0, 0, 0})},
+		solana.Hash{},
+		solana.TransactionPayer(payer),
+	)
+	result := map[string]interface{}{
+		"slot":        sig.Slot,
+		"blockTime":   sig.BlockTime,
+		"transaction": []string{tx.MustToBase64(), "base64"},
+		"meta": map[string]interface{}{
+			"err":               nil,
+			"fee":               5000,
+			"preBalances":       []uint64{1000000000, 1},
+			"postBalances":      []uint64{999995000, 1},
+			"innerInstructions": []interface{}{},
+			"preTokenBalances":  []interface{}{},
+			"postTokenBalances": []interface{}{},
+			"loadedAddresses":   map[string]interface{}{"writable": []string{}, "readonly": []string{}},
+		},
+	}
+	raw, _ := json.Marshal(result)
+	return raw
+}
+
+func randomSignature() string {
+	var sig solana.Signature
+	key := solana.NewWallet().PublicKey()
+	copy(sig[:], key[:])
+	other := solana.NewWallet().PublicKey()
+	copy(sig[32:], other[:])
+	return sig.String()
+}
+
+// fakeOKX 模拟OKX行情接口，所有代币返回同一个收盘价
+type fakeOKX struct {
+	mu        sync.Mutex
+	price     string
+	calls     int
+	failUntil time.Time // 查询该时间之前价格的请求直接断开连接，模拟OKX历史数据缺失
+	errCode   string    // 不为空时返回OKX业务错误
+	errMsg    string
+	empty     bool            // 为true时返回空的data数组，模拟没有K线的代币
+	emptyBars map[string]bool // 这些K线粒度返回空的data数组
+	bars      []string        // 每次请求的K线粒度
+	skews     []time.Duration // 每次请求收到时与OK-ACCESS-TIMESTAMP的时间差
+	server    *httptest.Server
+}
+
+func newFakeOKX(t testing.TB, price string) *fakeOKX {
+	f := &fakeOKX{price: price}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.calls++
+		price := f.price
+		after, _ := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+		fail := after > 0 && time.UnixMilli(after).Before(f.failUntil)
+		errCode, errMsg := f.errCode, f.errMsg
+		bar := r.URL.Query().Get("bar")
+		f.bars = append(f.bars, bar)
+		if signed, err := time.Parse("2006-01-02T15:04:05.000Z", r.Header.Get("OK-ACCESS-TIMESTAMP")); err == nil {
+			f.skews = append(f.skews, time.Since(signed))
+		}
+		empty := f.empty || f.emptyBars[bar]
+		f.mu.Unlock()
+
+		if fail {
+			if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+				_ = conn.Close()
+			}
+			return
+		}
+
+		if errCode != "" {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"code": errCode, "msg": errMsg, "data": [][]string{}})
+			return
+		}
+
+		if empty {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"code": "0", "msg": "", "data": [][]string{}})
+			return
+		}
+
+		// 与OKX一致：返回after之前最近的K线
+		ts := fmt.Sprintf("%d", time.Now().UnixMilli())
+		if after > 0 {
+			ts = fmt.Sprintf("%d", after-1)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"code": "0",
+			"msg":  "",
+			"data": [][]string{{ts, price, price, price, price, "1", "1", "1"}},
+		})
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// lastSkew 最近一次请求的签名时间戳距收到请求的时间
+func (f *fakeOKX) lastSkew() time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.skews[len(f.skews)-1]
+}
+
+func (f *fakeOKX) callCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// setPrice 修改之后请求返回的收盘价
+func (f *fakeOKX) setPrice(price string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.price = price
+}
+
+// failWithCode 使所有请求返回OKX业务错误
+func (f *fakeOKX) failWithCode(code, msg string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errCode, f.errMsg = code, msg
+}
+
+// failPricesBefore 使查询until之前价格的请求失败
+func (f *fakeOKX) failPricesBefore(until time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failUntil = until
+}
+
+// returnEmptyBar 使指定粒度的K线请求返回空数组
+func (f *fakeOKX) returnEmptyBar(bar string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.emptyBars == nil {
+		f.emptyBars = make(map[string]bool)
+	}
+	f.emptyBars[bar] = true
+}
+
+// requestedBars 返回每次请求的K线粒度
+func (f *fakeOKX) requestedBars() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.bars...)
+}
+
+// returnEmptyData 使所有请求返回空的K线数组
+func (f *fakeOKX) returnEmptyData() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.empty = true
+}
@@ -0,0 +1,439 @@
+package test
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/near/borsh-go"
+	"github.com/zhinan22/DPLabsDemo/services"
+)
+
+// jupiterPID Jupiter v6程序ID
+var jupiterPID = solana.MustPublicKeyFromBase58("JUP6LkbZbjS1jKKwapdHNy74zcZ3tLUZoi5QNyVTaV4")
+
+// tokenLeg 用户在某个代币上的余额变化，Mint为solana.SolMint时使用用户的lamports余额
+type tokenLeg struct {
+	Mint     solana.PublicKey
+	Decimals uint8
+	Pre      uint64
+	Post     uint64
+}
+
+// swapHop 一个swap事件（一跳）
+type swapHop struct {
+	InputMint    solana.PublicKey
+	InputAmount  uint64
+	OutputMint   solana.PublicKey
+	OutputAmount uint64
+}
+
+// swapFixture 构造Jupiter swap交易的参数
+type swapFixture struct {
+	User      solana.PublicKey
+	Slot      uint64
+	BlockTime int64
+	V0        bool   // 是否为v0交易
+	Failed    bool   // 是否为失败交易
+	Fee       uint64 // 手续费（lamports）
+	Legs      []tokenLeg
+	Hops      []swapHop
+	Routes    [][]swapHop        // 多个route时每个route的事件，设置后忽略Hops
+	Signers   []solana.PublicKey // 额外签名者（多签场景）
+	Others    []otherOwnerLeg    // 其他地址的余额变化
+	// 每个Hops事件所属的顶层指令索引，默认都属于route；超出route的索引追加为非Jupiter指令，
+	// innerInstructions按索引首次出现的顺序列出，用于构造树遍历顺序与指令索引顺序不同的交易
+	EventTops []int
+	// v0交易通过地址查找表加载的账户，写入meta.loadedAddresses
+	LoadedWritable []solana.PublicKey
+	LoadedReadOnly []solana.PublicKey
+}
+
+// otherOwnerLeg 非查询用户的代币余额变化
+type otherOwnerLeg struct {
+	Owner solana.PublicKey
+	tokenLeg
+}
+
+// swapEventData 构造Jupiter SwapEvent数据：2个8字节discriminator + borsh编码的事件
+func swapEventData(hop swapHop) []byte {
+	data, _ := hex.DecodeString("e445a52e51cb9a1d40c6cde8260871e2")
+	payload, _ := borsh.Serialize(services.JupiterSwapEventData{
+		Amm:          solana.NewWallet().PublicKey(),
+		InputMint:    hop.InputMint,
+		InputAmount:  hop.InputAmount,
+		OutputMint:   hop.OutputMint,
+		OutputAmount: hop.OutputAmount,
+	})
+	return append(data, payload...)
+}
+
+// base58Keys 公钥列表转为base58字符串，nil时返回空列表
+func base58Keys(keys []solana.PublicKey) []string {
+	strs := make([]string, len(keys))
+	for i, key := range keys {
+		strs[i] = key.String()
+	}
+	return strs
+}
+
+// buildSwapTx 按fixture构造getTransaction结果JSON：每个route一条顶层指令，每一跳对应一条内部事件指令
+func buildSwapTx(t testing.TB, fx swapFixture) json.RawMessage {
+	t.Helper()
+
+	// 为每个代币腿创建用户的代币账户
+	tokenAccounts := make([]solana.PublicKey, len(fx.Legs))
+	metas := solana.AccountMetaSlice{solana.Meta(fx.User).SIGNER().WRITE()}
+	for _, signer := range fx.Signers {
+		metas = append(metas, solana.Meta(signer).SIGNER().WRITE())
+	}
+	for i := range fx.Legs {
+		tokenAccounts[i] = solana.NewWallet().PublicKey()
+		metas = append(metas, solana.Meta(tokenAccounts[i]).WRITE())
+	}
+	otherAccounts := make([]solana.PublicKey, len(fx.Others))
+	for i := range fx.Others {
+		otherAccounts[i] = solana.NewWallet().PublicKey()
+		metas = append(metas, solana.Meta(otherAccounts[i]).WRITE())
+	}
+
+	routes := fx.Routes
+	if len(routes) == 0 {
+		routes = [][]swapHop{fx.Hops}
+	}
+	routeArgs := routeData("e517cb977ae3ad2a", []uint64{1, 1}, 50, 0)
+	instructions := make([]solana.Instruction, len(routes))
+	for i := range routes {
+		instructions[i] = solana.NewInstruction(jupiterPID, metas, routeArgs)
+	}
+	for _, top := range fx.EventTops {
+		for len(instructions) <= top {
+			instructions = append(instructions, solana.NewInstruction(solana.SystemProgramID, solana.AccountMetaSlice{solana.Meta(fx.User).SIGNER().WRITE()}, []byte{2, 0, 0, 0}))
+		}
+	}
+	tx, err := solana.NewTransaction(
+		instructions,
+		solana.Hash{},
+		solana.TransactionPayer(fx.User),
+	)
+	if err != nil {
+		t.Fatalf("构造交易失败: %v", err)
+	}
+	if fx.V0 {
+		tx.Message.SetVersion(solana.MessageVersionV0)
+	}
+
+	keys := tx.Message.AccountKeys
+	indexOf := func(key solana.PublicKey) int {
+		for i, k := range keys {
+			if k.Equals(key) {
+				return i
+			}
+		}
+		t.Fatalf("账户 %s 不在交易中", key)
+		return -1
+	}
+
+	// SOL余额：默认不变，用户的SOL腿按fixture设置，并扣除手续费
+	preBalances := make([]uint64, len(keys))
+	postBalances := make([]uint64, len(keys))
+	for i := range keys {
+		preBalances[i] = 2039280
+		postBalances[i] = 2039280
+	}
+	userIdx := indexOf(fx.User)
+	preBalances[userIdx] = 10_000_000_000
+	postBalances[userIdx] = 10_000_000_000 - fx.Fee
+
+	var preTokenBalances, postTokenBalances []map[string]interface{}
+	tokenBalance := func(accountIdx int, owner solana.PublicKey, leg tokenLeg, amount uint64) map[string]interface{} {
+		return map[string]interface{}{
+			"accountIndex": accountIdx,
+			"mint":         leg.Mint.String(),
+			"owner":        owner.String(),
+			"programId":    solana.TokenProgramID.String(),
+			"uiTokenAmount": map[string]interface{}{
+				"amount":         strconv.FormatUint(amount, 10),
+				"decimals":       leg.Decimals,
+				"uiAmountString": strconv.FormatUint(amount, 10),
+			},
+		}
+	}
+	for i, leg := range fx.Legs {
+		if leg.Mint.Equals(solana.SolMint) {
+			preBalances[userIdx] = leg.Pre
+			postBalances[userIdx] = leg.Post - fx.Fee
+			continue
+		}
+		idx := indexOf(tokenAccounts[i])
+		preTokenBalances = append(preTokenBalances, tokenBalance(idx, fx.User, leg, leg.Pre))
+		postTokenBalances = append(postTokenBalances, tokenBalance(idx, fx.User, leg, leg.Post))
+	}
+	for i, other := range fx.Others {
+		idx := indexOf(otherAccounts[i])
+		preTokenBalances = append(preTokenBalances, tokenBalance(idx, other.Owner, other.tokenLeg, other.Pre))
+		postTokenBalances = append(postTokenBalances, tokenBalance(idx, other.Owner, other.tokenLeg, other.Post))
+	}
+	if preTokenBalances == nil {
+		preTokenBalances = []map[string]interface{}{}
+		postTokenBalances = []map[string]interface{}{}
+	}
+
+	jupIdx := indexOf(jupiterPID)
+	eventOf := func(hop swapHop) map[string]interface{} {
+		return map[string]interface{}{
+			"programIdIndex": jupIdx,
+			"accounts":       []int{},
+			"data":           solana.Base58(swapEventData(hop)).String(),
+			"stackHeight":    2,
+		}
+	}
+	innerInstructions := []map[string]interface{}{}
+	if len(fx.EventTops) > 0 {
+		groups := make(map[int]map[string]interface{})
+		for i, hop := range fx.Hops {
+			top := fx.EventTops[i]
+			group, ok := groups[top]
+			if !ok {
+				group = map[string]interface{}{"index": top, "instructions": []map[string]interface{}{}}
+				groups[top] = group
+				innerInstructions = append(innerInstructions, group)
+			}
+			group["instructions"] = append(group["instructions"].([]map[string]interface{}), eventOf(hop))
+		}
+	} else {
+		for i, hops := range routes {
+			var events []map[string]interface{}
+			for _, hop := range hops {
+				events = append(events, eventOf(hop))
+			}
+			if len(events) > 0 {
+				innerInstructions = append(innerInstructions, map[string]interface{}{
+					"index":        i,
+					"instructions": events,
+				})
+			}
+		}
+	}
+
+	var metaErr interface{}
+	if fx.Failed {
+		metaErr = map[string]interface{}{"InstructionError": []interface{}{0, map[string]interface{}{"Custom": 6001}}}
+	}
+
+	result := map[string]interface{}{
+		"slot":        fx.Slot,
+		"blockTime":   fx.BlockTime,
+		"transaction": []string{tx.MustToBase64(), "base64"},
+		"meta": map[string]interface{}{
+			"err":               metaErr,
+			"fee":               fx.Fee,
+			"preBalances":       preBalances,
+			"postBalances":      postBalances,
+			"innerInstructions": innerInstructions,
+			"preTokenBalances":  preTokenBalances,
+			"postTokenBalances": postTokenBalances,
+			"loadedAddresses":   map[string]interface{}{"writable": base58Keys(fx.LoadedWritable), "readonly": base58Keys(fx.LoadedReadOnly)},
+		},
+	}
+	if fx.V0 {
+		result["version"] = 0
+	} else {
+		result["version"] = "legacy"
+	}
+	raw, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("序列化交易失败: %v", err)
+	}
+	return raw
+}
+
+// usdcMint 测试中使用的USDC（默认稳定币）
+var usdcMint = solana.MustPublicKeyFromBase58("EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v")
+
+// stableSwap 用户用USDC买入（buy=true）或卖出token换USDC的交易，数量均为6位小数的原始数量，时间由addSwaps设置
+// 不含手续费，使成本和收入正好等于USDC数量；需要手续费的测试自行设置Fee
+func stableSwap(user, token solana.PublicKey, usdcAmount, tokenAmount uint64, buy bool) swapFixture {
+	fx := swapFixture{User: user}
+	if buy {
+		fx.Legs = []tokenLeg{
+			{Mint: usdcMint, Decimals: 6, Pre: usdcAmount, Post: 0},
+			{Mint: token, Decimals: 6, Pre: 0, Post: tokenAmount},
+		}
+		fx.Hops = []swapHop{{InputMint: usdcMint, InputAmount: usdcAmount, OutputMint: token, OutputAmount: tokenAmount}}
+	} else {
+		fx.Legs = []tokenLeg{
+			{Mint: token, Decimals: 6, Pre: tokenAmount, Post: 0},
+			{Mint: usdcMint, Decimals: 6, Pre: 0, Post: usdcAmount},
+		}
+		fx.Hops = []swapHop{{InputMint: token, InputAmount: tokenAmount, OutputMint: usdcMint, OutputAmount: usdcAmount}}
+	}
+	return fx
+}
+
+// raydiumAMM Raydium AMM v4程序ID
+var raydiumAMM = solana.MustPublicKeyFromBase58("675kPX9MHTjS2zt1qfr1NYHuzeLXfQM9H24wFSUt1Mp8")
+
+// raydiumSwapFixture 构造Raydium AMM v4 swapBaseIn交易的参数，输入输出代币精度均为6
+type raydiumSwapFixture struct {
+	User       solana.PublicKey
+	Slot       uint64
+	BlockTime  int64
+	InputMint  solana.PublicKey
+	AmountIn   uint64
+	OutputMint solana.PublicKey
+	AmountOut  uint64
+}
+
+// tokenTransferData SPL Token Transfer指令数据
+func tokenTransferData(amount uint64) []byte {
+	return binary.LittleEndian.AppendUint64([]byte{3}, amount)
+}
+
+// buildRaydiumSwapTx 构造一条顶层Raydium swapBaseIn指令，内部为用户转入池子和池子转给用户的两笔代币转账
+func buildRaydiumSwapTx(t testing.TB, fx raydiumSwapFixture) json.RawMessage {
+	t.Helper()
+
+	userSource, userDest := solana.NewWallet().PublicKey(), solana.NewWallet().PublicKey()
+	vaultIn, vaultOut := solana.NewWallet().PublicKey(), solana.NewWallet().PublicKey()
+	authority := solana.NewWallet().PublicKey()
+
+	// swapBaseIn的18个账户：token程序、amm、amm权限、open orders、target orders、两个池子金库、
+	// OpenBook程序、市场、bids、asks、event queue、两个市场金库、vault signer、用户源账户、用户目标账户、用户
+	metas := solana.AccountMetaSlice{
+		solana.Meta(solana.TokenProgramID),
+		solana.Meta(solana.NewWallet().PublicKey()).WRITE(),
+		solana.Meta(authority),
+		solana.Meta(solana.NewWallet().PublicKey()).WRITE(),
+		solana.Meta(solana.NewWallet().PublicKey()).WRITE(),
+		solana.Meta(vaultIn).WRITE(),
+		solana.Meta(vaultOut).WRITE(),
+	}
+	for i := 0; i < 8; i++ {
+		metas = append(metas, solana.Meta(solana.NewWallet().PublicKey()).WRITE())
+	}
+	metas = append(metas,
+		solana.Meta(userSource).WRITE(),
+		solana.Meta(userDest).WRITE(),
+		solana.Meta(fx.User).SIGNER().WRITE(),
+	)
+	data := binary.LittleEndian.AppendUint64([]byte{9}, fx.AmountIn)
+	data = binary.LittleEndian.AppendUint64(data, fx.AmountOut)
+
+	tx, err := solana.NewTransaction(
+		[]solana.Instruction{solana.NewInstruction(raydiumAMM, metas, data)},
+		solana.Hash{},
+		solana.TransactionPayer(fx.User),
+	)
+	if err != nil {
+		t.Fatalf("构造交易失败: %v", err)
+	}
+
+	keys := tx.Message.AccountKeys
+	indexOf := func(key solana.PublicKey) int {
+		for i, k := range keys {
+			if k.Equals(key) {
+				return i
+			}
+		}
+		t.Fatalf("账户 %s 不在交易中", key)
+		return -1
+	}
+	balances := make([]uint64, len(keys))
+	for i := range balances {
+		balances[i] = 2039280
+	}
+
+	tokenBalance := func(account, owner, mint solana.PublicKey, amount uint64) map[string]interface{} {
+		return map[string]interface{}{
+			"accountIndex": indexOf(account),
+			"mint":         mint.String(),
+			"owner":        owner.String(),
+			"programId":    solana.TokenProgramID.String(),
+			"uiTokenAmount": map[string]interface{}{
+				"amount":         strconv.FormatUint(amount, 10),
+				"decimals":       6,
+				"uiAmountString": strconv.FormatUint(amount, 10),
+			},
+		}
+	}
+	const vaultBalance = 1_000_000_000_000
+	preTokenBalances := []map[string]interface{}{
+		tokenBalance(userSource, fx.User, fx.InputMint, fx.AmountIn),
+		tokenBalance(userDest, fx.User, fx.OutputMint, 0),
+		tokenBalance(vaultIn, authority, fx.InputMint, vaultBalance),
+		tokenBalance(vaultOut, authority, fx.OutputMint, vaultBalance),
+	}
+	postTokenBalances := []map[string]interface{}{
+		tokenBalance(userSource, fx.User, fx.InputMint, 0),
+		tokenBalance(userDest, fx.User, fx.OutputMint, fx.AmountOut),
+		tokenBalance(vaultIn, authority, fx.InputMint, vaultBalance+fx.AmountIn),
+		tokenBalance(vaultOut, authority, fx.OutputMint, vaultBalance-fx.AmountOut),
+	}
+
+	tokenIdx := indexOf(solana.TokenProgramID)
+	innerInstructions := []map[string]interface{}{{
+		"index": 0,
+		"instructions": []map[string]interface{}{
+			{
+				"programIdIndex": tokenIdx,
+				"accounts":       []int{indexOf(userSource), indexOf(vaultIn), indexOf(fx.User)},
+				"data":           solana.Base58(tokenTransferData(fx.AmountIn)).String(),
+				"stackHeight":    2,
+			},
+			{
+				"programIdIndex": tokenIdx,
+				"accounts":       []int{indexOf(vaultOut), indexOf(userDest), indexOf(authority)},
+				"data":           solana.Base58(tokenTransferData(fx.AmountOut)).String(),
+				"stackHeight":    2,
+			},
+		},
+	}}
+
+	raw, err := json.Marshal(map[string]interface{}{
+		"slot":        fx.Slot,
+		"blockTime":   fx.BlockTime,
+		"version":     "legacy",
+		"transaction": []string{tx.MustToBase64(), "base64"},
+		"meta": map[string]interface{}{
+			"err":               nil,
+			"fee":               5000,
+			"preBalances":       balances,
+			"postBalances":      balances,
+			"innerInstructions": innerInstructions,
+			"preTokenBalances":  preTokenBalances,
+			"postTokenBalances": postTokenBalances,
+			"loadedAddresses":   map[string]interface{}{"writable": []string{}, "readonly": []string{}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("序列化交易失败: %v", err)
+	}
+	return raw
+}
+
+// repeatSwaps n笔相同的稳定币买入，用于只关心交易数量和时间的测试
+func repeatSwaps(user solana.PublicKey, n int) []swapFixture {
+	token := solana.NewWallet().PublicKey()
+	swaps := make([]swapFixture, n)
+	for i := range swaps {
+		swaps[i] = stableSwap(user, token, 1_000_000, 1_000_000, true)
+	}
+	return swaps
+}
+
+// addSwaps 按时间顺序添加交易，第i笔交易的blockTime为start+i秒
+func addSwaps(t testing.TB, rpcServer *fakeRPC, start int64, swaps ...swapFixture) []string {
+	t.Helper()
+	sigs := rpcServer.addSignatures(len(swaps), time.Unix(start, 0))
+	for i, fx := range swaps {
+		fx.BlockTime = start + int64(i)
+		fx.Slot = uint64(fx.BlockTime)
+		rpcServer.setTransaction(sigs[i], buildSwapTx(t, fx))
+	}
+	return sigs
+}
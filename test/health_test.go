@@ -0,0 +1,53 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/assert/v2"
+	"github.com/zhinan22/DPLabsDemo/handlers"
+)
+
+func Test_HealthAndReady(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	svc, rpcServer, okxServer := newTestServiceWithOKX(t)
+	handler := handlers.NewPnLHandler(svc, 100)
+
+	r := gin.New()
+	r.GET("/health", handler.Health)
+	r.GET("/ready", handler.Ready)
+
+	get := func(path string) (int, handlers.ReadyResponse) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		var resp handlers.ReadyResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	code, _ := get("/health")
+	assert.Equal(t, http.StatusOK, code)
+
+	code, resp := get("/ready")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", resp.Checks["rpc"])
+	assert.Equal(t, "ok", resp.Checks["okx"])
+
+	// RPC节点不支持getHealth时不就绪
+	rpcServer.disableMethod("getHealth")
+	code, resp = get("/ready")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.NotEqual(t, "ok", resp.Checks["rpc"])
+	assert.Equal(t, "ok", resp.Checks["okx"])
+
+	// OKX不可达时同样不就绪，/health不受影响
+	okxServer.server.Close()
+	code, resp = get("/ready")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.NotEqual(t, "ok", resp.Checks["okx"])
+	code, _ = get("/health")
+	assert.Equal(t, http.StatusOK, code)
+}
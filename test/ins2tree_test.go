@@ -0,0 +1,266 @@
+package test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/go-playground/assert/v2"
+	"github.com/zhinan22/DPLabsDemo/services"
+)
+
+// deepTree 构造一条深度为depth的单链指令树，所有节点都调用programIndex对应的程序
+func deepTree(depth int, programIndex uint16, data []byte) *services.StackInstructionNode {
+	root := &services.StackInstructionNode{Index: 0, ProgramIDIndex: programIndex, Data: data}
+	current := root
+	for i := 1; i <= depth; i++ {
+		child := &services.StackInstructionNode{
+			Index:          i,
+			StackHeight:    uint64(i),
+			ProgramIDIndex: programIndex,
+			Data:           data,
+			Parent:         current,
+		}
+		current.Children = append(current.Children, child)
+		current = child
+	}
+	return root
+}
+
+func Test_FindNodesByProgramID_MaxDepth(t *testing.T) {
+	program := solana.NewWallet().PublicKey()
+	keys := []solana.PublicKey{solana.NewWallet().PublicKey(), program}
+	root := deepTree(100000, 1, make([]byte, 16))
+
+	// 超过限制返回错误
+	_, _, err := services.FindNodesByProgramIDWithMaxDepth(keys, root, program, 64)
+	assert.Equal(t, true, errors.Is(err, services.ErrInstructionTreeTooDeep))
+
+	// 不限制深度时也不会因递归过深而崩溃
+	route, event, err := services.FindNodesByProgramIDWithMaxDepth(keys, root, program, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(route))
+	assert.Equal(t, 0, len(event))
+}
+
+// 每层都匹配的1000层指令树：显式栈遍历返回所有节点，深度限制按层数生效
+func Test_FindDEXNodes_Deep(t *testing.T) {
+	program := solana.NewWallet().PublicKey()
+	keys := []solana.PublicKey{solana.NewWallet().PublicKey(), program}
+	root := deepTree(1000, 1, []byte{9})
+	matchers := []services.DEXMatcher{
+		{ProgramID: program, Match: func(data []byte) bool { return len(data) > 0 && data[0] == 9 }},
+		{ProgramID: keys[0]},
+	}
+
+	nodes, err := services.FindDEXNodes(keys, root, matchers, 1000)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1001, len(nodes[0]))
+	assert.Equal(t, 0, len(nodes[1]))
+	assert.Equal(t, uint64(1000), nodes[0][1000].StackHeight)
+
+	_, err = services.FindDEXNodes(keys, root, matchers, 999)
+	assert.Equal(t, true, errors.Is(err, services.ErrInstructionTreeTooDeep))
+}
+
+func Test_FindJupiterNodes_CustomDiscriminator(t *testing.T) {
+	program := solana.NewWallet().PublicKey()
+	keys := []solana.PublicKey{solana.NewWallet().PublicKey(), program}
+	routeData, _ := hex.DecodeString("0102030405060708" + "0000000000000000")
+	eventData, _ := hex.DecodeString("1112131415161718" + "2122232425262728")
+	root := &services.StackInstructionNode{Index: -1}
+	route := &services.StackInstructionNode{Index: 0, ProgramIDIndex: 1, Data: routeData, Parent: root}
+	event := &services.StackInstructionNode{Index: 1, StackHeight: 2, ProgramIDIndex: 1, Data: eventData, Parent: route}
+	route.Children = []*services.StackInstructionNode{event}
+	root.Children = []*services.StackInstructionNode{route}
+
+	// 默认discriminator不识别
+	routes, events, err := services.FindNodesByProgramIDWithMaxDepth(keys, root, program, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(routes))
+	assert.Equal(t, 0, len(events))
+
+	// 注册新版本的discriminator后可以匹配
+	discs := services.DefaultJupiterDiscriminators()
+	discs.Route = append(discs.Route, "0102030405060708")
+	discs.Event = append(discs.Event, "11121314151617182122232425262728")
+	routes, events, err = services.FindJupiterNodes(keys, root, program, 0, discs)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(routes))
+	assert.Equal(t, route, routes[0])
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, event, events[0])
+}
+
+func Test_FindNodesByProgramID_ShortData(t *testing.T) {
+	program := solana.NewWallet().PublicKey()
+	keys := []solana.PublicKey{solana.NewWallet().PublicKey(), program}
+	routeData, _ := hex.DecodeString("e517cb977ae3ad2a")
+	root := &services.StackInstructionNode{Index: -1}
+	short := &services.StackInstructionNode{Index: 0, ProgramIDIndex: 1, Data: []byte{1, 2, 3, 4}, Parent: root}
+	route := &services.StackInstructionNode{Index: 1, ProgramIDIndex: 1, Data: routeData, Parent: root}
+	root.Children = []*services.StackInstructionNode{short, route}
+
+	// 4字节的指令被跳过，8字节的route仍能识别
+	routes, events, err := services.FindNodesByProgramIDWithMaxDepth(keys, root, program, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(routes))
+	assert.Equal(t, route, routes[0])
+	assert.Equal(t, 0, len(events))
+}
+
+// tokenBalanceTx 构造owner在单个代币账户上余额从pre变为post的交易元数据
+func tokenBalanceTx(owner, account, mint solana.PublicKey, pre, post string) (*rpc.GetTransactionResult, []solana.PublicKey) {
+	balance := func(amount string) []rpc.TokenBalance {
+		return []rpc.TokenBalance{{AccountIndex: 1, Owner: &owner, Mint: mint, UiTokenAmount: &rpc.UiTokenAmount{Amount: amount, Decimals: 6}}}
+	}
+	tx := &rpc.GetTransactionResult{Meta: &rpc.TransactionMeta{
+		PreBalances:       []uint64{1_000_000_000, 2_039_280},
+		PostBalances:      []uint64{999_995_000, 2_039_280},
+		PreTokenBalances:  balance(pre),
+		PostTokenBalances: balance(post),
+	}}
+	return tx, []solana.PublicKey{owner, account}
+}
+
+func Test_GetBalanceChanges_Signed(t *testing.T) {
+	owner := solana.NewWallet().PublicKey()
+	account := solana.NewWallet().PublicKey()
+	mint := solana.NewWallet().PublicKey()
+
+	// 余额增加为正
+	tx, keys := tokenBalanceTx(owner, account, mint, "1000000", "3500000")
+	_, changes, err := services.GetBalanceChanges(tx, keys)
+	assert.Equal(t, nil, err)
+	change := changes[owner.String()][mint.String()]
+	assert.Equal(t, "2500000", change.Amount)
+	assert.Equal(t, "2.500000", change.UiAmountString)
+	assert.Equal(t, 2.5, change.UiAmount)
+
+	// 余额减少为负
+	tx, keys = tokenBalanceTx(owner, account, mint, "3500000", "1000000")
+	_, changes, err = services.GetBalanceChanges(tx, keys)
+	assert.Equal(t, nil, err)
+	change = changes[owner.String()][mint.String()]
+	assert.Equal(t, "-2500000", change.Amount)
+	assert.Equal(t, "-2.500000", change.UiAmountString)
+	assert.Equal(t, -2.5, change.UiAmount)
+
+	// SOL同样带符号（手续费）
+	assert.Equal(t, "-5000", changes[owner.String()]["SOL"].Amount)
+}
+
+func Test_GetBalanceChanges_IndexOutOfRange(t *testing.T) {
+	owner := solana.NewWallet().PublicKey()
+	account := solana.NewWallet().PublicKey()
+	mint := solana.NewWallet().PublicKey()
+
+	// 代币余额的账户索引超出账户列表（例如缺少查找表账户）时返回错误而不是panic
+	tx, keys := tokenBalanceTx(owner, account, mint, "1000000", "3500000")
+	tx.Meta.PreBalances = tx.Meta.PreBalances[:1]
+	tx.Meta.PostBalances = tx.Meta.PostBalances[:1]
+	_, _, err := services.GetBalanceChanges(tx, keys[:1])
+	assert.NotEqual(t, nil, err)
+
+	// 交易后的代币余额索引越界
+	tx, keys = tokenBalanceTx(owner, account, mint, "1000000", "3500000")
+	tx.Meta.PostTokenBalances[0].AccountIndex = 5
+	_, _, err = services.GetBalanceChanges(tx, keys)
+	assert.NotEqual(t, nil, err)
+
+	// SOL余额数量多于账户列表
+	tx, keys = tokenBalanceTx(owner, account, mint, "1000000", "3500000")
+	tx.Meta.PreBalances = append(tx.Meta.PreBalances, 1)
+	_, _, err = services.GetBalanceChanges(tx, keys)
+	assert.NotEqual(t, nil, err)
+	tx, keys = tokenBalanceTx(owner, account, mint, "1000000", "3500000")
+	tx.Meta.PostBalances = append(tx.Meta.PostBalances, 1)
+	_, _, err = services.GetBalanceChanges(tx, keys)
+	assert.NotEqual(t, nil, err)
+}
+
+func Test_GetFullAccountKeys_V0LoadedAddresses(t *testing.T) {
+	user := solana.NewWallet().PublicKey()
+	writable := []solana.PublicKey{solana.NewWallet().PublicKey(), solana.NewWallet().PublicKey()}
+	readOnly := []solana.PublicKey{solana.NewWallet().PublicKey()}
+	fx := swapFixture{
+		User:           user,
+		V0:             true,
+		Legs:           []tokenLeg{{Mint: solana.NewWallet().PublicKey(), Decimals: 6, Pre: 0, Post: 1}},
+		LoadedWritable: writable,
+		LoadedReadOnly: readOnly,
+	}
+
+	var raw rpc.GetTransactionResult
+	if err := json.Unmarshal(buildSwapTx(t, fx), &raw); err != nil {
+		t.Fatalf("解析交易失败: %v", err)
+	}
+	msg, err := raw.Transaction.GetTransaction()
+	if err != nil {
+		t.Fatalf("解析交易消息失败: %v", err)
+	}
+	static := len(msg.Message.AccountKeys)
+
+	// 静态账户之后依次是writable和readonly的查找表账户
+	keys, err := services.GetFullAccountKeys(&raw)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, static+3, len(keys))
+	assert.Equal(t, writable, keys[static:static+2])
+	assert.Equal(t, readOnly, keys[static+2:])
+}
+
+func Test_NewTransactionResultFromRaw_V0(t *testing.T) {
+	user := solana.NewWallet().PublicKey()
+	writable := []solana.PublicKey{solana.NewWallet().PublicKey()}
+	readOnly := []solana.PublicKey{solana.NewWallet().PublicKey(), solana.NewWallet().PublicKey()}
+	mint := solana.NewWallet().PublicKey()
+	fx := swapFixture{
+		User:           user,
+		V0:             true,
+		Fee:            5000,
+		Legs:           []tokenLeg{{Mint: mint, Decimals: 6, Pre: 0, Post: 1000000}},
+		LoadedWritable: writable,
+		LoadedReadOnly: readOnly,
+	}
+
+	// 拆出getTransaction返回中的base64交易和meta，模拟调用方自行保存的原始数据
+	var envelope struct {
+		Transaction []string        `json:"transaction"`
+		Meta        json.RawMessage `json:"meta"`
+	}
+	if err := json.Unmarshal(buildSwapTx(t, fx), &envelope); err != nil {
+		t.Fatalf("解析交易失败: %v", err)
+	}
+
+	raw, err := services.NewTransactionResultFromRaw(envelope.Transaction[0], envelope.Meta, 123, 1700000000)
+	if err != nil {
+		t.Fatalf("构造交易失败: %v", err)
+	}
+	assert.Equal(t, uint64(123), raw.Slot)
+	assert.Equal(t, int64(1700000000), raw.BlockTime.Time().Unix())
+
+	msg, err := raw.Transaction.GetTransaction()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, solana.MessageVersionV0, msg.Message.GetVersion())
+	static := len(msg.Message.AccountKeys)
+
+	keys, err := services.GetFullAccountKeys(raw)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, static+3, len(keys))
+	assert.Equal(t, writable, keys[static:static+1])
+	assert.Equal(t, readOnly, keys[static+1:])
+
+	// 查找表账户之后的余额变化同样可以解析
+	_, changes, err := services.GetBalanceChanges(raw, keys)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "1000000", changes[user.String()][mint.String()].Amount)
+	assert.Equal(t, "-5000", changes[user.String()]["SOL"].Amount)
+
+	_, err = services.NewTransactionResultFromRaw("not base64!", envelope.Meta, 0, 0)
+	assert.NotEqual(t, nil, err)
+	_, err = services.NewTransactionResultFromRaw(envelope.Transaction[0], []byte("{"), 0, 0)
+	assert.NotEqual(t, nil, err)
+}
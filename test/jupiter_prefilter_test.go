@@ -0,0 +1,92 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/go-playground/assert/v2"
+	"github.com/zhinan22/DPLabsDemo/services"
+)
+
+// noopCache 不保存任何交易，模拟重启或缓存淘汰后每次都要重新获取
+type noopCache struct{}
+
+func (noopCache) Get(context.Context, string) (*services.Transaction, bool)         { return nil, false }
+func (noopCache) Set(context.Context, string, *services.Transaction, time.Duration) {}
+func (noopCache) Clear(context.Context) int                                         { return 0 }
+
+// addMixedWallet 添加plain笔不含Jupiter指令的交易和swaps笔Jupiter交易，返回钱包地址
+func addMixedWallet(t testing.TB, rpcServer *fakeRPC, plain, swaps int) string {
+	user := solana.NewWallet().PublicKey()
+	rpcServer.addSignatures(plain, time.Unix(1690000000, 0))
+	addSwaps(t, rpcServer, 1700000000, repeatSwaps(user, swaps)...)
+	return user.String()
+}
+
+func Test_GetTransactions_SkipsNonJupiter(t *testing.T) {
+	svc, rpcServer := newTestService(t, services.WithTransactionCache(noopCache{}))
+	user := addMixedWallet(t, rpcServer, 5, 3)
+
+	// 第一次获取全部交易，只返回调用Jupiter的交易
+	txs, _, err := svc.GetTransactions(context.Background(), user, 10)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+	assert.Equal(t, len(txs), 3)
+	assert.Equal(t, rpcServer.callCount("getTransaction"), 8)
+
+	// 之后只获取Jupiter交易
+	txs, _, err = svc.GetTransactions(context.Background(), user, 10)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+	assert.Equal(t, len(txs), 3)
+	assert.Equal(t, rpcServer.callCount("getTransaction"), 11)
+
+	// 清空缓存后重新判断
+	svc.ClearTransactionCache(context.Background())
+	_, _, _ = svc.GetTransactions(context.Background(), user, 10)
+	assert.Equal(t, rpcServer.callCount("getTransaction"), 19)
+}
+
+// Benchmark_GetTransactions_JupiterPrefilter 90%为非Jupiter交易的钱包，在交易缓存不命中时比较每次请求的getTransaction次数：
+// cold为首次请求（未记录非Jupiter签名），warm为之后的请求
+func Benchmark_GetTransactions_JupiterPrefilter(b *testing.B) {
+	rpcServer := newFakeRPC(b)
+	okxServer := newFakeOKX(b, "1.5")
+	user := addMixedWallet(b, rpcServer, 90, 10)
+	newService := func() *services.PnlService {
+		svc, err := services.NewPnlService(rpcServer.server.URL, jupiterPID.String(), services.OKXClient{
+			BaseUrl:              okxServer.server.URL,
+			MarketHistoricalPath: okxHistoricalPath,
+			MarketCurrentPath:    okxCurrentPath,
+		}, services.WithTransactionCache(noopCache{}))
+		if err != nil {
+			b.Fatalf("创建服务失败: %v", err)
+		}
+		return svc
+	}
+
+	run := func(b *testing.B, svc func() *services.PnlService) {
+		start := rpcServer.callCount("getTransaction")
+		for i := 0; i < b.N; i++ {
+			if _, _, err := svc().GetTransactions(context.Background(), user, 100); err != nil {
+				b.Fatalf("获取交易失败: %v", err)
+			}
+		}
+		b.ReportMetric(float64(rpcServer.callCount("getTransaction")-start)/float64(b.N), "fetches/op")
+	}
+
+	b.Run("cold", func(b *testing.B) {
+		run(b, newService)
+	})
+	b.Run("warm", func(b *testing.B) {
+		svc := newService()
+		if _, _, err := svc.GetTransactions(context.Background(), user, 100); err != nil {
+			b.Fatalf("获取交易失败: %v", err)
+		}
+		run(b, func() *services.PnlService { return svc })
+	})
+}
@@ -0,0 +1,48 @@
+package test
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/go-playground/assert/v2"
+	"github.com/zhinan22/DPLabsDemo/services"
+)
+
+// routeData 构造route类指令数据：discriminator + route_plan占位 + 尾部参数
+func routeData(disc string, amounts []uint64, slippage uint16, fee uint8) []byte {
+	data, _ := hex.DecodeString(disc)
+	// 变长route_plan，内容与解析无关
+	data = append(data, 2, 0, 0, 0, 7, 100, 0, 1, 26, 100, 1, 2)
+	for _, amount := range amounts {
+		data = binary.LittleEndian.AppendUint64(data, amount)
+	}
+	data = binary.LittleEndian.AppendUint16(data, slippage)
+	return append(data, fee)
+}
+
+func Test_DecodeJupiterRouteArgs(t *testing.T) {
+	args, err := services.DecodeJupiterRouteArgs(routeData("e517cb977ae3ad2a", []uint64{1000, 2500}, 50, 0))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "route", args.Variant)
+	assert.Equal(t, uint64(1000), args.InAmount)
+	assert.Equal(t, uint64(2500), args.QuotedOutAmount)
+	assert.Equal(t, uint16(50), args.SlippageBps)
+
+	// exact-out变体参数顺序为out_amount, quoted_in_amount
+	args, err = services.DecodeJupiterRouteArgs(routeData("d033ef977b2bed5c", []uint64{2500, 1000}, 30, 1))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, args.ExactOut)
+	assert.Equal(t, uint64(1000), args.InAmount)
+	assert.Equal(t, uint64(2500), args.QuotedOutAmount)
+	assert.Equal(t, uint8(1), args.PlatformFeeBps)
+
+	// token ledger变体没有in_amount
+	args, err = services.DecodeJupiterRouteArgs(routeData("96564774a75d0e68", []uint64{2500}, 10, 0))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(0), args.InAmount)
+	assert.Equal(t, uint64(2500), args.QuotedOutAmount)
+
+	_, err = services.DecodeJupiterRouteArgs([]byte{1, 2, 3})
+	assert.NotEqual(t, nil, err)
+}
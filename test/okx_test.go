@@ -0,0 +1,218 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/go-playground/assert/v2"
+	"github.com/zhinan22/DPLabsDemo/services"
+)
+
+func Test_GetOKXCandles(t *testing.T) {
+	svc, _, okxServer := newTestServiceWithOKX(t)
+
+	candles, err := svc.GetOKXCandles(context.Background(), usdcMint.String(), time.Now())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(candles.Records))
+	assert.Equal(t, 1.5, candles.Records[0].Close)
+	assert.Equal(t, "0", candles.Raw.Code)
+	assert.Equal(t, 8, len(candles.Raw.Data[0]))
+
+	// code不为"0"时返回OKX的msg
+	okxServer.failWithCode("50011", "Too Many Requests")
+	_, err = svc.GetOKXCandles(context.Background(), usdcMint.String(), time.Now())
+	var apiErr *services.OKXAPIError
+	assert.Equal(t, true, errors.As(err, &apiErr))
+	assert.Equal(t, "Too Many Requests", apiErr.Msg)
+}
+
+func Test_OKXTokenPriceRequest(t *testing.T) {
+	req := services.OKXTokenPriceRequest{
+		ChainIndex:           "501",
+		TokenContractAddress: usdcMint.String(),
+		After:                "1700000000000",
+		Bar:                  "1m",
+		Limit:                "5",
+	}
+	assert.Equal(t, req.String(), "after=1700000000000&bar=1m&chainIndex=501&limit=5&tokenContractAddress="+usdcMint.String())
+
+	okxServer := newFakeOKX(t, "1.5")
+	client := services.OKXClient{BaseUrl: okxServer.server.URL, MarketHistoricalPath: okxHistoricalPath}
+	req.ChainIndex = ""
+	candles, err := client.GetCandles(context.Background(), req)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(candles.Records))
+}
+
+func Test_OKXClient_HistoricalBarFallback(t *testing.T) {
+	okxServer := newFakeOKX(t, "1.5")
+	client := services.OKXClient{BaseUrl: okxServer.server.URL, MarketHistoricalPath: okxHistoricalPath}
+	tradeTime := time.Unix(1700000000, 0)
+
+	// 1s没有K线时回退到1m
+	okxServer.returnEmptyBar("1s")
+	price, err := client.HistoricalPrice(context.Background(), usdcMint.String(), tradeTime)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1.5, price.Price)
+	assert.Equal(t, []string{"1s", "1m"}, okxServer.requestedBars())
+	assert.Equal(t, true, !price.CandleTime.After(tradeTime))
+
+	// 配置的粒度之前的更细粒度不会被查询
+	client.HistoricalBar = "1H"
+	_, err = client.HistoricalPrice(context.Background(), usdcMint.String(), tradeTime)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"1s", "1m", "1H"}, okxServer.requestedBars())
+}
+
+func Test_OKXClient_ContextCancel(t *testing.T) {
+	// OKX挂起不响应，直到客户端断开
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(slow.Close)
+	client := services.OKXClient{BaseUrl: slow.URL, MarketHistoricalPath: okxHistoricalPath}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.GetTokenHistoricalPriceByTimeLatest(ctx, usdcMint.String(), "")
+	assert.Equal(t, true, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, true, time.Since(start) < time.Second)
+
+	// 未设置ctx截止时间时使用客户端超时
+	client.RequestTimeout = 50 * time.Millisecond
+	start = time.Now()
+	_, err = client.GetTokenHistoricalPriceByTimeLatest(context.Background(), usdcMint.String(), "")
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, true, time.Since(start) < time.Second)
+}
+
+func Test_OKXClient_RateLimit(t *testing.T) {
+	okxServer := newFakeOKX(t, "1.5")
+	client := services.OKXClient{
+		BaseUrl:              okxServer.server.URL,
+		MarketHistoricalPath: okxHistoricalPath,
+		Limiter:              services.NewOKXRateLimiter(20, 1),
+	}
+
+	// 每秒20次、突发1次：5次请求之间至少间隔4个50ms
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		_, err := client.GetTokenHistoricalPriceByTimeLatest(context.Background(), usdcMint.String(), "")
+		assert.Equal(t, nil, err)
+	}
+	assert.Equal(t, true, time.Since(start) >= 190*time.Millisecond)
+	assert.Equal(t, 5, okxServer.callCount())
+
+	// 等待令牌时ctx取消立即返回，不发出请求
+	client.Limiter = services.NewOKXRateLimiter(0.1, 1)
+	_, _ = client.GetTokenHistoricalPriceByTimeLatest(context.Background(), usdcMint.String(), "")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := client.GetTokenHistoricalPriceByTimeLatest(ctx, usdcMint.String(), "")
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, 6, okxServer.callCount())
+
+	// 签名时间戳在限流等待之后生成：第二次请求等待约250ms，签名不应包含等待时间
+	client.Limiter = services.NewOKXRateLimiter(4, 1)
+	for i := 0; i < 2; i++ {
+		_, err = client.GetTokenHistoricalPriceByTimeLatest(context.Background(), usdcMint.String(), "")
+		assert.Equal(t, nil, err)
+	}
+	assert.Equal(t, true, okxServer.lastSkew() < 100*time.Millisecond)
+}
+
+func Test_OKXClient_ResponseLimits(t *testing.T) {
+	okxServer := newFakeOKX(t, "1.5")
+	client := services.OKXClient{
+		BaseUrl:              okxServer.server.URL,
+		MarketHistoricalPath: okxHistoricalPath,
+		MaxResponseBytes:     16,
+	}
+
+	// 响应体超过上限时返回明确的错误
+	_, err := client.GetTokenHistoricalCandles(context.Background(), usdcMint.String(), "")
+	assert.Equal(t, true, errors.Is(err, services.ErrOKXResponseTooLarge))
+
+	client.MaxResponseBytes = 0
+	candles, err := client.GetTokenHistoricalCandles(context.Background(), usdcMint.String(), "")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(candles.Records))
+}
+
+// roundTripFunc 用函数实现http.RoundTripper，直接返回构造的响应
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func Test_OKXClient_MalformedResponse(t *testing.T) {
+	for name, body := range map[string]string{
+		"truncated": `{"code":"0","msg":"","data":[["1700000000000","1.5"`,
+		"bad field": `{"code":"0","msg":"","data":[["1700000000000","1.5","1.5","1.5","abc","0","0","1"]]}`,
+	} {
+		client := services.OKXClient{
+			BaseUrl:              "http://okx.invalid",
+			MarketHistoricalPath: okxHistoricalPath,
+			MarketCurrentPath:    okxCurrentPath,
+			HTTPClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: make(http.Header)}, nil
+			})},
+		}
+
+		// 返回错误而不是退出进程
+		_, err := client.GetTokenHistoricalPriceByTimeLatest(context.Background(), usdcMint.String(), "")
+		assert.Equal(t, true, errors.Is(err, services.ErrOKXInvalidResponse))
+		_, err = client.GetTokenCurrentPrice(context.Background(), usdcMint.String())
+		assert.Equal(t, true, errors.Is(err, services.ErrOKXInvalidResponse))
+		if t.Failed() {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+}
+
+func Test_CalculatePnL_EmptyPriceData(t *testing.T) {
+	svc, rpcServer, okxServer := newTestServiceWithOKX(t)
+	okxServer.returnEmptyData()
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+	addSwaps(t, rpcServer, 1700000000, stableSwap(user, token, 100_000_000, 100_000_000, true))
+
+	txs, _, err := svc.GetTransactions(context.Background(), user.String(), 100)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+	// 持仓中需要当前价格，OKX没有K线时返回错误而不是panic
+	_, err = svc.CalculatePnL(context.Background(), txs, user.String(), token.String())
+	assert.Equal(t, true, errors.Is(err, services.ErrNoPriceData))
+
+	// 用其他代币买入需要历史价格，在查询当前价格之前失败
+	other := solana.NewWallet().PublicKey()
+	addSwaps(t, rpcServer, 1700000100, swapFixture{
+		User: user,
+		Fee:  5000,
+		Legs: []tokenLeg{
+			{Mint: other, Decimals: 6, Pre: 50_000_000, Post: 0},
+			{Mint: token, Decimals: 6, Pre: 100_000_000, Post: 150_000_000},
+		},
+		Hops: []swapHop{{InputMint: other, InputAmount: 50_000_000, OutputMint: token, OutputAmount: 50_000_000}},
+	})
+	txs, _, err = svc.GetTransactions(context.Background(), user.String(), 100)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+	_, err = svc.CalculatePnL(context.Background(), txs, user.String(), token.String())
+	assert.Equal(t, true, errors.Is(err, services.ErrNoPriceData))
+	assert.Equal(t, true, strings.Contains(err.Error(), "2023-11-14T22:15:00Z"))
+}
@@ -0,0 +1,1105 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/go-playground/assert/v2"
+	"github.com/zhinan22/DPLabsDemo/services"
+)
+
+// calculatePnL 获取用户全部交易并计算目标代币的PnL
+func calculatePnL(t *testing.T, svc *services.PnlService, user, token solana.PublicKey) []services.PnLResult {
+	t.Helper()
+	txs, _, err := svc.GetTransactions(context.Background(), user.String(), 100)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+	results, err := svc.CalculatePnL(context.Background(), txs, user.String(), token.String())
+	if err != nil {
+		t.Fatalf("计算PnL失败: %v", err)
+	}
+	return results
+}
+
+func Test_CalculatePnL_ProbeBuyFilter(t *testing.T) {
+	svc, rpcServer := newTestService(t, services.WithProbeBuyFraction(0.01))
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+
+	// 试探性买入1个（单价10），主建仓1000个（单价1），之后全部卖出
+	sigs := addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 10_000_000, 1_000_000, true),
+		stableSwap(user, token, 1000_000_000, 1000_000_000, true),
+		stableSwap(user, token, 2002_000_000, 1001_000_000, false),
+	)
+
+	results := calculatePnL(t, svc, user, token)
+	assert.Equal(t, len(results), 1)
+	assert.Equal(t, results[0].AverageCost, float64(1))
+	assert.NotEqual(t, results[0].ProbeFilter, nil)
+	assert.Equal(t, results[0].ProbeFilter.IgnoredBuys, 1)
+	assert.Equal(t, results[0].ProbeFilter.IgnoredSignatures, []string{sigs[0]})
+	assert.Equal(t, results[0].ProbeFilter.IgnoredCostUSD, float64(10))
+	assert.Equal(t, results[0].IsClosed, true)
+}
+
+func Test_CalculatePnL_SkipUnpriceable(t *testing.T) {
+	svc, rpcServer, okxServer := newTestServiceWithOKX(t)
+	okxServer.failPricesBefore(time.Unix(1750000000, 0))
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+	other := solana.NewWallet().PublicKey()
+
+	// 第一笔用USDC买入可直接定价，第二笔用其他代币买入需要OKX历史价格
+	sigs := addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		swapFixture{
+			User: user,
+			Fee:  5000,
+			Legs: []tokenLeg{
+				{Mint: other, Decimals: 6, Pre: 50_000_000, Post: 0},
+				{Mint: token, Decimals: 6, Pre: 0, Post: 50_000_000},
+			},
+			Hops: []swapHop{{InputMint: other, InputAmount: 50_000_000, OutputMint: token, OutputAmount: 50_000_000}},
+		},
+	)
+	txs, _, err := svc.GetTransactions(context.Background(), user.String(), 100)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+
+	// 默认严格模式整体失败
+	_, err = svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{})
+	assert.NotEqual(t, err, nil)
+
+	calc, err := svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{SkipUnpriceable: true})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(calc.Results), 1)
+	assert.Equal(t, calc.Results[0].TotalInvestment, float64(100))
+	assert.Equal(t, len(calc.Warnings), 1)
+	assert.Equal(t, strings.Contains(calc.Warnings[0], sigs[1]), true)
+	// 被跳过的订单仍由Jupiter解析器解析出来
+	assert.Equal(t, calc.OrdersBySource, map[string]int{services.OrderSourceJupiter: 2})
+}
+
+func Test_CalculateWalletPnL(t *testing.T) {
+	svc, rpcServer, okxServer := newTestServiceWithOKX(t)
+	okxServer.failPricesBefore(time.Unix(1750000000, 0))
+	user := solana.NewWallet().PublicKey()
+	tokenA := solana.NewWallet().PublicKey()
+	tokenB := solana.NewWallet().PublicKey()
+	tokenC := solana.NewWallet().PublicKey()
+	other := solana.NewWallet().PublicKey()
+
+	addSwaps(t, rpcServer, 1700000000,
+		// A：已实现盈利10
+		stableSwap(user, tokenA, 100_000_000, 100_000_000, true),
+		stableSwap(user, tokenA, 110_000_000, 100_000_000, false),
+		// B：持仓100个，成本1，当前价格1.5，未实现盈利50
+		stableSwap(user, tokenB, 100_000_000, 100_000_000, true),
+		// C：用其他代币买入，历史价格缺失无法定价
+		swapFixture{
+			User: user,
+			Fee:  5000,
+			Legs: []tokenLeg{
+				{Mint: other, Decimals: 6, Pre: 50_000_000, Post: 0},
+				{Mint: tokenC, Decimals: 6, Pre: 0, Post: 50_000_000},
+			},
+			Hops: []swapHop{{InputMint: other, InputAmount: 50_000_000, OutputMint: tokenC, OutputAmount: 50_000_000}},
+		},
+	)
+	txs, _, err := svc.GetTransactions(context.Background(), user.String(), 100)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+
+	wallet := svc.CalculateWalletPnL(context.Background(), txs, user.String(), services.PnLOptions{})
+	assert.Equal(t, wallet.TotalRealized, float64(10))
+	assert.Equal(t, wallet.TotalUnrealized, float64(50))
+	assert.Equal(t, wallet.Total, float64(60))
+	assert.Equal(t, len(wallet.Tokens), 2)
+	assert.Equal(t, wallet.Tokens[0].Mint, tokenB.String())
+
+	unpriceable := map[string]bool{}
+	for _, token := range wallet.Unpriceable {
+		unpriceable[token.Mint] = true
+	}
+	assert.Equal(t, unpriceable, map[string]bool{tokenC.String(): true, other.String(): true})
+}
+
+func Test_CalculatePnL_PositionModel(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+
+	// 以1买入100个，120卖出平仓；再以2买入100个，250卖出平仓
+	addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 120_000_000, 100_000_000, false),
+		stableSwap(user, token, 200_000_000, 100_000_000, true),
+		stableSwap(user, token, 250_000_000, 100_000_000, false),
+	)
+	txs, _, err := svc.GetTransactions(context.Background(), user.String(), 100)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+
+	t.Run("perCycle", func(t *testing.T) {
+		calc, err := svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(),
+			services.PnLOptions{PositionModel: services.PositionModelPerCycle})
+		assert.Equal(t, err, nil)
+		results := calc.Results
+		assert.Equal(t, len(results), 2)
+		assert.Equal(t, results[0].AverageCost, float64(1))
+		assert.Equal(t, results[0].ProfitLossValue, float64(20))
+		assert.Equal(t, results[1].AverageCost, float64(2))
+		assert.Equal(t, results[1].ProfitLossValue, float64(50))
+	})
+
+	t.Run("lifetime", func(t *testing.T) {
+		calc, err := svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(),
+			services.PnLOptions{PositionModel: services.PositionModelLifetime})
+		assert.Equal(t, err, nil)
+		results := calc.Results
+		assert.Equal(t, len(results), 1)
+		// 平均成本跨周期累计：(100+200)/200
+		assert.Equal(t, results[0].AverageCost, 1.5)
+		// 第一次卖出按成本1实现20，第二次按成本1.5实现100
+		assert.Equal(t, results[0].ProfitLossValue, float64(120))
+		assert.Equal(t, results[0].IsClosed, true)
+		assert.Equal(t, results[0].TotalInvestment, float64(300))
+	})
+
+	t.Run("lifetime sell while flat", func(t *testing.T) {
+		// 平仓后、再次买入前卖出50个，没有持仓，不按历史平均成本计入已实现盈亏
+		flatUser := solana.NewWallet().PublicKey()
+		sigs := addSwaps(t, rpcServer, 1700001000,
+			stableSwap(flatUser, token, 100_000_000, 100_000_000, true),
+			stableSwap(flatUser, token, 120_000_000, 100_000_000, false),
+			stableSwap(flatUser, token, 60_000_000, 50_000_000, false),
+			stableSwap(flatUser, token, 200_000_000, 100_000_000, true),
+			stableSwap(flatUser, token, 250_000_000, 100_000_000, false),
+		)
+		txs, _, err := svc.GetTransactions(context.Background(), flatUser.String(), 100)
+		if err != nil {
+			t.Fatalf("获取交易失败: %v", err)
+		}
+		calc, err := svc.CalculatePnLWithOptions(context.Background(), txs, flatUser.String(), token.String(),
+			services.PnLOptions{PositionModel: services.PositionModelLifetime})
+		assert.Equal(t, err, nil)
+		assert.Equal(t, len(calc.Results), 1)
+		assert.Equal(t, calc.Results[0].AverageCost, 1.5)
+		assert.Equal(t, calc.Results[0].ProfitLossValue, float64(120))
+		assert.Equal(t, calc.Results[0].IsClosed, true)
+		assert.Equal(t, len(calc.UnmatchedSells), 1)
+		assert.Equal(t, calc.UnmatchedSells[0].Signature, sigs[2])
+		assert.Equal(t, calc.UnmatchedSells[0].Quantity, float64(50))
+		assert.Equal(t, calc.UnmatchedSells[0].ProceedsUSD, float64(60))
+	})
+}
+
+func Test_CalculatePnL_Rounded(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+
+	// 2 USDC买入3个，平均成本为2/3
+	addSwaps(t, rpcServer, 1700000000, stableSwap(user, token, 2_000_000, 3_000_000, true))
+
+	results := calculatePnL(t, svc, user, token)
+	assert.Equal(t, len(results), 1)
+	assert.Equal(t, results[0].AverageCost, 0.666666666)
+	assert.NotEqual(t, results[0].Rounded, nil)
+	assert.Equal(t, results[0].Rounded.AverageCost, 0.666666667)
+	// 当前价格1.5：3*1.5-2=2.5
+	assert.Equal(t, results[0].Rounded.UnrealizedProfitLossValue, 2.5)
+}
+
+func Test_CalculatePnL_RoundTrips(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+
+	// 相邻slot内一买一卖（亏损），很久之后再正常买入
+	sigs := addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 90_000_000, 100_000_000, false),
+	)
+	addSwaps(t, rpcServer, 1700001000, stableSwap(user, token, 10_000_000, 10_000_000, true))
+	txs, _, err := svc.GetTransactions(context.Background(), user.String(), 100)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+
+	// 默认不检测
+	calc, err := svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(calc.RoundTrips), 0)
+	assert.Equal(t, len(calc.Results), 2)
+
+	// 只标记不排除
+	calc, err = svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{RoundTripSlots: 2})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(calc.RoundTrips), 1)
+	assert.Equal(t, calc.RoundTrips[0].OpenSignature, sigs[0])
+	assert.Equal(t, calc.RoundTrips[0].CloseSignature, sigs[1])
+	assert.Equal(t, calc.RoundTrips[0].OpenedWithBuy, true)
+	assert.Equal(t, calc.RoundTrips[0].SlotDistance, uint64(1))
+	assert.Equal(t, len(calc.Results), 2)
+
+	// 排除后只剩后来的买入
+	calc, err = svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{RoundTripSlots: 2, ExcludeRoundTrips: true})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(calc.Results), 1)
+	assert.Equal(t, calc.Results[0].TotalInvestment, float64(10))
+}
+
+func Test_CalculatePnL_RoundTripsMultiRoute(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+
+	// 买入100个；下一个slot的交易有两个route：卖出100个（与买入构成往返）和另外买入10个
+	addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		swapFixture{
+			User: user,
+			Legs: []tokenLeg{
+				{Mint: token, Decimals: 6, Pre: 100_000_000, Post: 10_000_000},
+				{Mint: usdcMint, Decimals: 6, Pre: 10_000_000, Post: 90_000_000},
+			},
+			Routes: [][]swapHop{
+				{{InputMint: token, InputAmount: 100_000_000, OutputMint: usdcMint, OutputAmount: 90_000_000}},
+				{{InputMint: usdcMint, InputAmount: 10_000_000, OutputMint: token, OutputAmount: 10_000_000}},
+			},
+		},
+	)
+	txs, _, err := svc.GetTransactions(context.Background(), user.String(), 100)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+
+	// 只排除往返的两笔订单，同一交易中的另一个route保留
+	calc, err := svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{RoundTripSlots: 2, ExcludeRoundTrips: true})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(calc.RoundTrips), 1)
+	assert.Equal(t, len(calc.Results), 1)
+	assert.Equal(t, calc.Results[0].TotalInvestment, float64(10))
+}
+
+func Test_CalculatePnL_MinUSDValue(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+
+	// 0.001 USDC买入1个（粉尘），100 USDC买入100个
+	addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 1_000, 1_000_000, true),
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+	)
+	txs, _, err := svc.GetTransactions(context.Background(), user.String(), 100)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+
+	// 默认不过滤，粉尘买入拉低平均成本
+	calc, err := svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(calc.Results[0].Trades), 2)
+	assert.Equal(t, calc.Results[0].AverageCost < 1, true)
+
+	calc, err = svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{MinUSDValue: 0.01})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(calc.Results), 1)
+	assert.Equal(t, len(calc.Results[0].Trades), 1)
+	assert.Equal(t, calc.Results[0].AverageCost, float64(1))
+	assert.Equal(t, calc.Results[0].TotalInvestment, float64(100))
+}
+
+func Test_DiscoverTradedMints_Incremental(t *testing.T) {
+	svc, rpcServer := newTestService(t, services.WithMintDiscovery(2, 1))
+	user := solana.NewWallet().PublicKey()
+	tokenA := solana.NewWallet().PublicKey()
+	tokenB := solana.NewWallet().PublicKey()
+	tokenC := solana.NewWallet().PublicKey()
+
+	addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, tokenA, 100_000_000, 100_000_000, true),
+		stableSwap(user, tokenB, 100_000_000, 100_000_000, true),
+		stableSwap(user, tokenA, 110_000_000, 100_000_000, false),
+	)
+	txs, _, err := svc.GetTransactions(context.Background(), user.String(), 100)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+	assert.Equal(t, svc.DiscoverTradedMints(context.Background(), txs, user.String()), []string{tokenA.String(), tokenB.String()})
+
+	// 已扫描的交易使用缓存结果：即使交易内容被替换为普通转账，仍返回原来发现的代币
+	var plain rpc.GetTransactionResult
+	if err := json.Unmarshal(emptyTransaction(fakeSignature{}), &plain); err != nil {
+		t.Fatalf("解析交易失败: %v", err)
+	}
+	replaced := make([]*services.Transaction, len(txs))
+	for i, tx := range txs {
+		replaced[i] = &services.Transaction{Signature: tx.Signature, Slot: tx.Slot, BlockTime: tx.BlockTime, RawTx: &plain}
+	}
+	assert.Equal(t, svc.DiscoverTradedMints(context.Background(), replaced, user.String()), []string{tokenA.String(), tokenB.String()})
+
+	// 新交易增量解析
+	addSwaps(t, rpcServer, 1700000100, stableSwap(user, tokenC, 100_000_000, 100_000_000, true))
+	txs, _, err = svc.GetTransactions(context.Background(), user.String(), 100)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+	assert.Equal(t, svc.DiscoverTradedMints(context.Background(), txs, user.String()), []string{tokenA.String(), tokenB.String(), tokenC.String()})
+}
+
+func Test_CalculatePnL_PriceSource(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+	other := solana.NewWallet().PublicKey()
+
+	// 第一笔用USDC买入，第二笔用其他代币买入需要OKX历史价格
+	sigs := addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		swapFixture{
+			User: user,
+			Fee:  5000,
+			Legs: []tokenLeg{
+				{Mint: other, Decimals: 6, Pre: 50_000_000, Post: 0},
+				{Mint: token, Decimals: 6, Pre: 0, Post: 50_000_000},
+			},
+			Hops: []swapHop{{InputMint: other, InputAmount: 50_000_000, OutputMint: token, OutputAmount: 50_000_000}},
+		},
+	)
+
+	results := calculatePnL(t, svc, user, token)
+	assert.Equal(t, len(results), 1)
+	pricing := results[0].Pricing
+	assert.Equal(t, len(pricing), 2)
+	assert.Equal(t, pricing[0].Signature, sigs[0])
+	assert.Equal(t, pricing[0].Source, services.PriceSourceStablecoin)
+	assert.Equal(t, pricing[0].CandleTime == nil, true)
+	assert.Equal(t, pricing[1].Source, services.PriceSourceHistorical)
+	assert.Equal(t, pricing[1].Price, 1.5)
+	assert.Equal(t, pricing[1].CandleTime != nil, true)
+
+	assert.NotEqual(t, results[0].CurrentPrice, nil)
+	assert.Equal(t, results[0].CurrentPrice.Source, services.PriceSourceCurrent)
+}
+
+func Test_CalculatePnL_StablecoinValuation(t *testing.T) {
+	usdt := solana.MustPublicKeyFromBase58("Es9vMFrzaCERmJfrF4H2FYD4KCoNkY11McCe8BenwNYB")
+	// quoteSwap 用quote代币买入（buy=true）或卖出token，数量均为6位小数的原始数量
+	quoteSwap := func(user, token, quote solana.PublicKey, quoteAmount, tokenAmount uint64, buy bool) swapFixture {
+		fx := stableSwap(user, token, quoteAmount, tokenAmount, buy)
+		for i := range fx.Legs {
+			if fx.Legs[i].Mint.Equals(usdcMint) {
+				fx.Legs[i].Mint = quote
+			}
+		}
+		for i := range fx.Hops {
+			if fx.Hops[i].InputMint.Equals(usdcMint) {
+				fx.Hops[i].InputMint = quote
+			}
+			if fx.Hops[i].OutputMint.Equals(usdcMint) {
+				fx.Hops[i].OutputMint = quote
+			}
+		}
+		return fx
+	}
+
+	// 默认稳定币：USDC买入100个花250，USDT卖出50个得150，都按1:1计价，不受OKX价格(1.5)影响
+	svc, rpcServer, okxServer := newTestServiceWithOKX(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+	addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 250_000_000, 100_000_000, true),
+		quoteSwap(user, token, usdt, 150_000_000, 50_000_000, false),
+	)
+
+	results := calculatePnL(t, svc, user, token)
+	assert.Equal(t, len(results), 1)
+	assert.Equal(t, results[0].TotalInvestment, float64(250))
+	assert.Equal(t, results[0].AverageCost, 2.5)
+	assert.Equal(t, results[0].ProfitLossValue, float64(25))
+	assert.Equal(t, results[0].Pricing[0].Source, services.PriceSourceStablecoin)
+	assert.Equal(t, results[0].Pricing[0].Price, 2.5)
+	assert.Equal(t, results[0].Pricing[1].Source, services.PriceSourceStablecoin)
+	assert.Equal(t, results[0].Pricing[1].Price, float64(3))
+	// 只查询了一次代币当前价格，没有历史价格查询
+	assert.Equal(t, okxServer.callCount(), 1)
+
+	// 自定义稳定币列表覆盖默认值：USDC不再按1:1计价
+	custom := solana.NewWallet().PublicKey()
+	svc, rpcServer = newTestService(t, services.WithStablecoins([]string{custom.String()}))
+	addSwaps(t, rpcServer, 1700000000,
+		quoteSwap(user, token, custom, 250_000_000, 100_000_000, true),
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+	)
+
+	results = calculatePnL(t, svc, user, token)
+	assert.Equal(t, len(results), 1)
+	assert.Equal(t, results[0].Pricing[0].Source, services.PriceSourceStablecoin)
+	assert.Equal(t, results[0].Pricing[1].Source, services.PriceSourceHistorical)
+	assert.Equal(t, results[0].TotalInvestment, float64(400))
+}
+
+func Test_CalculatePnL_ExecutionVWAP(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+	mid := solana.NewWallet().PublicKey()
+
+	// 1 SOL拆成两条路由买入5个：0.6 SOL直接换3个，0.4 SOL经中间代币换2个
+	sigs := addSwaps(t, rpcServer, 1700000000, swapFixture{
+		User: user,
+		Fee:  5000,
+		Legs: []tokenLeg{
+			{Mint: solana.SolMint, Decimals: 9, Pre: 10_000_000_000, Post: 9_000_000_000},
+			{Mint: token, Decimals: 6, Pre: 0, Post: 5_000_000},
+		},
+		Hops: []swapHop{
+			{InputMint: solana.SolMint, InputAmount: 600_000_000, OutputMint: token, OutputAmount: 3_000_000},
+			{InputMint: solana.SolMint, InputAmount: 400_000_000, OutputMint: mid, OutputAmount: 7_000_000},
+			{InputMint: mid, InputAmount: 7_000_000, OutputMint: token, OutputAmount: 2_000_000},
+		},
+	})
+
+	orders, _, err := svc.GetTransactionOrders(context.Background(), sigs[0], user.String(), token.String(), false)
+	if err != nil {
+		t.Fatalf("解析订单失败: %v", err)
+	}
+	assert.Equal(t, len(orders), 1)
+	assert.Equal(t, orders[0].Execution, &services.ExecutionPrice{QuoteMint: "SOL", BaseAmount: 5_000_000, QuoteAmount: 1_000_000_000, Price: 0.2})
+
+	// 成本按成交均价0.2 SOL乘以SOL价格1.5计算，而不是目标代币的K线价格；另加5000 lamports手续费(0.0000075美元)
+	results := calculatePnL(t, svc, user, token)
+	assert.Equal(t, len(results), 1)
+	assert.Equal(t, results[0].Rounded.AverageCost, 0.3000015)
+	assert.Equal(t, results[0].Pricing[0].Source, services.PriceSourceVWAP)
+}
+
+func Test_CalculatePnL_PriceCache(t *testing.T) {
+	// 成交均价和手续费都需要交易时的SOL价格，另需一次代币当前价格
+	solBuy := func(user, token solana.PublicKey) swapFixture {
+		return swapFixture{
+			User: user,
+			Fee:  5000,
+			Legs: []tokenLeg{
+				{Mint: solana.SolMint, Decimals: 9, Pre: 10_000_000_000, Post: 9_000_000_000},
+				{Mint: token, Decimals: 6, Pre: 0, Post: 5_000_000},
+			},
+			Hops: []swapHop{{InputMint: solana.SolMint, InputAmount: 1_000_000_000, OutputMint: token, OutputAmount: 5_000_000}},
+		}
+	}
+
+	for ttl, okxCalls := range map[time.Duration]int{services.DefaultPriceCacheTTL: 2, 0: 3} {
+		svc, rpcServer, okxServer := newTestServiceWithOKX(t, services.WithPriceCacheTTL(ttl))
+		user := solana.NewWallet().PublicKey()
+		token := solana.NewWallet().PublicKey()
+		addSwaps(t, rpcServer, 1700000000, solBuy(user, token))
+
+		results := calculatePnL(t, svc, user, token)
+		assert.Equal(t, len(results), 1)
+		// 同一时间的SOL价格只查询一次
+		assert.Equal(t, okxServer.callCount(), okxCalls)
+	}
+}
+
+func Test_CalculatePnL_CurrentPriceCache(t *testing.T) {
+	svc, rpcServer, okxServer := newTestServiceWithOKX(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+	// 稳定币买入不查询历史价格，只有未实现盈亏需要代币当前价格
+	addSwaps(t, rpcServer, 1700000000, stableSwap(user, token, 100_000_000, 100_000_000, true))
+
+	// 当前价格按代币缓存，短时间内的重复计算只查询一次
+	calculatePnL(t, svc, user, token)
+	calculatePnL(t, svc, user, token)
+	assert.Equal(t, okxServer.callCount(), 1)
+
+	// 超过DefaultCurrentPriceCacheTTL后重新查询
+	time.Sleep(services.DefaultCurrentPriceCacheTTL + 100*time.Millisecond)
+	calculatePnL(t, svc, user, token)
+	assert.Equal(t, okxServer.callCount(), 2)
+}
+
+func Test_CalculatePnL_PriceRefresher(t *testing.T) {
+	svc, rpcServer, okxServer := newTestServiceWithOKX(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+	// 稳定币买入按1:1计价，不需要查询历史价格
+	addSwaps(t, rpcServer, 1700000000, stableSwap(user, token, 100_000_000, 100_000_000, true))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	// 启动时立即刷新一次，之后的周期在测试期间不会到达
+	svc.StartPriceRefresher(ctx, []string{token.String()}, time.Hour)
+	deadline := time.Now().Add(time.Second)
+	for okxServer.callCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, okxServer.callCount(), 1)
+
+	// 刷新后价格变化，计算仍使用缓存的1.5，不再请求OKX
+	okxServer.setPrice("3")
+	results := calculatePnL(t, svc, user, token)
+	assert.Equal(t, len(results), 1)
+	assert.Equal(t, results[0].CurrentPrice.Price, 1.5)
+	assert.Equal(t, results[0].UnrealizedProfitLossValue, float64(50))
+	assert.Equal(t, okxServer.callCount(), 1)
+
+	// 不在watchlist中的代币实时查询
+	other := solana.NewWallet().PublicKey()
+	addSwaps(t, rpcServer, 1700000100, stableSwap(user, other, 100_000_000, 100_000_000, true))
+	results = calculatePnL(t, svc, user, other)
+	assert.Equal(t, results[0].CurrentPrice.Price, float64(3))
+	assert.Equal(t, okxServer.callCount(), 2)
+}
+
+func Test_CalculatePnLFromOrders(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	token := solana.NewWallet().PublicKey().String()
+	leg := func(mint, amount string) services.OrderTokenInfo {
+		return services.OrderTokenInfo{Mint: mint, UiTokenAmount: rpc.UiTokenAmount{Amount: amount, Decimals: 6}}
+	}
+
+	// 传入顺序与时间顺序相反：先买100个花100 USDC，再以120 USDC全部卖出
+	orders := []services.Order{
+		{Signature: "sell", BlockTime: time.Unix(1700000100, 0), SellToken: leg(token, "100000000"), BuyToken: leg(usdcMint.String(), "120000000")},
+		{Signature: "buy", BlockTime: time.Unix(1700000000, 0), SellToken: leg(usdcMint.String(), "100000000"), BuyToken: leg(token, "100000000")},
+	}
+	results, err := svc.CalculatePnLFromOrders(context.Background(), orders, token)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(results), 1)
+	assert.Equal(t, results[0].IsClosed, true)
+	assert.Equal(t, results[0].ProfitLossValue, float64(20))
+	assert.Equal(t, orders[0].Signature, "sell")
+
+	// 不访问RPC
+	assert.Equal(t, rpcServer.callCount("getTransaction"), 0)
+}
+
+func Test_CalculatePnLFromOrders_LargeAmount(t *testing.T) {
+	svc, _ := newTestService(t)
+	token := solana.NewWallet().PublicKey().String()
+	usdc := func(amount string) services.OrderTokenInfo {
+		return services.OrderTokenInfo{Mint: usdcMint.String(), UiTokenAmount: rpc.UiTokenAmount{Amount: amount, Decimals: 6}}
+	}
+	// 原始数量超过int64上限
+	big := services.OrderTokenInfo{Mint: token, UiTokenAmount: rpc.UiTokenAmount{Amount: "18446744073709551615", Decimals: 9}}
+
+	orders := []services.Order{
+		{Signature: "buy", BlockTime: time.Unix(1700000000, 0), SellToken: usdc("1000000000"), BuyToken: big},
+		{Signature: "sell", BlockTime: time.Unix(1700000100, 0), SellToken: big, BuyToken: usdc("2000000000")},
+	}
+	results, err := svc.CalculatePnLFromOrders(context.Background(), orders, token)
+	if err != nil {
+		t.Fatalf("计算PnL失败: %v", err)
+	}
+	assert.Equal(t, len(results), 1)
+	assert.Equal(t, results[0].IsClosed, true)
+	assert.Equal(t, results[0].ProfitLossValue, float64(1000))
+	assert.Equal(t, results[0].Rounded.AverageCost, 0.000000054)
+}
+
+func Test_CalculatePnLFromOrders_ManySmallTrades(t *testing.T) {
+	token := solana.NewWallet().PublicKey().String()
+	leg := func(mint, amount string) services.OrderTokenInfo {
+		return services.OrderTokenInfo{Mint: mint, UiTokenAmount: rpc.UiTokenAmount{Amount: amount, Decimals: 6}}
+	}
+
+	// 1000笔买入，每笔花0.1 USDC买1个，最后以110 USDC全部卖出
+	const n = 1000
+	orders := func(fee uint64) []services.Order {
+		var orders []services.Order
+		for i := 0; i < n; i++ {
+			orders = append(orders, services.Order{
+				Signature: fmt.Sprintf("buy-%d", i),
+				BlockTime: time.Unix(1700000000+int64(i), 0),
+				Fee:       fee,
+				SellToken: leg(usdcMint.String(), "100000"),
+				BuyToken:  leg(token, "1000000"),
+			})
+		}
+		return append(orders, services.Order{Signature: "sell", BlockTime: time.Unix(1700000000+n, 0), Fee: fee, SellToken: leg(token, "1000000000"), BuyToken: leg(usdcMint.String(), "110000000")})
+	}
+	// float64逐笔累加有误差
+	var floatSum float64
+	for i := 0; i < n; i++ {
+		floatSum += 0.1 + 0.0000015
+	}
+	assert.NotEqual(t, floatSum, 100.0015)
+
+	for _, method := range []services.CostBasisMethod{services.CostBasisAverage, services.CostBasisFIFO} {
+		svc, _ := newTestService(t, services.WithCostBasisMethod(method))
+
+		results, err := svc.CalculatePnLFromOrders(context.Background(), orders(0), token)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, len(results), 1)
+		assert.Equal(t, results[0].IsClosed, true)
+		assert.Equal(t, results[0].TotalInvestment, float64(100))
+		assert.Equal(t, results[0].AverageCost, 0.1)
+		assert.Equal(t, results[0].ProfitLossValue, float64(10))
+		assert.Equal(t, results[0].ProfitLossPercentage, "10.00%")
+
+		// 每笔1000 lamports手续费，SOL价格1.5，即每笔0.0000015美元
+		results, err = svc.CalculatePnLFromOrders(context.Background(), orders(1000), token)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, len(results), 1)
+		assert.Equal(t, results[0].IsClosed, true)
+		assert.Equal(t, results[0].TotalInvestment, 100.0015)
+		assert.Equal(t, results[0].AverageCost, 0.1000015)
+		// 110 - 0.0000015 - 100.0015
+		assert.Equal(t, results[0].ProfitLossValue, 9.9984985)
+	}
+}
+
+func Test_CalculatePnL_PriceProvider(t *testing.T) {
+	jupiter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mint := r.URL.Query().Get("ids")
+		fmt.Fprintf(w, `{"data":{%q:{"id":%q,"price":"3"}}}`, mint, mint)
+	}))
+	t.Cleanup(jupiter.Close)
+
+	svc, rpcServer := newTestService(t, services.WithJupiterPriceURL(jupiter.URL))
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+	other := solana.NewWallet().PublicKey()
+
+	// 用其他代币买入，成本和当前价格都来自价格提供方；手续费0.01 SOL
+	addSwaps(t, rpcServer, 1700000000, swapFixture{
+		User: user,
+		Fee:  10_000_000,
+		Legs: []tokenLeg{
+			{Mint: other, Decimals: 6, Pre: 50_000_000, Post: 0},
+			{Mint: token, Decimals: 6, Pre: 0, Post: 50_000_000},
+		},
+		Hops: []swapHop{{InputMint: other, InputAmount: 50_000_000, OutputMint: token, OutputAmount: 50_000_000}},
+	})
+	txs, _, err := svc.GetTransactions(context.Background(), user.String(), 100)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+
+	for _, tc := range []struct {
+		opts       services.PnLOptions
+		provider   string
+		price      float64
+		investment float64
+	}{
+		// 50个代币加上按SOL价格换算的手续费
+		{services.PnLOptions{}, services.PriceProviderOKX, 1.5, 75.015},
+		// 固定价格只用于目标代币，手续费仍按OKX的SOL价格1.5计
+		{services.PnLOptions{PriceProvider: services.PriceProviderFixed, FixedPrice: 2}, services.PriceProviderFixed, 2, 100.015},
+		{services.PnLOptions{PriceProvider: services.PriceProviderJupiter}, services.PriceProviderJupiter, 3, 150.03},
+	} {
+		calc, err := svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), tc.opts)
+		if err != nil {
+			t.Fatalf("计算PnL失败: %v", err)
+		}
+		assert.Equal(t, len(calc.Results), 1)
+		result := calc.Results[0]
+		assert.Equal(t, result.Pricing[0].Provider, tc.provider)
+		assert.Equal(t, result.Pricing[0].Price, tc.price)
+		assert.Equal(t, result.CurrentPrice.Provider, tc.provider)
+		assert.Equal(t, result.TotalInvestment, tc.investment)
+	}
+
+	// SOL报价的买入：固定价格优先于成交均价，以SOL计价时按OKX的SOL价格换算
+	solUser := solana.NewWallet().PublicKey()
+	addSwaps(t, rpcServer, 1700000100, swapFixture{
+		User: solUser,
+		Fee:  10_000_000,
+		Legs: []tokenLeg{
+			{Mint: solana.SolMint, Decimals: 9, Pre: 10_000_000_000, Post: 9_000_000_000},
+			{Mint: token, Decimals: 6, Pre: 0, Post: 5_000_000},
+		},
+		Hops: []swapHop{{InputMint: solana.SolMint, InputAmount: 1_000_000_000, OutputMint: token, OutputAmount: 5_000_000}},
+	})
+	txs, _, err = svc.GetTransactions(context.Background(), solUser.String(), 100)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+	for _, tc := range []struct {
+		denom       string
+		averageCost float64
+	}{
+		// 5个代币按固定价格2计为10美元，加0.015美元手续费
+		{"", 2.003},
+		// 10.015美元 / SOL价格1.5 / 5
+		{services.DenominationSOL, 1.335333333},
+	} {
+		calc, err := svc.CalculatePnLWithOptions(context.Background(), txs, solUser.String(), token.String(), services.PnLOptions{
+			PriceProvider: services.PriceProviderFixed,
+			FixedPrice:    2,
+			Denomination:  tc.denom,
+		})
+		if err != nil {
+			t.Fatalf("计算PnL失败: %v", err)
+		}
+		assert.Equal(t, len(calc.Results), 1)
+		assert.Equal(t, calc.Results[0].Pricing[0].Source, services.PriceSourceHistorical)
+		assert.Equal(t, calc.Results[0].Pricing[0].Provider, services.PriceProviderFixed)
+		assert.Equal(t, calc.Results[0].Rounded.AverageCost, tc.averageCost)
+	}
+
+	// fixed缺少价格、未知提供方都返回错误
+	_, err = svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{PriceProvider: services.PriceProviderFixed})
+	assert.NotEqual(t, err, nil)
+	_, err = svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{PriceProvider: "coingecko"})
+	assert.NotEqual(t, err, nil)
+}
+
+// fakePriceProvider 按交易时间返回预设价格的PriceProvider，未预设的时间返回ErrNoPriceData
+type fakePriceProvider struct {
+	historical map[int64]float64
+	current    float64
+}
+
+func (p fakePriceProvider) HistoricalPrice(_ context.Context, mint string, timestamp time.Time) (services.PriceInfo, error) {
+	price, ok := p.historical[timestamp.Unix()]
+	if !ok {
+		return services.PriceInfo{}, fmt.Errorf("%w: %s", services.ErrNoPriceData, mint)
+	}
+	return services.PriceInfo{Source: services.PriceSourceHistorical, Provider: "fake", Price: price}, nil
+}
+
+func (p fakePriceProvider) CurrentPrice(_ context.Context, _ string) (services.PriceInfo, error) {
+	return services.PriceInfo{Source: services.PriceSourceCurrent, Provider: "fake", Price: p.current}, nil
+}
+
+func Test_CalculatePnL_CustomPriceProvider(t *testing.T) {
+	rpcServer := newFakeRPC(t)
+	// OKX地址为空，任何OKX请求都会失败
+	svc, err := services.NewPnlService(rpcServer.server.URL, jupiterPID.String(), services.OKXClient{},
+		services.WithPriceProvider(fakePriceProvider{historical: map[int64]float64{1700000000: 1, 1700000001: 2}, current: 4}))
+	if err != nil {
+		t.Fatalf("创建服务失败: %v", err)
+	}
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+	other := solana.NewWallet().PublicKey()
+
+	// 价格1时用其他代币买入50个，价格2时卖出25个，当前价格4
+	addSwaps(t, rpcServer, 1700000000,
+		swapFixture{
+			User: user,
+			Legs: []tokenLeg{
+				{Mint: other, Decimals: 6, Pre: 50_000_000, Post: 0},
+				{Mint: token, Decimals: 6, Pre: 0, Post: 50_000_000},
+			},
+			Hops: []swapHop{{InputMint: other, InputAmount: 50_000_000, OutputMint: token, OutputAmount: 50_000_000}},
+		},
+		swapFixture{
+			User: user,
+			Legs: []tokenLeg{
+				{Mint: token, Decimals: 6, Pre: 50_000_000, Post: 25_000_000},
+				{Mint: other, Decimals: 6, Pre: 0, Post: 50_000_000},
+			},
+			Hops: []swapHop{{InputMint: token, InputAmount: 25_000_000, OutputMint: other, OutputAmount: 50_000_000}},
+		},
+	)
+
+	results := calculatePnL(t, svc, user, token)
+	assert.Equal(t, len(results), 1)
+	assert.Equal(t, results[0].TotalInvestment, float64(50))
+	assert.Equal(t, results[0].AverageCost, float64(1))
+	assert.Equal(t, results[0].ProfitLossValue, float64(25))
+	assert.Equal(t, results[0].UnrealizedProfitLossValue, float64(75))
+	assert.Equal(t, results[0].Pricing[1].Provider, "fake")
+	assert.Equal(t, results[0].CurrentPrice.Provider, "fake")
+}
+
+func Test_CalculatePnL_DenomSOL(t *testing.T) {
+	rpcServer := newFakeRPC(t)
+	// 所有代币（包括SOL）的历史价格和当前价格都是2
+	svc, err := services.NewPnlService(rpcServer.server.URL, jupiterPID.String(), services.OKXClient{},
+		services.WithPriceProvider(fakePriceProvider{historical: map[int64]float64{1700000000: 2, 1700000001: 2}, current: 2}))
+	if err != nil {
+		t.Fatalf("创建服务失败: %v", err)
+	}
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+
+	// 100 USDC买入100个，再以75 USDC卖出50个，剩余50个按当前价格计算未实现盈亏
+	addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 75_000_000, 50_000_000, false),
+	)
+	txs, _, err := svc.GetTransactions(context.Background(), user.String(), 100)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+
+	usd, err := svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{})
+	assert.Equal(t, err, nil)
+	sol, err := svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{Denomination: services.DenominationSOL})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(usd.Results), 1)
+	assert.Equal(t, len(sol.Results), 1)
+
+	u, s := usd.Results[0], sol.Results[0]
+	assert.Equal(t, u.UnrealizedProfitLossValue, float64(50))
+	assert.Equal(t, s.AverageCost, u.AverageCost/2)
+	assert.Equal(t, s.TotalInvestment, u.TotalInvestment/2)
+	assert.Equal(t, s.ProfitLossValue, u.ProfitLossValue/2)
+	assert.Equal(t, s.UnrealizedProfitLossValue, u.UnrealizedProfitLossValue/2)
+	assert.Equal(t, s.ProfitLossPercentage, u.ProfitLossPercentage)
+	for i := range u.Trades {
+		assert.Equal(t, s.Trades[i].ValueUSD, u.Trades[i].ValueUSD/2)
+		assert.Equal(t, s.Trades[i].RealizedPnL, u.Trades[i].RealizedPnL/2)
+	}
+
+	_, err = svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{Denomination: "btc"})
+	assert.NotEqual(t, err, nil)
+}
+
+func Test_CalculatePnL_Trades(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+
+	// 1美元买入100个、2美元买入100个，再以3美元卖出150个（平均成本1.5）
+	sigs := addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 200_000_000, 100_000_000, true),
+		stableSwap(user, token, 450_000_000, 150_000_000, false),
+	)
+
+	results := calculatePnL(t, svc, user, token)
+	assert.Equal(t, len(results), 1)
+	trades := results[0].Trades
+	assert.Equal(t, len(trades), 3)
+	for i, trade := range trades {
+		assert.Equal(t, trade.Signature, sigs[i])
+		assert.Equal(t, trade.BlockTime, time.Unix(1700000000+int64(i), 0))
+	}
+	assert.Equal(t, trades[0], services.TradeDetail{
+		Signature: sigs[0], BlockTime: trades[0].BlockTime, Side: services.TradeSideBuy,
+		Quantity: 100, ValueUSD: 100, PriceUSD: 1,
+	})
+	assert.Equal(t, trades[1].PriceUSD, float64(2))
+	assert.Equal(t, trades[2].Side, services.TradeSideSell)
+	assert.Equal(t, trades[2].Quantity, float64(150))
+	assert.Equal(t, trades[2].PriceUSD, float64(3))
+	assert.Equal(t, trades[2].RealizedPnL, float64(225))
+}
+
+func Test_CalculatePnL_InitialPosition(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+
+	// 链上历史前已转入100个、成本50美元；链上再以1美元买入100个，然后以2美元卖出150个
+	addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 300_000_000, 150_000_000, false),
+	)
+	txs, _, err := svc.GetTransactions(context.Background(), user.String(), 100)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+
+	calc, err := svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{
+		InitialQuantity: 100,
+		InitialCostUSD:  50,
+	})
+	if err != nil {
+		t.Fatalf("计算PnL失败: %v", err)
+	}
+	assert.Equal(t, len(calc.Results), 1)
+	result := calc.Results[0]
+	assert.Equal(t, result.InitialQuantity, 100.0)
+	assert.Equal(t, result.TotalInvestment, 150.0)
+	assert.Equal(t, result.AverageCost, 0.75)
+	// 300 - 150 * 0.75
+	assert.Equal(t, result.ProfitLossValue, 187.5)
+	assert.Equal(t, result.IsClosed, false)
+
+	_, err = svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{InitialQuantity: -1})
+	assert.NotEqual(t, err, nil)
+	_, err = svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{InitialCostUSD: 10})
+	assert.NotEqual(t, err, nil)
+}
+
+func Test_CalculatePnL_CostBasisMethod(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+
+	// 先以1美元买入100个，再以2美元买入100个，然后以2.5美元卖出100个；当前价格1.5美元
+	addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 200_000_000, 100_000_000, true),
+		stableSwap(user, token, 250_000_000, 100_000_000, false),
+	)
+	txs, _, err := svc.GetTransactions(context.Background(), user.String(), 100)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+
+	for _, tc := range []struct {
+		method     services.CostBasisMethod
+		realized   float64
+		unrealized float64
+	}{
+		// 平均成本1.5：250 - 150，剩余成本150
+		{"", 100, 0},
+		{services.CostBasisAverage, 100, 0},
+		// FIFO卖出消耗1美元的批次：250 - 100，剩余2美元的批次
+		{services.CostBasisFIFO, 150, -50},
+	} {
+		calc, err := svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{
+			CostBasisMethod: tc.method,
+		})
+		if err != nil {
+			t.Fatalf("计算PnL失败(%s): %v", tc.method, err)
+		}
+		assert.Equal(t, len(calc.Results), 1)
+		assert.Equal(t, calc.Results[0].ProfitLossValue, tc.realized)
+		assert.Equal(t, calc.Results[0].UnrealizedProfitLossValue, tc.unrealized)
+		assert.Equal(t, calc.Results[0].AverageCost, 1.5)
+	}
+
+	_, err = svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{CostBasisMethod: "lifo"})
+	assert.NotEqual(t, err, nil)
+}
+
+func Test_CalculateRealizedLots(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+
+	// 以1美元和2美元各买入100个，然后以2.5美元卖出150个，卖出手续费0.01 SOL（0.015美元）
+	sell := stableSwap(user, token, 375_000_000, 150_000_000, false)
+	sell.Fee = 10_000_000
+	sigs := addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 200_000_000, 100_000_000, true),
+		sell,
+	)
+	txs, _, err := svc.GetTransactions(context.Background(), user.String(), 100)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+
+	// 第一个批次持有2秒，第二个批次持有1秒
+	lots, err := svc.CalculateRealizedLots(context.Background(), txs, user.String(), token.String(), services.PnLOptions{}, 2*time.Second)
+	if err != nil {
+		t.Fatalf("计算批次盈亏失败: %v", err)
+	}
+	assert.Equal(t, len(lots), 2)
+	assert.Equal(t, lots[0].AcquisitionSignature, sigs[0])
+	assert.Equal(t, lots[0].DisposalSignature, sigs[2])
+	assert.Equal(t, lots[0].Quantity, 100.0)
+	assert.Equal(t, lots[0].CostBasis, 100.0)
+	assert.Equal(t, lots[0].HoldingPeriod, services.HoldingLongTerm)
+	assert.Equal(t, lots[1].AcquisitionSignature, sigs[1])
+	assert.Equal(t, lots[1].Quantity, 50.0)
+	assert.Equal(t, lots[1].CostBasis, 100.0)
+	assert.Equal(t, lots[1].HoldingPeriod, services.HoldingShortTerm)
+	// 手续费按数量分摊到两个批次的卖出收入
+	assert.Equal(t, lots[0].Proceeds, 249.99)
+	assert.Equal(t, lots[1].Proceeds, 124.995)
+
+	// 批次盈亏合计与method=fifo的已实现盈亏一致
+	calc, err := svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{
+		CostBasisMethod: services.CostBasisFIFO,
+	})
+	if err != nil {
+		t.Fatalf("计算PnL失败: %v", err)
+	}
+	assert.Equal(t, calc.Results[0].ProfitLossValue, lots[0].GainLoss+lots[1].GainLoss)
+
+	// 初始持仓作为最早的批次，没有买入交易
+	lots, err = svc.CalculateRealizedLots(context.Background(), txs, user.String(), token.String(), services.PnLOptions{
+		InitialQuantity: 100,
+		InitialCostUSD:  50,
+		IgnoreFees:      true,
+	}, 0)
+	if err != nil {
+		t.Fatalf("计算批次盈亏失败: %v", err)
+	}
+	assert.Equal(t, len(lots), 2)
+	assert.Equal(t, lots[0].AcquisitionSignature, "")
+	assert.Equal(t, lots[0].CostBasis, 50.0)
+	assert.Equal(t, lots[0].Proceeds, 250.0)
+	assert.Equal(t, lots[0].HoldingPeriod, services.HoldingLongTerm)
+	assert.Equal(t, lots[1].AcquisitionSignature, sigs[0])
+	assert.Equal(t, lots[1].CostBasis, 50.0)
+	assert.Equal(t, lots[1].HoldingPeriod, services.HoldingShortTerm)
+}
+
+func Test_CalculatePnL_NetworkFee(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+
+	// 每笔交易0.01 SOL手续费（含优先费），SOL价格1.5，即每笔0.015美元
+	buy := stableSwap(user, token, 100_000_000, 100_000_000, true)
+	sell := stableSwap(user, token, 110_000_000, 100_000_000, false)
+	buy.Fee, sell.Fee = 10_000_000, 10_000_000
+	addSwaps(t, rpcServer, 1700000000, buy, sell)
+	txs, _, err := svc.GetTransactions(context.Background(), user.String(), 100)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+
+	calc, err := svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{})
+	if err != nil {
+		t.Fatalf("计算PnL失败: %v", err)
+	}
+	assert.Equal(t, len(calc.Results), 1)
+	// 买入成本100.015，卖出收入109.985
+	assert.Equal(t, calc.Results[0].TotalInvestment, 100.015)
+	assert.Equal(t, calc.Results[0].Rounded.ProfitLossValue, 9.97)
+
+	ignored, err := svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{IgnoreFees: true})
+	if err != nil {
+		t.Fatalf("计算PnL失败: %v", err)
+	}
+	assert.Equal(t, ignored.Results[0].ProfitLossValue, 10.0)
+}
+
+func Test_CalculatePnL_SellBeforeBuy(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+
+	// 查询窗口内第一笔是卖出（代币来自空投）：50个换60 USDC；之后100 USDC买入100个，再卖出100个换120 USDC
+	addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 60_000_000, 50_000_000, false),
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 120_000_000, 100_000_000, false),
+	)
+	txs, _, err := svc.GetTransactions(context.Background(), user.String(), 100)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+
+	calc, err := svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{})
+	if err != nil {
+		t.Fatalf("计算PnL失败: %v", err)
+	}
+	assert.Equal(t, len(calc.UnmatchedSells), 1)
+	assert.Equal(t, calc.UnmatchedSells[0].Quantity, 50.0)
+	assert.Equal(t, calc.UnmatchedSells[0].ProceedsUSD, 60.0)
+	assert.Equal(t, len(calc.Warnings), 1)
+
+	// 之后的买卖正常计算，不受前面卖出的影响
+	assert.Equal(t, len(calc.Results), 1)
+	assert.Equal(t, calc.Results[0].ProfitLossValue, 20.0)
+	assert.Equal(t, calc.Results[0].IsClosed, true)
+}
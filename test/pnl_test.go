@@ -1,28 +1,55 @@
 package test
 
 import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/gagliardetto/solana-go"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/assert/v2"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/zhinan22/DPLabsDemo/config"
 	"github.com/zhinan22/DPLabsDemo/handlers"
 	"github.com/zhinan22/DPLabsDemo/services"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // 测试用的响应结构体（与实际保持一致）
 type PnLResponse struct {
-	Results []services.PnLResult `json:"results,omitempty"`
+	Results []services.PnLResult  `json:"results,omitempty"`
+	Total   int                   `json:"total,omitempty"`
+	Error   string                `json:"error,omitempty"`
+	Details []handlers.FieldError `json:"details,omitempty"`
+
+	ClosedPositions []handlers.ClosedPosition `json:"closedPositions,omitempty"`
+	OpenPosition    *handlers.OpenPosition    `json:"openPosition,omitempty"`
+	ClosedSummary   *handlers.ClosedSummary   `json:"closedSummary,omitempty"`
+	Summary         *handlers.PnLSummary      `json:"summary,omitempty"`
+	ByMint          map[string]PnLResponse    `json:"byMint,omitempty"`
+
+	ComputedAt      *time.Time `json:"computedAt,omitempty"`
+	LatestSlot      uint64     `json:"latestSlot,omitempty"`
+	LatestBlockTime *time.Time `json:"latestBlockTime,omitempty"`
 }
 
-// 初始化测试环境
-func setupTest() (*gin.Engine, *services.PnlService) {
+// 初始化测试环境，Solana RPC和OKX均指向本地假服务
+func setupTest(t *testing.T) (*gin.Engine, *fakeRPC) {
 	// 设置gin为测试模式
 	gin.SetMode(gin.TestMode)
 
+	rpcServer := newFakeRPC(t)
+	okxServer := newFakeOKX(t, "1.5")
+	t.Setenv("SOLANA_RPC_URL", rpcServer.server.URL)
+	t.Setenv("BASEURL", okxServer.server.URL)
+
 	// 加载环境变量
 	err := godotenv.Load()
 	if err != nil {
@@ -39,30 +66,804 @@ func setupTest() (*gin.Engine, *services.PnlService) {
 	solanaService, _ := services.NewPnlService(cfg.SolanaRPCUrl, cfg.JupiterProgramID, cfg.OKXClient)
 
 	// 初始化处理器
-	handler := handlers.NewPnLHandler(solanaService)
+	handler := handlers.NewPnLHandler(solanaService, cfg.TransactionLimit)
+
+	// 每个测试使用独立的registry，指标本身是进程内共享的
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(services.MetricsCollectors()...)
+	registry.MustRegister(handlers.MetricsCollectors()...)
 
 	// 设置路由
 	r := gin.Default()
+	r.GET("/metrics", handlers.Metrics(registry))
+	r.Use(handlers.RequestDuration())
 	r.GET("/pnl", handler.GetPnL)
+	r.GET("/pnl/export", handler.ExportTrades)
+	r.GET("/pnl/lots", handler.GetTaxLots)
+	r.POST("/pnl", handler.PostPnL)
+	r.POST("/parse", handler.ParseTransaction)
 
-	return r, solanaService
+	return r, rpcServer
 }
 
-func Test_Pnl(t *testing.T) {
-
-	r, _ := setupTest()
-
+// pnlRequest 构造/pnl请求，limit为空时不传该参数
+func pnlRequest(limit string) *http.Request {
 	req := httptest.NewRequest("GET", "/pnl", nil)
 	q := req.URL.Query()
 	q.Add("userAddress", "DxhVG5CzS5GHWkpZKtnGYYAsmUbE7FgdYbMYK6FGQ8hP")
 	q.Add("tokenMint", "6p6xgHyF7AeE6TZkSmFsko444wqoP15icUSqi2jfGiPN")
-	q.Add("limit", "30") // 非数字的limit
+	if limit != "" {
+		q.Add("limit", limit)
+	}
 	req.URL.RawQuery = q.Encode()
+	return req
+}
+
+func Test_Pnl(t *testing.T) {
+
+	r, rpcServer := setupTest(t)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, pnlRequest("30"))
+
+	// 合法的limit按传入值查询签名
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(30), rpcServer.lastParams("getSignaturesForAddress", 1)["limit"])
+
+	for limit, msg := range map[string]string{"abc": "limit必须是整数", "0": "limit必须大于0", "-5": "limit必须大于0", "10000000": "limit必须在1到1000之间"} {
+		t.Run("limit="+limit, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, pnlRequest(limit))
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			var resp PnLResponse
+			assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, msg, resp.Error)
+		})
+	}
+}
+
+func Test_Pnl_DefaultLimit(t *testing.T) {
+	// 未传limit时使用配置的TRANSACTION_LIMIT
+	t.Setenv("TRANSACTION_LIMIT", "40")
+	r, rpcServer := setupTest(t)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, pnlRequest(""))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(40), rpcServer.lastParams("getSignaturesForAddress", 1)["limit"])
+}
+
+func Test_PostPnl_Validation(t *testing.T) {
+	r, _ := setupTest(t)
+
+	body := `{"userAddress":"not-base58!","tokenMint":"6p6xgHyF7AeE6TZkSmFsko444wqoP15icUSqi2jfGiPN","limit":5000}`
+	req := httptest.NewRequest("POST", "/pnl", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var resp PnLResponse
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &resp))
+	fields := map[string]bool{}
+	for _, d := range resp.Details {
+		fields[d.Field] = true
+	}
+	assert.Equal(t, map[string]bool{"userAddress": true, "limit": true}, fields)
+
+	// 字段类型错误
+	req = httptest.NewRequest("POST", "/pnl", strings.NewReader(`{"limit":"abc"}`))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	resp = PnLResponse{}
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "limit", resp.Details[0].Field)
+}
+
+func Test_Pnl_TableFormat(t *testing.T) {
+	r, _ := setupTest(t)
+
+	req := pnlRequest("10")
+	req.URL.RawQuery += "&format=table"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, true, strings.Contains(w.Body.String(), "共0个头寸"))
+
+	// 不支持的格式
+	req = pnlRequest("10")
+	req.URL.RawQuery += "&format=xml"
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func Test_Pnl_ClosedSummary(t *testing.T) {
+	r, rpcServer := setupTest(t)
+	user := solana.MustPublicKeyFromBase58("DxhVG5CzS5GHWkpZKtnGYYAsmUbE7FgdYbMYK6FGQ8hP")
+	token := solana.MustPublicKeyFromBase58("6p6xgHyF7AeE6TZkSmFsko444wqoP15icUSqi2jfGiPN")
+
+	// 第一轮盈利10 USD，第二轮亏损5 USD，每轮持有1秒
+	addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 110_000_000, 100_000_000, false),
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 95_000_000, 100_000_000, false),
+	)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, pnlRequest("10"))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp PnLResponse
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, &handlers.ClosedSummary{
+		Count:                 2,
+		TotalRealized:         5,
+		Winning:               1,
+		Losing:                1,
+		WinRate:               0.5,
+		AverageHoldingSeconds: 1,
+	}, resp.ClosedSummary)
+	// 两轮各投入100 USD，合计收益5 USD
+	assert.Equal(t, &handlers.PnLSummary{
+		TotalRealized:        5,
+		TotalUnrealized:      0,
+		TotalInvested:        200,
+		ProfitLossPercentage: "2.50%",
+	}, resp.Summary)
+}
+
+func Test_Pnl_OpenPosition(t *testing.T) {
+	r, rpcServer := setupTest(t)
+	user := solana.MustPublicKeyFromBase58("DxhVG5CzS5GHWkpZKtnGYYAsmUbE7FgdYbMYK6FGQ8hP")
+	token := solana.MustPublicKeyFromBase58("6p6xgHyF7AeE6TZkSmFsko444wqoP15icUSqi2jfGiPN")
+
+	// 第一轮平仓盈利10 USD；第二轮1美元买入100个，以1.2美元卖出50个，剩余50个按当前价格1.5计算
+	addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 110_000_000, 100_000_000, false),
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 60_000_000, 50_000_000, false),
+	)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, pnlRequest("10"))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp PnLResponse
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, []handlers.ClosedPosition{{AverageCost: 1, ProfitLossPercentage: "10.00%", ProfitLossValue: 10}}, resp.ClosedPositions)
+	assert.Equal(t, &handlers.OpenPosition{
+		AverageCost:               1,
+		ProfitLossPercentage:      "10.00%",
+		RealizedProfitLossValue:   10,
+		UnrealizedProfitLossValue: 25,
+	}, resp.OpenPosition)
+
+	// 全部平仓时没有openPosition
+	r, rpcServer = setupTest(t)
+	addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 110_000_000, 100_000_000, false),
+	)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, pnlRequest("10"))
+	resp = PnLResponse{}
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, len(resp.ClosedPositions), 1)
+	assert.Equal(t, resp.OpenPosition == nil, true)
+}
+
+func Test_Pnl_Pagination(t *testing.T) {
+	r, rpcServer := setupTest(t)
+	user := solana.MustPublicKeyFromBase58("DxhVG5CzS5GHWkpZKtnGYYAsmUbE7FgdYbMYK6FGQ8hP")
+	token := solana.MustPublicKeyFromBase58("6p6xgHyF7AeE6TZkSmFsko444wqoP15icUSqi2jfGiPN")
+
+	// 三轮买卖，卖出收入依次为110、95、120 USD
+	addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 110_000_000, 100_000_000, false),
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 95_000_000, 100_000_000, false),
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 120_000_000, 100_000_000, false),
+	)
+
+	// 每页2个头寸的第二页只有第三轮
+	req := pnlRequest("10")
+	req.URL.RawQuery += "&offset=2&pageSize=2"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp PnLResponse
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 3, resp.Total)
+	assert.Equal(t, 1, len(resp.Results))
+	assert.Equal(t, float64(20), resp.Results[0].ProfitLossValue)
+	assert.Equal(t, time.Unix(1700000004, 0).UTC(), resp.Results[0].OpenedAt.UTC())
+	// 汇总统计仍基于全部头寸
+	assert.Equal(t, float64(25), resp.Summary.TotalRealized)
+
+	for _, query := range []string{"&offset=-1", "&pageSize=abc", "&pageSize=10000"} {
+		req := pnlRequest("10")
+		req.URL.RawQuery += query
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+}
+
+func Test_Pnl_DebugEvents(t *testing.T) {
+	r, rpcServer := setupTest(t)
+	user := solana.MustPublicKeyFromBase58("DxhVG5CzS5GHWkpZKtnGYYAsmUbE7FgdYbMYK6FGQ8hP")
+	token := solana.MustPublicKeyFromBase58("6p6xgHyF7AeE6TZkSmFsko444wqoP15icUSqi2jfGiPN")
+	middle := solana.NewWallet().PublicKey()
+
+	// USDC -> 中间代币 -> 目标代币的两跳路由
+	fx := stableSwap(user, token, 100_000_000, 50_000_000, true)
+	fx.Hops = []swapHop{
+		{InputMint: usdcMint, InputAmount: 100_000_000, OutputMint: middle, OutputAmount: 7_000_000},
+		{InputMint: middle, InputAmount: 7_000_000, OutputMint: token, OutputAmount: 50_000_000},
+	}
+	addSwaps(t, rpcServer, 1700000000, fx)
+
+	req := pnlRequest("10")
+	req.URL.RawQuery += "&debug=true"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp PnLResponse
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &resp))
+	events := resp.Results[0].Trades[0].Events
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, usdcMint, events[0].InputMint)
+	assert.Equal(t, middle, events[0].OutputMint)
+	assert.Equal(t, uint64(7_000_000), events[0].OutputAmount)
+	assert.Equal(t, middle, events[1].InputMint)
+	assert.Equal(t, token, events[1].OutputMint)
+	assert.Equal(t, uint64(50_000_000), events[1].OutputAmount)
+
+	// 默认不返回事件
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, pnlRequest("10"))
+	resp = PnLResponse{}
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 0, len(resp.Results[0].Trades[0].Events))
+}
+
+func Test_Pnl_Freshness(t *testing.T) {
+	r, rpcServer := setupTest(t)
+
+	// 没有交易时只返回计算时间
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, pnlRequest("10"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp PnLResponse
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.NotEqual(t, nil, resp.ComputedAt)
+	assert.Equal(t, uint64(0), resp.LatestSlot)
+
+	user := solana.MustPublicKeyFromBase58("DxhVG5CzS5GHWkpZKtnGYYAsmUbE7FgdYbMYK6FGQ8hP")
+	token := solana.MustPublicKeyFromBase58("6p6xgHyF7AeE6TZkSmFsko444wqoP15icUSqi2jfGiPN")
+	// 第i笔交易的slot为1700000000+i
+	addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 110_000_000, 100_000_000, false),
+		stableSwap(user, token, 50_000_000, 50_000_000, true),
+	)
+
+	before := time.Now().Truncate(time.Second)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, pnlRequest("10"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	resp = PnLResponse{}
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, uint64(1700000002), resp.LatestSlot)
+	assert.Equal(t, int64(1700000002), resp.LatestBlockTime.Unix())
+	assert.NotEqual(t, nil, resp.ComputedAt)
+	assert.Equal(t, false, resp.ComputedAt.Before(before))
+	assert.Equal(t, false, resp.ComputedAt.After(time.Now()))
+}
+
+func Test_Pnl_ExportCSV(t *testing.T) {
+	r, rpcServer := setupTest(t)
+	user := solana.MustPublicKeyFromBase58("DxhVG5CzS5GHWkpZKtnGYYAsmUbE7FgdYbMYK6FGQ8hP")
+	token := solana.MustPublicKeyFromBase58("6p6xgHyF7AeE6TZkSmFsko444wqoP15icUSqi2jfGiPN")
+	sigs := addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 110_000_000, 100_000_000, false),
+	)
+
+	req := pnlRequest("10")
+	req.URL.Path = "/pnl/export"
+	req.URL.RawQuery += "&format=csv"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="trades_DxhVG5CzS5GHWkpZKtnGYYAsmUbE7FgdYbMYK6FGQ8hP.csv"`, w.Header().Get("Content-Disposition"))
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(rows))
+	assert.Equal(t, []string{"signature", "time", "side", "token", "amount", "priceUSD", "valueUSD", "realizedPnL"}, rows[0])
+	assert.Equal(t, []string{sigs[0], "2023-11-14T22:13:20Z", "buy", token.String(), "100", "1", "100.00", "0.00"}, rows[1])
+	assert.Equal(t, []string{sigs[1], "2023-11-14T22:13:21Z", "sell", token.String(), "100", "1.1", "110.00", "10.00"}, rows[2])
+
+	// 导出不支持其他格式
+	req = pnlRequest("10")
+	req.URL.Path = "/pnl/export"
+	req.URL.RawQuery += "&format=json"
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func Test_Pnl_TaxLotsCSV(t *testing.T) {
+	r, rpcServer := setupTest(t)
+	user := solana.MustPublicKeyFromBase58("DxhVG5CzS5GHWkpZKtnGYYAsmUbE7FgdYbMYK6FGQ8hP")
+	token := solana.MustPublicKeyFromBase58("6p6xgHyF7AeE6TZkSmFsko444wqoP15icUSqi2jfGiPN")
+	sigs := addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 200_000_000, 100_000_000, true),
+		stableSwap(user, token, 375_000_000, 150_000_000, false),
+	)
+
+	req := pnlRequest("10")
+	req.URL.Path = "/pnl/lots"
+	req.URL.RawQuery += "&format=csv"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="lots_6p6xgHyF7AeE6TZkSmFsko444wqoP15icUSqi2jfGiPN.csv"`, w.Header().Get("Content-Disposition"))
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(rows))
+	assert.Equal(t, []string{"acquisitionSignature", "disposalSignature", "acquiredAt", "disposedAt", "quantity", "proceeds", "costBasis", "gainLoss", "holdingPeriod"}, rows[0])
+	// 卖出150个先消耗1美元的批次，再消耗2美元批次中的50个
+	assert.Equal(t, []string{sigs[0], sigs[2], "2023-11-14T22:13:20Z", "2023-11-14T22:13:22Z", "100", "250.00", "100.00", "150.00", "short"}, rows[1])
+	assert.Equal(t, []string{sigs[1], sigs[2], "2023-11-14T22:13:21Z", "2023-11-14T22:13:22Z", "50", "125.00", "100.00", "25.00", "short"}, rows[2])
+
+	// 批次盈亏只按FIFO计算，不支持table格式
+	for _, query := range []string{"&method=average", "&format=table", "&longTermDays=0"} {
+		req = pnlRequest("10")
+		req.URL.Path = "/pnl/lots"
+		req.URL.RawQuery += query
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+}
+
+// scrapeMetric 请求/metrics并返回指定指标（无标签）的值，不存在时返回0
+func scrapeMetric(t *testing.T, r *gin.Engine, name string) float64 {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, name+" "); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("解析指标%s失败: %v", name, err)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+func Test_Metrics_TransactionCache(t *testing.T) {
+	r, rpcServer := setupTest(t)
+	user := solana.MustPublicKeyFromBase58("DxhVG5CzS5GHWkpZKtnGYYAsmUbE7FgdYbMYK6FGQ8hP")
+	token := solana.MustPublicKeyFromBase58("6p6xgHyF7AeE6TZkSmFsko444wqoP15icUSqi2jfGiPN")
+	addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 110_000_000, 100_000_000, false),
+	)
+
+	// 第一次请求两笔交易都未命中缓存
+	hits, misses := scrapeMetric(t, r, "dplabs_tx_cache_hits_total"), scrapeMetric(t, r, "dplabs_tx_cache_misses_total")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, pnlRequest("10"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, hits, scrapeMetric(t, r, "dplabs_tx_cache_hits_total"))
+	assert.Equal(t, misses+2, scrapeMetric(t, r, "dplabs_tx_cache_misses_total"))
+
+	// 重复请求同样的签名时命中缓存
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, pnlRequest("10"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, hits+2, scrapeMetric(t, r, "dplabs_tx_cache_hits_total"))
+	assert.Equal(t, 2, rpcServer.callCount("getTransaction"))
+
+	// 请求耗时按路由记录
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, true, strings.Contains(w.Body.String(), `dplabs_pnl_request_duration_seconds_count{route="/pnl",status="200"}`))
+}
+
+func Test_Pnl_MultipleMints(t *testing.T) {
+	r, rpcServer := setupTest(t)
+	user := solana.MustPublicKeyFromBase58("DxhVG5CzS5GHWkpZKtnGYYAsmUbE7FgdYbMYK6FGQ8hP")
+	tokenA := solana.MustPublicKeyFromBase58("6p6xgHyF7AeE6TZkSmFsko444wqoP15icUSqi2jfGiPN")
+	tokenB := solana.NewWallet().PublicKey()
+
+	// 代币A盈利10 USD，代币B亏损5 USD
+	addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, tokenA, 100_000_000, 100_000_000, true),
+		stableSwap(user, tokenB, 100_000_000, 100_000_000, true),
+		stableSwap(user, tokenA, 110_000_000, 100_000_000, false),
+		stableSwap(user, tokenB, 95_000_000, 100_000_000, false),
+	)
+
+	req := pnlRequest("10")
+	req.URL.RawQuery += "&tokenMint=" + tokenB.String()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp PnLResponse
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 2, len(resp.ByMint))
+	assert.Equal(t, 10.0, resp.ByMint[tokenA.String()].ClosedSummary.TotalRealized)
+	assert.Equal(t, -5.0, resp.ByMint[tokenB.String()].ClosedSummary.TotalRealized)
+	// 交易只获取一次
+	assert.Equal(t, 1, rpcServer.callCount("getSignaturesForAddress"))
+	assert.Equal(t, 4, rpcServer.callCount("getTransaction"))
+}
+
+func Test_Pnl_SignaturesNotSupported(t *testing.T) {
+	r, rpcServer := setupTest(t)
+	rpcServer.disableMethod("getSignaturesForAddress")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, pnlRequest("10"))
+
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	var resp PnLResponse
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, true, strings.Contains(resp.Error, "归档节点"))
+}
+
+func Test_ParseTransaction(t *testing.T) {
+	r, _ := setupTest(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+	raw := buildSwapTx(t, stableSwap(user, token, 10_000_000, 5_000_000, true))
+
+	// 不传userAddress时使用手续费支付者
+	req := httptest.NewRequest("POST", "/parse?tokenMint="+token.String(), strings.NewReader(string(raw)))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp handlers.ParseResponse
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &resp))
+	parsed := resp.Transaction
+	assert.Equal(t, user.String(), parsed.User)
+	assert.Equal(t, 1, len(parsed.Orders))
+	assert.Equal(t, token.String(), parsed.Orders[0].BuyToken.Mint)
+	assert.Equal(t, "5000000", parsed.BalanceChanges[user.String()][token.String()].Amount)
+	assert.Equal(t, jupiterPID.String(), parsed.Instructions.ProgramID)
+	assert.Equal(t, 1, len(parsed.Instructions.Children))
+
+	req = httptest.NewRequest("POST", "/parse", strings.NewReader(`{"slot":1}`))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func Test_Pnl_ETag(t *testing.T) {
+	r, rpcServer := setupTest(t)
+	user := solana.MustPublicKeyFromBase58("DxhVG5CzS5GHWkpZKtnGYYAsmUbE7FgdYbMYK6FGQ8hP")
+	token := solana.MustPublicKeyFromBase58("6p6xgHyF7AeE6TZkSmFsko444wqoP15icUSqi2jfGiPN")
+	addSwaps(t, rpcServer, 1700000000, stableSwap(user, token, 100_000_000, 100_000_000, true))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, pnlRequest("10"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.NotEqual(t, "", etag)
+
+	// 没有新交易时返回304
+	req := pnlRequest("10")
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, 0, w.Body.Len())
+
+	// 参数不同时ETag不同
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, pnlRequest("20"))
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+
+	// 有新交易时重新计算
+	addSwaps(t, rpcServer, 1700000100, stableSwap(user, token, 110_000_000, 100_000_000, false))
+	req = pnlRequest("10")
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+}
+
+func Test_Pnl_PriceProvider(t *testing.T) {
+	r, _ := setupTest(t)
+
+	for _, tc := range []struct {
+		query map[string]string
+		code  int
+	}{
+		{map[string]string{"priceProvider": "coingecko"}, http.StatusBadRequest},
+		{map[string]string{"priceProvider": "fixed"}, http.StatusBadRequest},
+		{map[string]string{"priceProvider": "fixed", "fixedPrice": "abc"}, http.StatusBadRequest},
+		{map[string]string{"priceProvider": "fixed", "fixedPrice": "2"}, http.StatusOK},
+	} {
+		req := pnlRequest("10")
+		q := req.URL.Query()
+		for k, v := range tc.query {
+			q.Add(k, v)
+		}
+		req.URL.RawQuery = q.Encode()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, tc.code, w.Code)
+	}
+}
+
+func Test_Pnl_Method(t *testing.T) {
+	r, _ := setupTest(t)
+
+	for method, code := range map[string]int{"average": http.StatusOK, "fifo": http.StatusOK, "lifo": http.StatusBadRequest} {
+		req := pnlRequest("10")
+		req.URL.RawQuery += "&method=" + method
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, code, w.Code)
+	}
+}
+
+func Test_Pnl_ConcurrentIdenticalRequests(t *testing.T) {
+	r, rpcServer := setupTest(t)
+	rpcServer.addSignatures(5, time.Unix(1700000000, 0))
+	// 每笔交易耗时50ms，保证所有请求在第一次获取完成前到达
+	rpcServer.setTransactionDelay(50 * time.Millisecond)
+
+	const n = 8
+	codes := make(chan int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, pnlRequest("10"))
+			codes <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		assert.Equal(t, http.StatusOK, code)
+	}
+	// 并发的相同请求共用一次签名查询和交易获取
+	assert.Equal(t, 1, rpcServer.callCount("getSignaturesForAddress"))
+	assert.Equal(t, 5, rpcServer.callCount("getTransaction"))
+}
+
+// blockingPriceProvider 查询当前价格时阻塞直到ctx结束，并通过done报告看到的ctx错误
+type blockingPriceProvider struct {
+	started chan struct{}
+	done    chan error
+}
+
+func (p blockingPriceProvider) HistoricalPrice(_ context.Context, _ string, _ time.Time) (services.PriceInfo, error) {
+	return services.PriceInfo{Source: services.PriceSourceHistorical, Price: 1}, nil
+}
+
+func (p blockingPriceProvider) CurrentPrice(ctx context.Context, _ string) (services.PriceInfo, error) {
+	p.started <- struct{}{}
+	<-ctx.Done()
+	p.done <- ctx.Err()
+	return services.PriceInfo{}, ctx.Err()
+}
+
+func Test_LimitConcurrency(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	r := gin.New()
+	r.GET("/pnl", handlers.LimitConcurrency(1, 7), func(c *gin.Context) {
+		started <- struct{}{}
+		<-release
+		c.Status(http.StatusOK)
+	})
+
+	// 第一个请求占用唯一的名额
+	first := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		r.ServeHTTP(first, httptest.NewRequest("GET", "/pnl", nil))
+		close(done)
+	}()
+	<-started
 
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/pnl", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "7", w.Header().Get("Retry-After"))
+	var resp PnLResponse
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.NotEqual(t, "", resp.Error)
+
+	// 第一个请求结束后名额释放
+	close(release)
+	<-done
+	assert.Equal(t, http.StatusOK, first.Code)
+	go func() { <-started }()
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/pnl", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func Test_Pnl_RequestContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	prices := blockingPriceProvider{started: make(chan struct{}, 1), done: make(chan error, 1)}
+	svc, rpcServer := newTestService(t, services.WithPriceProvider(prices))
+	user := solana.MustPublicKeyFromBase58("DxhVG5CzS5GHWkpZKtnGYYAsmUbE7FgdYbMYK6FGQ8hP")
+	token := solana.MustPublicKeyFromBase58("6p6xgHyF7AeE6TZkSmFsko444wqoP15icUSqi2jfGiPN")
+	addSwaps(t, rpcServer, 1700000000, stableSwap(user, token, 100_000_000, 100_000_000, true))
+
+	handler := handlers.NewPnLHandler(svc, 100)
+	r := gin.New()
+	r.GET("/pnl", handler.GetPnL)
+
+	// 客户端在计算过程中断开，PnL计算收到取消
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		r.ServeHTTP(httptest.NewRecorder(), pnlRequest("10").WithContext(ctx))
+	}()
+	<-prices.started
+	cancel()
+	select {
+	case err := <-prices.done:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("PnL计算没有收到取消")
+	}
+	<-served
+
+	// 超过RequestTimeout时返回504
+	handler.RequestTimeout = 50 * time.Millisecond
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, pnlRequest("10"))
+	<-prices.started
+	assert.Equal(t, context.DeadlineExceeded, <-prices.done)
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+}
+
+func Test_Pnl_Denom(t *testing.T) {
+	r, _ := setupTest(t)
+
+	for denom, code := range map[string]int{"usd": http.StatusOK, "sol": http.StatusOK, "btc": http.StatusBadRequest} {
+		req := pnlRequest("10")
+		req.URL.RawQuery += "&denom=" + denom
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, code, w.Code)
+	}
+}
+
+func Test_Pnl_ExcludeSignatures(t *testing.T) {
+	r, rpcServer := setupTest(t)
+	user := solana.MustPublicKeyFromBase58("DxhVG5CzS5GHWkpZKtnGYYAsmUbE7FgdYbMYK6FGQ8hP")
+	token := solana.MustPublicKeyFromBase58("6p6xgHyF7AeE6TZkSmFsko444wqoP15icUSqi2jfGiPN")
+
+	// 第二笔买入是需要排除的交易；排除后1美元买入100个，以1.1美元全部卖出
+	sigs := addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 300_000_000, 100_000_000, true),
+		stableSwap(user, token, 110_000_000, 100_000_000, false),
+	)
+	closed := []handlers.ClosedPosition{{AverageCost: 1, ProfitLossPercentage: "10.00%", ProfitLossValue: 10}}
+
+	// 不排除时平均成本为2，仍有持仓
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, pnlRequest("10"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var resp PnLResponse
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 0, len(resp.ClosedPositions))
+	assert.NotEqual(t, nil, resp.OpenPosition)
+
+	// GET：逗号分隔和重复传参都支持，不存在的签名被忽略
+	req := pnlRequest("10")
+	req.URL.RawQuery += "&excludeSignatures=" + sigs[1] + "," + randomSignature() + "&excludeSignatures=" + randomSignature()
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	resp = PnLResponse{}
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, closed, resp.ClosedPositions)
+	assert.Equal(t, (*handlers.OpenPosition)(nil), resp.OpenPosition)
+
+	// POST
+	body := `{"userAddress":"` + user.String() + `","tokenMint":"` + token.String() + `","limit":10,"excludeSignatures":["` + sigs[1] + `"]}`
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/pnl", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	resp = PnLResponse{}
+	assert.Equal(t, nil, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, closed, resp.ClosedPositions)
+}
+
+func Test_Pnl_UntilSignature(t *testing.T) {
+	r, rpcServer := setupTest(t)
+	sigs := rpcServer.addSignatures(3, time.Unix(1700000000, 0))
+
+	req := pnlRequest("10")
+	req.URL.RawQuery += "&untilSignature=not-a-signature"
 	w := httptest.NewRecorder()
 	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
 
-	// 验证应使用默认limit（100），但不会返回错误
+	req = pnlRequest("10")
+	req.URL.RawQuery += "&untilSignature=" + sigs[0]
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
 	assert.Equal(t, http.StatusOK, w.Code)
+	// 最早的签名是边界，只获取之后的两笔交易
+	assert.Equal(t, 2, rpcServer.callCount("getTransaction"))
+}
+
+func Test_Pnl_InvalidAddress(t *testing.T) {
+	r, rpcServer := setupTest(t)
 
+	for field, value := range map[string]string{"userAddress": "not-a-wallet", "tokenMint": "0OIl"} {
+		req := pnlRequest("10")
+		q := req.URL.Query()
+		q.Set(field, value)
+		req.URL.RawQuery = q.Encode()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, w.Code, http.StatusBadRequest)
+		var resp PnLResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		assert.Equal(t, strings.HasPrefix(resp.Error, field), true)
+	}
+	// 地址不合法时不访问RPC
+	assert.Equal(t, rpcServer.callCount("getSignaturesForAddress"), 0)
+}
+
+func Test_Pnl_TimeRange(t *testing.T) {
+	r, rpcServer := setupTest(t)
+	rpcServer.addSignatures(10, time.Unix(1700000000, 0))
+
+	for query, code := range map[string]int{
+		"&startTime=1700000003&endTime=2023-11-14T22:13:25Z": http.StatusOK,
+		"&startTime=yesterday":                               http.StatusBadRequest,
+		"&startTime=1700000005&endTime=1700000001":           http.StatusBadRequest,
+	} {
+		req := pnlRequest("10")
+		req.URL.RawQuery += query
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, code, w.Code)
+	}
+	// 1700000003到1700000005之间的3笔交易
+	assert.Equal(t, rpcServer.callCount("getTransaction"), 3)
 }
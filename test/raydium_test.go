@@ -0,0 +1,79 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/go-playground/assert/v2"
+	"github.com/zhinan22/DPLabsDemo/services"
+)
+
+func Test_GetTransactionOrders_Raydium(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+	sig := rpcServer.addSignatures(1, time.Unix(1700000000, 0))[0]
+	rpcServer.setTransaction(sig, buildRaydiumSwapTx(t, raydiumSwapFixture{
+		User:       user,
+		Slot:       1000,
+		BlockTime:  1700000000,
+		InputMint:  usdcMint,
+		AmountIn:   10_000_000,
+		OutputMint: token,
+		AmountOut:  5_000_000,
+	}))
+
+	orders, _, err := svc.GetTransactionOrders(context.Background(), sig, user.String(), token.String(), false)
+	if err != nil {
+		t.Fatalf("解析Raydium交易失败: %v", err)
+	}
+	assert.Equal(t, len(orders), 1)
+	assert.Equal(t, orders[0].Source, services.OrderSourceRaydium)
+	assert.Equal(t, orders[0].SellToken.Mint, usdcMint.String())
+	assert.Equal(t, orders[0].SellToken.UiTokenAmount.Amount, "10000000")
+	assert.Equal(t, orders[0].BuyToken.Mint, token.String())
+	assert.Equal(t, orders[0].BuyToken.UiTokenAmount.Amount, "5000000")
+	assert.Equal(t, orders[0].QuoteIsStable, true)
+	assert.Equal(t, orders[0].Execution.Price, 2.0)
+
+	// 其他用户的swap不属于该用户
+	orders, _, err = svc.GetTransactionOrders(context.Background(), sig, solana.NewWallet().PublicKey().String(), token.String(), false)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(orders), 0)
+}
+
+// Raydium和Jupiter的订单一起参与PnL计算
+func Test_CalculatePnL_RaydiumAndJupiter(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+
+	// Jupiter买入100个花费100 USDC，Raydium卖出100个收到110 USDC
+	addSwaps(t, rpcServer, 1700000000, stableSwap(user, token, 100_000_000, 100_000_000, true))
+	sig := rpcServer.addSignatures(1, time.Unix(1700000001, 0))[0]
+	rpcServer.setTransaction(sig, buildRaydiumSwapTx(t, raydiumSwapFixture{
+		User:       user,
+		Slot:       1700000001,
+		BlockTime:  1700000001,
+		InputMint:  token,
+		AmountIn:   100_000_000,
+		OutputMint: usdcMint,
+		AmountOut:  110_000_000,
+	}))
+
+	txs, _, err := svc.GetTransactions(context.Background(), user.String(), 10)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+	assert.Equal(t, len(txs), 2)
+	calc, err := svc.CalculatePnLWithOptions(context.Background(), txs, user.String(), token.String(), services.PnLOptions{IgnoreFees: true})
+	if err != nil {
+		t.Fatalf("计算PnL失败: %v", err)
+	}
+	assert.Equal(t, calc.OrdersBySource, map[string]int{services.OrderSourceJupiter: 1, services.OrderSourceRaydium: 1})
+	assert.Equal(t, len(calc.Results), 1)
+	assert.Equal(t, calc.Results[0].IsClosed, true)
+	assert.Equal(t, calc.Results[0].ProfitLossValue, float64(10))
+}
@@ -0,0 +1,87 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/go-playground/assert/v2"
+	"github.com/zhinan22/DPLabsDemo/services"
+)
+
+func Test_TokenListLoader(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	list := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"address":"EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v","symbol":"USDC","name":"USD Coin","decimals":6,"tags":["verified"]}]`))
+	}))
+	defer list.Close()
+
+	svc.StartTokenListLoader(context.Background(), list.URL, 0)
+
+	info, ok := svc.TokenInfo(usdcMint.String())
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "USDC", info.Symbol)
+	assert.Equal(t, "USD Coin", info.Name)
+	assert.Equal(t, uint8(6), info.Decimals)
+
+	// 列表中的代币精度无需再通过RPC查询
+	decimals, err := svc.GetMintDecimals(context.Background(), usdcMint.String())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint8(6), decimals)
+	assert.Equal(t, 0, rpcServer.callCount("getAccountInfo"))
+
+	_, ok = svc.TokenInfo("unknown")
+	assert.Equal(t, false, ok)
+}
+
+// fakeMetadataProvider 只知道一个代币的元数据提供方，记录查询次数
+type fakeMetadataProvider struct {
+	mint  string
+	calls atomic.Int32
+}
+
+func (p *fakeMetadataProvider) TokenMetadata(_ context.Context, mint string) (services.TokenMetadata, error) {
+	p.calls.Add(1)
+	if mint != p.mint {
+		return services.TokenMetadata{}, fmt.Errorf("%w: %s", services.ErrNoTokenMetadata, mint)
+	}
+	return services.TokenMetadata{Symbol: "BONK", Name: "Bonk", Decimals: 6}, nil
+}
+
+func Test_CalculatePnL_TokenMetadata(t *testing.T) {
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+	provider := &fakeMetadataProvider{mint: token.String()}
+	svc, rpcServer := newTestService(t, services.WithMetadataProvider(provider))
+	addSwaps(t, rpcServer, 1700000000, stableSwap(user, token, 100_000_000, 100_000_000, true))
+	txs, _, err := svc.GetTransactions(context.Background(), user.String(), 10)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		results, err := svc.CalculatePnL(context.Background(), txs, user.String(), token.String())
+		assert.Equal(t, nil, err)
+		assert.Equal(t, &services.TokenMetadata{Mint: token.String(), Symbol: "BONK", Name: "Bonk", Decimals: 6}, results[0].Token)
+
+		out, _ := json.Marshal(results[0])
+		assert.Equal(t, true, strings.Contains(string(out), `"symbol":"BONK"`))
+	}
+	// 查到的元数据被缓存
+	assert.Equal(t, int32(1), provider.calls.Load())
+
+	// 没有元数据时不影响计算
+	svc, rpcServer = newTestService(t, services.WithMetadataProvider(&fakeMetadataProvider{}))
+	addSwaps(t, rpcServer, 1700000000, stableSwap(user, token, 100_000_000, 100_000_000, true))
+	txs, _, _ = svc.GetTransactions(context.Background(), user.String(), 10)
+	results, err := svc.CalculatePnL(context.Background(), txs, user.String(), token.String())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, true, results[0].Token == nil)
+}
@@ -0,0 +1,712 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/go-playground/assert/v2"
+	"github.com/zhinan22/DPLabsDemo/services"
+)
+
+// 假OKX的历史价格和当前价格路径
+const (
+	okxHistoricalPath = "/historical"
+	okxCurrentPath    = "/current"
+)
+
+// newTestService 创建指向假RPC和假OKX的PnlService
+func newTestService(t testing.TB, opts ...services.Option) (*services.PnlService, *fakeRPC) {
+	svc, rpcServer, _ := newTestServiceWithOKX(t, opts...)
+	return svc, rpcServer
+}
+
+// newTestServiceWithOKX 同newTestService，同时返回假OKX用于控制价格接口
+func newTestServiceWithOKX(t testing.TB, opts ...services.Option) (*services.PnlService, *fakeRPC, *fakeOKX) {
+	rpcServer := newFakeRPC(t)
+	okxServer := newFakeOKX(t, "1.5")
+	svc, err := services.NewPnlService(rpcServer.server.URL, jupiterPID.String(), services.OKXClient{
+		BaseUrl:              okxServer.server.URL,
+		MarketHistoricalPath: okxHistoricalPath,
+		MarketCurrentPath:    okxCurrentPath,
+	}, opts...)
+	if err != nil {
+		t.Fatalf("创建服务失败: %v", err)
+	}
+	return svc, rpcServer, okxServer
+}
+
+func Test_GetTransactionOrders_V0(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+
+	user := solana.NewWallet().PublicKey()
+	usdc := solana.MustPublicKeyFromBase58("EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v")
+	token := solana.NewWallet().PublicKey()
+	sig := rpcServer.addSignatures(1, time.Unix(1700000000, 0))[0]
+	rpcServer.setTransaction(sig, buildSwapTx(t, swapFixture{
+		User:      user,
+		Slot:      1000,
+		BlockTime: 1700000000,
+		V0:        true,
+		Fee:       5000,
+		Legs: []tokenLeg{
+			{Mint: usdc, Decimals: 6, Pre: 100_000_000, Post: 90_000_000},
+			{Mint: token, Decimals: 6, Pre: 0, Post: 5_000_000},
+		},
+		Hops: []swapHop{{InputMint: usdc, InputAmount: 10_000_000, OutputMint: token, OutputAmount: 5_000_000}},
+	}))
+
+	orders, _, err := svc.GetTransactionOrders(context.Background(), sig, user.String(), token.String(), false)
+	if err != nil {
+		t.Fatalf("v0交易应当被获取并解析: %v", err)
+	}
+	assert.Equal(t, len(orders), 1)
+	assert.Equal(t, orders[0].BuyToken.Mint, token.String())
+	assert.Equal(t, orders[0].SellToken.Mint, usdc.String())
+	assert.Equal(t, orders[0].BuyToken.UiTokenAmount.Amount, "5000000")
+
+	// 请求中必须声明支持v0版本
+	opts := rpcServer.lastParams("getTransaction", 1)
+	assert.Equal(t, opts["maxSupportedTransactionVersion"], float64(0))
+}
+
+func Test_GetTransactionOrders_Quote(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+
+	user := solana.NewWallet().PublicKey()
+	usdc := solana.MustPublicKeyFromBase58("EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v")
+	token := solana.NewWallet().PublicKey()
+	sig := rpcServer.addSignatures(1, time.Unix(1700000000, 0))[0]
+	// 卖出目标代币换取USDC
+	rpcServer.setTransaction(sig, buildSwapTx(t, swapFixture{
+		User:      user,
+		Slot:      1000,
+		BlockTime: 1700000000,
+		Fee:       5000,
+		Legs: []tokenLeg{
+			{Mint: token, Decimals: 6, Pre: 5_000_000, Post: 0},
+			{Mint: usdc, Decimals: 6, Pre: 0, Post: 12_500_000},
+		},
+		Hops: []swapHop{{InputMint: token, InputAmount: 5_000_000, OutputMint: usdc, OutputAmount: 12_500_000}},
+	}))
+
+	orders, _, err := svc.GetTransactionOrders(context.Background(), sig, user.String(), token.String(), false)
+	if err != nil {
+		t.Fatalf("解析订单失败: %v", err)
+	}
+	assert.Equal(t, len(orders), 1)
+	assert.Equal(t, orders[0].QuoteMint, usdc.String())
+	assert.Equal(t, orders[0].QuoteAmount, "12.500000")
+	assert.Equal(t, orders[0].QuoteIsStable, true)
+}
+
+func Test_TransactionCache_Commitment(t *testing.T) {
+	sigFetches := func(svc *services.PnlService, rpcServer *fakeRPC) int {
+		sig := rpcServer.addSignatures(1, time.Unix(1700000000, 0))[0]
+		for i := 0; i < 2; i++ {
+			if _, _, err := svc.GetTransactionOrders(context.Background(), sig, solana.NewWallet().PublicKey().String(), "SOL", false); err != nil {
+				t.Fatalf("获取交易失败: %v", err)
+			}
+		}
+		return rpcServer.callCount("getTransaction")
+	}
+
+	// finalized交易命中缓存
+	svc, rpcServer := newTestService(t)
+	assert.Equal(t, sigFetches(svc, rpcServer), 1)
+
+	// confirmed交易不缓存时每次都重新获取
+	svc, rpcServer = newTestService(t,
+		services.WithTransactionFetchOptions(services.TransactionFetchOptions{Commitment: rpc.CommitmentConfirmed}),
+		services.WithConfirmedCacheTTL(0),
+	)
+	assert.Equal(t, sigFetches(svc, rpcServer), 2)
+	assert.Equal(t, rpcServer.lastParams("getTransaction", 1)["commitment"], "confirmed")
+}
+
+func Test_GetTransactions_Commitment(t *testing.T) {
+	wallet := solana.NewWallet().PublicKey()
+
+	// 默认finalized
+	svc, rpcServer := newTestService(t)
+	addSwaps(t, rpcServer, 1700000000, repeatSwaps(wallet, 1)...)
+	if _, _, err := svc.GetTransactions(context.Background(), wallet.String(), 1); err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+	assert.Equal(t, rpcServer.lastParams("getSignaturesForAddress", 1)["commitment"], "finalized")
+	assert.Equal(t, rpcServer.lastParams("getTransaction", 1)["commitment"], "finalized")
+
+	// 配置的确认级别同时用于签名列表和交易请求
+	svc, rpcServer = newTestService(t, services.WithCommitment(rpc.CommitmentConfirmed))
+	addSwaps(t, rpcServer, 1700000000, repeatSwaps(wallet, 1)...)
+	if _, _, err := svc.GetTransactions(context.Background(), wallet.String(), 1); err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+	assert.Equal(t, rpcServer.lastParams("getSignaturesForAddress", 1)["commitment"], "confirmed")
+	assert.Equal(t, rpcServer.lastParams("getTransaction", 1)["commitment"], "confirmed")
+}
+
+// 乱序的区块时间和同一时间不同slot的交易，排序结果与原先逐对交换的排序一致
+func Test_GetTransactions_SortByTime(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	wallet := solana.NewWallet().PublicKey()
+	swaps := repeatSwaps(wallet, 6)
+	times := []struct {
+		blockTime int64
+		slot      uint64
+	}{
+		{1700000030, 130}, {1700000010, 112}, {1700000020, 120},
+		{1700000010, 110}, {1700000000, 100}, {1700000010, 111},
+	}
+	sigs := rpcServer.addSignatures(len(swaps), time.Unix(1700000000, 0))
+	expected := make([]*services.Transaction, len(swaps))
+	for i, fx := range swaps {
+		fx.BlockTime, fx.Slot = times[i].blockTime, times[i].slot
+		rpcServer.setTransaction(sigs[i], buildSwapTx(t, fx))
+		expected[i] = &services.Transaction{Signature: sigs[i], Slot: fx.Slot, BlockTime: time.Unix(fx.BlockTime, 0)}
+	}
+
+	// 原先的O(n²)排序
+	for i := 0; i < len(expected); i++ {
+		for j := i + 1; j < len(expected); j++ {
+			if expected[j].BlockTime.Before(expected[i].BlockTime) ||
+				expected[j].BlockTime.Equal(expected[i].BlockTime) && expected[j].Slot < expected[i].Slot {
+				expected[i], expected[j] = expected[j], expected[i]
+			}
+		}
+	}
+
+	txs, _, err := svc.GetTransactions(context.Background(), wallet.String(), len(swaps))
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+	assert.Equal(t, len(txs), len(expected))
+	for i, tx := range txs {
+		assert.Equal(t, tx.Signature, expected[i].Signature)
+	}
+	assert.Equal(t, txs[1].Slot, uint64(110))
+	assert.Equal(t, txs[3].Slot, uint64(112))
+}
+
+// 同一钱包的并发请求（如缓存预热与用户查询同时进行）每笔交易只获取一次，需配合-race运行
+func Test_GetTransactions_ConcurrentSameWallet(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	wallet := solana.NewWallet().PublicKey()
+	addSwaps(t, rpcServer, 1700000000, repeatSwaps(wallet, 20)...)
+	user := wallet.String()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			txs, _, err := svc.GetTransactions(context.Background(), user, 20)
+			if err == nil && len(txs) != 20 {
+				err = fmt.Errorf("期望20笔交易，实际%d笔", len(txs))
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.Equal(t, rpcServer.callCount("getTransaction"), 20)
+}
+
+// 负责获取交易的请求超时后，等待同一签名的其他请求重新获取，而不是返回对方的超时错误
+func Test_GetTransactions_OwnerCancelled(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+	sigs := addSwaps(t, rpcServer, 1700000000, stableSwap(user, token, 100_000_000, 100_000_000, true))
+	rpcServer.setTransactionDelay(100 * time.Millisecond)
+
+	owner := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+		defer cancel()
+		_, _, err := svc.GetTransactions(ctx, user.String(), 10)
+		owner <- err
+	}()
+	// 第一个请求获取交易期间查询同一签名的订单，等待其结果
+	time.Sleep(10 * time.Millisecond)
+	orders, _, err := svc.GetTransactionOrders(context.Background(), sigs[0], user.String(), token.String(), false)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(orders))
+	assert.NotEqual(t, nil, <-owner)
+}
+
+func Test_GetTransactionOrders_SOLReconciliation(t *testing.T) {
+	svc, rpcServer := newTestService(t, services.WithSOLReconciliation(0.01))
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+
+	solBuy := func(eventLamports uint64) swapFixture {
+		return swapFixture{
+			User: user,
+			Fee:  5000,
+			Legs: []tokenLeg{
+				{Mint: solana.SolMint, Decimals: 9, Pre: 10_000_000_000, Post: 9_000_000_000},
+				{Mint: token, Decimals: 6, Pre: 0, Post: 5_000_000},
+			},
+			Hops: []swapHop{{InputMint: solana.SolMint, InputAmount: eventLamports, OutputMint: token, OutputAmount: 5_000_000}},
+		}
+	}
+	// 第一笔事件数量与余额变化一致，第二笔事件只记录了一半
+	sigs := addSwaps(t, rpcServer, 1700000000, solBuy(1_000_000_000), solBuy(500_000_000))
+
+	orders, _, err := svc.GetTransactionOrders(context.Background(), sigs[0], user.String(), token.String(), false)
+	if err != nil {
+		t.Fatalf("解析订单失败: %v", err)
+	}
+	assert.Equal(t, len(orders), 1)
+	assert.Equal(t, len(orders[0].Warnings), 0)
+
+	orders, _, err = svc.GetTransactionOrders(context.Background(), sigs[1], user.String(), token.String(), false)
+	if err != nil {
+		t.Fatalf("解析订单失败: %v", err)
+	}
+	assert.Equal(t, len(orders), 1)
+	assert.Equal(t, len(orders[0].Warnings), 1)
+}
+
+func Test_EstimateCost(t *testing.T) {
+	svc, rpcServer := newTestService(t, services.WithRequestCostCap(100, 0))
+
+	estimate := svc.EstimateCost(120)
+	assert.Equal(t, estimate, services.CostEstimate{SignaturePages: 3, TransactionCalls: 120, RPCCalls: 123, OKXCalls: 241})
+
+	// 超过上限时不发起任何RPC调用
+	_, _, err := svc.GetTransactions(context.Background(), solana.NewWallet().PublicKey().String(), 120)
+	var overCap *services.CostCapExceededError
+	assert.Equal(t, errors.As(err, &overCap), true)
+	assert.Equal(t, rpcServer.callCount("getSignaturesForAddress"), 0)
+
+	_, _, err = svc.GetTransactions(context.Background(), solana.NewWallet().PublicKey().String(), 90)
+	assert.Equal(t, err, nil)
+}
+
+// 用户作为第二签名者与对手方共同签名的swap，双方余额都有变化，订单只取用户自己账户的变化
+func Test_GetTransactionOrders_SecondarySigner(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	counterparty := solana.NewWallet().PublicKey()
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+
+	sigs := addSwaps(t, rpcServer, 1700000000, swapFixture{
+		User:    counterparty, // 对手方支付手续费
+		Signers: []solana.PublicKey{user},
+		Fee:     5000,
+		Legs: []tokenLeg{
+			{Mint: usdcMint, Decimals: 6, Pre: 30_000_000, Post: 0},
+			{Mint: token, Decimals: 6, Pre: 0, Post: 20_000_000},
+		},
+		// 用户支付10 USDC，收到的5个代币分布在两个代币账户中
+		Others: []otherOwnerLeg{
+			{Owner: user, tokenLeg: tokenLeg{Mint: usdcMint, Decimals: 6, Pre: 10_000_000, Post: 0}},
+			{Owner: user, tokenLeg: tokenLeg{Mint: token, Decimals: 6, Pre: 0, Post: 3_000_000}},
+			{Owner: user, tokenLeg: tokenLeg{Mint: token, Decimals: 6, Pre: 1_000_000, Post: 3_000_000}},
+		},
+		Hops: []swapHop{{InputMint: usdcMint, InputAmount: 40_000_000, OutputMint: token, OutputAmount: 25_000_000}},
+	})
+
+	orders, _, err := svc.GetTransactionOrders(context.Background(), sigs[0], user.String(), token.String(), false)
+	if err != nil {
+		t.Fatalf("解析订单失败: %v", err)
+	}
+	assert.Equal(t, len(orders), 1)
+	assert.Equal(t, orders[0].BuyToken.UiTokenAmount.Amount, "5000000")
+	assert.Equal(t, orders[0].SellToken.UiTokenAmount.Amount, "10000000")
+
+	orders, _, err = svc.GetTransactionOrders(context.Background(), sigs[0], counterparty.String(), token.String(), false)
+	if err != nil {
+		t.Fatalf("解析订单失败: %v", err)
+	}
+	assert.Equal(t, len(orders), 1)
+	assert.Equal(t, orders[0].BuyToken.UiTokenAmount.Amount, "20000000")
+	assert.Equal(t, orders[0].SellToken.UiTokenAmount.Amount, "30000000")
+}
+
+func Test_GetTransactions_OneFailing(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	sigs := rpcServer.addSignatures(5, time.Unix(1700000000, 0))
+	rpcServer.failTransaction(sigs[2])
+	user := solana.NewWallet().PublicKey().String()
+
+	// 单笔交易获取失败时返回包含签名的错误，而不是panic
+	txs, _, err := svc.GetTransactions(context.Background(), user, 5)
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, len(txs), 0)
+	assert.Equal(t, strings.Contains(err.Error(), sigs[2]), true)
+}
+
+func Test_GetTransactions_RetryTransient(t *testing.T) {
+	svc, rpcServer := newTestService(t, services.WithRPCRetry(3, time.Millisecond))
+	wallet := solana.NewWallet().PublicKey()
+	sigs := addSwaps(t, rpcServer, 1700000000, repeatSwaps(wallet, 1)...)
+	// 前两次请求被限流，第三次成功
+	rpcServer.rateLimitTransaction(sigs[0], 2)
+
+	txs, _, err := svc.GetTransactions(context.Background(), wallet.String(), 1)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+	assert.Equal(t, len(txs), 1)
+	assert.Equal(t, txs[0].Signature, sigs[0])
+	assert.Equal(t, rpcServer.callCount("getTransaction"), 3)
+
+	// 超过最大尝试次数后返回错误
+	svc, rpcServer = newTestService(t, services.WithRPCRetry(2, time.Millisecond))
+	sigs = addSwaps(t, rpcServer, 1700000000, repeatSwaps(wallet, 1)...)
+	rpcServer.rateLimitTransaction(sigs[0], 2)
+	_, _, err = svc.GetTransactions(context.Background(), wallet.String(), 1)
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, rpcServer.callCount("getTransaction"), 2)
+}
+
+func Test_GetTransactions_BoundedConcurrency(t *testing.T) {
+	svc, rpcServer := newTestService(t, services.WithRPCConcurrency(4))
+	wallet := solana.NewWallet().PublicKey()
+	addSwaps(t, rpcServer, 1700000000, repeatSwaps(wallet, 40)...)
+	rpcServer.setTransactionDelay(10 * time.Millisecond)
+	user := wallet.String()
+
+	txs, _, err := svc.GetTransactions(context.Background(), user, 40)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+	assert.Equal(t, len(txs), 40)
+	// 同时进行的请求不超过配置的上限
+	assert.Equal(t, rpcServer.maxInflight() <= 4, true)
+	assert.Equal(t, rpcServer.maxInflight() > 1, true)
+}
+
+func Test_GetTransactions_BatchAPI(t *testing.T) {
+	svc, rpcServer := newTestService(t, services.WithBatchAPI(true))
+	wallet := solana.NewWallet().PublicKey()
+	addSwaps(t, rpcServer, 1700000000, repeatSwaps(wallet, 50)...)
+
+	// 50笔交易在一次批量请求中获取，加上一次签名查询共两次HTTP请求
+	txs, _, err := svc.GetTransactions(context.Background(), wallet.String(), 50)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+	assert.Equal(t, len(txs), 50)
+	assert.Equal(t, rpcServer.callCount("getTransaction"), 50)
+	assert.Equal(t, rpcServer.callCount("getSignaturesForAddress"), 1)
+	assert.Equal(t, rpcServer.requestCount(), 2)
+
+	// 批内单笔失败时逐笔重新获取，仍失败则返回错误
+	svc, rpcServer = newTestService(t, services.WithBatchAPI(true))
+	sigs := addSwaps(t, rpcServer, 1700000000, repeatSwaps(wallet, 50)...)
+	rpcServer.failTransaction(sigs[10])
+	txs, _, err = svc.GetTransactions(context.Background(), wallet.String(), 50)
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, len(txs), 0)
+	assert.Equal(t, strings.Contains(err.Error(), sigs[10]), true)
+
+	// 节点不支持批量请求时回退为并发单笔请求
+	svc, rpcServer = newTestService(t, services.WithBatchAPI(true))
+	addSwaps(t, rpcServer, 1700000000, repeatSwaps(wallet, 50)...)
+	rpcServer.disableBatch()
+	txs, _, err = svc.GetTransactions(context.Background(), wallet.String(), 50)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+	assert.Equal(t, len(txs), 50)
+	assert.Equal(t, rpcServer.callCount("getTransaction"), 50)
+	assert.Equal(t, rpcServer.requestCount(), 52)
+}
+
+func Test_GetTransactionOrders_EventOrder(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+	mid := solana.NewWallet().PublicKey()
+
+	// 两跳USDC -> mid -> token：第一跳的事件记在第二条顶层指令下且先列出，
+	// 树遍历先访问第二跳，按指令索引排序后第一跳在前
+	sigs := addSwaps(t, rpcServer, 1700000000, swapFixture{
+		User: user,
+		Legs: []tokenLeg{
+			{Mint: usdcMint, Decimals: 6, Pre: 100_000_000, Post: 0},
+			{Mint: token, Decimals: 6, Pre: 0, Post: 20_000_000},
+		},
+		Hops: []swapHop{
+			{InputMint: usdcMint, InputAmount: 100_000_000, OutputMint: mid, OutputAmount: 50_000_000},
+			{InputMint: mid, InputAmount: 50_000_000, OutputMint: token, OutputAmount: 20_000_000},
+		},
+		EventTops: []int{1, 0},
+	})
+
+	orders, _, err := svc.GetTransactionOrders(context.Background(), sigs[0], user.String(), token.String(), false)
+	if err != nil {
+		t.Fatalf("解析订单失败: %v", err)
+	}
+	assert.Equal(t, len(orders), 1)
+	assert.Equal(t, orders[0].SellToken.Mint, usdcMint.String())
+	assert.Equal(t, orders[0].BuyToken.Mint, token.String())
+	assert.Equal(t, orders[0].SellToken.UiTokenAmount.Amount, "100000000")
+	assert.Equal(t, orders[0].BuyToken.UiTokenAmount.Amount, "20000000")
+	assert.Equal(t, orders[0].Events[0].InputMint, usdcMint)
+}
+
+func Test_GetTransactionOrders_MultipleRoutes(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+
+	// 同一笔交易中两个route：100 USDC买入100个，再卖出40个换50 USDC
+	sigs := addSwaps(t, rpcServer, 1700000000, swapFixture{
+		User: user,
+		Legs: []tokenLeg{
+			{Mint: usdcMint, Decimals: 6, Pre: 100_000_000, Post: 50_000_000},
+			{Mint: token, Decimals: 6, Pre: 0, Post: 60_000_000},
+		},
+		Routes: [][]swapHop{
+			{{InputMint: usdcMint, InputAmount: 100_000_000, OutputMint: token, OutputAmount: 100_000_000}},
+			{{InputMint: token, InputAmount: 40_000_000, OutputMint: usdcMint, OutputAmount: 50_000_000}},
+		},
+	})
+
+	orders, _, err := svc.GetTransactionOrders(context.Background(), sigs[0], user.String(), token.String(), false)
+	if err != nil {
+		t.Fatalf("解析订单失败: %v", err)
+	}
+	assert.Equal(t, len(orders), 2)
+	assert.Equal(t, orders[0].BuyToken.Mint, token.String())
+	assert.Equal(t, orders[0].BuyToken.UiTokenAmount.Amount, "100000000")
+	assert.Equal(t, orders[0].SellToken.UiTokenAmount.Amount, "100000000")
+	assert.Equal(t, orders[1].SellToken.Mint, token.String())
+	assert.Equal(t, orders[1].SellToken.UiTokenAmount.Amount, "40000000")
+	assert.Equal(t, orders[1].BuyToken.UiTokenAmount.Amount, "50000000")
+
+	results := calculatePnL(t, svc, user, token)
+	assert.Equal(t, len(results), 1)
+	assert.Equal(t, results[0].TotalInvestment, float64(100))
+	assert.Equal(t, results[0].ProfitLossValue, float64(10))
+}
+
+func Test_GetTransactionOrders_Verbose(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+	sigs := addSwaps(t, rpcServer, 1700000000, stableSwap(user, token, 100_000_000, 50_000_000, true))
+
+	orders, diag, err := svc.GetTransactionOrders(context.Background(), sigs[0], user.String(), token.String(), true)
+	if err != nil {
+		t.Fatalf("解析订单失败: %v", err)
+	}
+	assert.Equal(t, len(orders), 1)
+	if diag == nil {
+		t.Fatal("verbose时应返回诊断信息")
+	}
+	// route指令账户：用户及其两个代币账户
+	assert.Equal(t, len(diag.RouteAccounts), 3)
+	assert.Equal(t, diag.RouteAccounts[0], user.String())
+	assert.Equal(t, len(diag.Events), 1)
+	assert.Equal(t, diag.Events[0].InputMint, usdcMint)
+	assert.Equal(t, diag.Events[0].InputAmount, uint64(100_000_000))
+	assert.Equal(t, diag.Events[0].OutputMint, token)
+	assert.Equal(t, diag.Events[0].OutputAmount, uint64(50_000_000))
+	assert.Equal(t, diag.SellMint, usdcMint.String())
+	assert.Equal(t, diag.BuyMint, token.String())
+
+	// 非verbose时不收集诊断信息
+	_, diag, err = svc.GetTransactionOrders(context.Background(), sigs[0], user.String(), token.String(), false)
+	if err != nil {
+		t.Fatalf("解析订单失败: %v", err)
+	}
+	assert.Equal(t, diag, (*services.RouteDiagnostic)(nil))
+}
+
+func Test_GetTransactionOrders_SkippedRouteLogged(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	svc, rpcServer := newTestService(t, services.WithLogger(logger))
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+	unknown := solana.NewWallet().PublicKey()
+
+	// 第二个route换成的代币不在余额变化中，精度未知，该route被跳过
+	sigs := addSwaps(t, rpcServer, 1700000000, swapFixture{
+		User: user,
+		Legs: []tokenLeg{
+			{Mint: usdcMint, Decimals: 6, Pre: 100_000_000, Post: 0},
+			{Mint: token, Decimals: 6, Pre: 0, Post: 60_000_000},
+		},
+		Routes: [][]swapHop{
+			{{InputMint: usdcMint, InputAmount: 100_000_000, OutputMint: token, OutputAmount: 100_000_000}},
+			{{InputMint: token, InputAmount: 40_000_000, OutputMint: unknown, OutputAmount: 50_000_000}},
+		},
+	})
+
+	ctx := services.ContextWithRequestID(context.Background(), "req-1")
+	orders, _, err := svc.GetTransactionOrders(ctx, sigs[0], user.String(), token.String(), false)
+	if err != nil {
+		t.Fatalf("解析订单失败: %v", err)
+	}
+	assert.Equal(t, len(orders), 1)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("解析日志失败: %v, 日志: %s", err, logs.String())
+	}
+	assert.Equal(t, entry["level"], "DEBUG")
+	assert.Equal(t, entry["signature"], sigs[0])
+	assert.Equal(t, entry["requestId"], "req-1")
+}
+
+func Test_GetTransactionOrders_ZeroChangeEpsilon(t *testing.T) {
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+	hop := swapHop{InputMint: usdcMint, InputAmount: 10_000_000, OutputMint: token, OutputAmount: 10_000_000}
+	// 自路由套利：swap事件存在，但两边余额只变化了1个最小单位
+	dust := swapFixture{
+		User: user,
+		Legs: []tokenLeg{
+			{Mint: usdcMint, Decimals: 6, Pre: 100_000_000, Post: 99_999_999},
+			{Mint: token, Decimals: 6, Pre: 0, Post: 1},
+		},
+		Hops: []swapHop{hop},
+	}
+	// 两边余额完全没有变化
+	unchanged := swapFixture{
+		User: user,
+		Legs: []tokenLeg{
+			{Mint: usdcMint, Decimals: 6, Pre: 100_000_000, Post: 100_000_000},
+			{Mint: token, Decimals: 6, Pre: 5_000_000, Post: 5_000_000},
+		},
+		Hops: []swapHop{hop},
+	}
+
+	for _, tc := range []struct {
+		epsilon   float64
+		dust      int
+		unchanged int
+	}{
+		{epsilon: 0, dust: 1, unchanged: 0},
+		{epsilon: 0.00001, dust: 0, unchanged: 0},
+	} {
+		svc, rpcServer := newTestService(t, services.WithZeroChangeEpsilon(tc.epsilon))
+		sigs := addSwaps(t, rpcServer, 1700000000, dust, unchanged)
+
+		orders, _, err := svc.GetTransactionOrders(context.Background(), sigs[0], user.String(), token.String(), false)
+		if err != nil {
+			t.Fatalf("解析订单失败: %v", err)
+		}
+		assert.Equal(t, len(orders), tc.dust)
+
+		orders, _, err = svc.GetTransactionOrders(context.Background(), sigs[1], user.String(), token.String(), false)
+		if err != nil {
+			t.Fatalf("解析订单失败: %v", err)
+		}
+		assert.Equal(t, len(orders), tc.unchanged)
+	}
+}
+
+func Test_GetTransactionOrders_FailedTransaction(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+
+	failed := stableSwap(user, token, 100_000_000, 100_000_000, true)
+	failed.Failed = true
+	sigs := addSwaps(t, rpcServer, 1700000000, failed)
+
+	orders, _, err := svc.GetTransactionOrders(context.Background(), sigs[0], user.String(), token.String(), false)
+	if err != nil {
+		t.Fatalf("解析订单失败: %v", err)
+	}
+	assert.Equal(t, len(orders), 0)
+	assert.Equal(t, len(calculatePnL(t, svc, user, token)), 0)
+}
+
+func Test_GetTransactions_SignatureBudget(t *testing.T) {
+	svc, rpcServer := newTestService(t, services.WithSignatureFetchBudget(120*time.Millisecond))
+	user := solana.NewWallet().PublicKey()
+	swaps := repeatSwaps(user, 200)
+	addSwaps(t, rpcServer, 1700000000, swaps...)
+	// 每页50个签名耗时50ms，预算内只能取完前两页
+	rpcServer.setSignatureDelay(50 * time.Millisecond)
+
+	txs, truncated, err := svc.GetTransactions(context.Background(), user.String(), 200)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, truncated, true)
+	if len(txs) == 0 || len(txs) >= 200 {
+		t.Fatalf("截断时应返回部分交易，实际%d笔", len(txs))
+	}
+
+	// 调用方取消不是超出预算，返回错误而不是截断的结果
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, truncated, err = svc.GetTransactions(ctx, user.String(), 200)
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, truncated, false)
+
+	// 不设置预算时取完全部签名
+	svc, rpcServer = newTestService(t)
+	addSwaps(t, rpcServer, 1700000000, swaps...)
+	rpcServer.setSignatureDelay(50 * time.Millisecond)
+	txs, truncated, err = svc.GetTransactions(context.Background(), user.String(), 200)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, truncated, false)
+	assert.Equal(t, len(txs), 200)
+}
+
+func Test_GetTransactionsInRange(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	// 300笔交易，时间为1700000000到1700000299，每页50个签名
+	wallet := solana.NewWallet().PublicKey()
+	addSwaps(t, rpcServer, 1700000000, repeatSwaps(wallet, 300)...)
+	user := wallet.String()
+
+	timeRange := services.TimeRange{Start: time.Unix(1700000100, 0), End: time.Unix(1700000149, 0)}
+	txs, truncated, err := svc.GetTransactionsInRange(context.Background(), user, 1000, timeRange)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+	assert.Equal(t, truncated, false)
+	assert.Equal(t, len(txs), 50)
+	assert.Equal(t, txs[0].BlockTime.Unix(), int64(1700000100))
+	assert.Equal(t, txs[len(txs)-1].BlockTime.Unix(), int64(1700000149))
+	// 第5页的第一个签名早于Start后停止分页，不再获取更早的签名
+	assert.Equal(t, rpcServer.callCount("getSignaturesForAddress"), 5)
+	assert.Equal(t, rpcServer.callCount("getTransaction"), 50)
+}
+
+func Test_GetTransactionsInRange_UntilSignature(t *testing.T) {
+	svc, rpcServer := newTestService(t)
+	// 300笔交易，每页50个签名；客户端已同步到第120笔（时间1700000119）
+	wallet := solana.NewWallet().PublicKey()
+	sigs := addSwaps(t, rpcServer, 1700000000, repeatSwaps(wallet, 300)...)
+	user := wallet.String()
+
+	timeRange := services.TimeRange{Until: solana.MustSignatureFromBase58(sigs[119])}
+	txs, _, err := svc.GetTransactionsInRange(context.Background(), user, 1000, timeRange)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+	// 只返回比边界签名更新的180笔，不包含边界签名本身
+	assert.Equal(t, len(txs), 180)
+	assert.Equal(t, txs[0].Signature, sigs[120])
+	assert.Equal(t, txs[len(txs)-1].Signature, sigs[299])
+	// 第4页到达边界后只有30个签名，分页结束
+	assert.Equal(t, rpcServer.callCount("getSignaturesForAddress"), 4)
+	assert.Equal(t, rpcServer.callCount("getTransaction"), 180)
+	assert.Equal(t, rpcServer.lastParams("getSignaturesForAddress", 1)["until"], sigs[119])
+}
@@ -0,0 +1,85 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gagliardetto/solana-go"
+	"github.com/go-playground/assert/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/zhinan22/DPLabsDemo/services"
+)
+
+func Test_RedisCache(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	cache := services.NewRedisCache(client, time.Hour)
+
+	svc, rpcServer := newTestService(t, services.WithTransactionCache(cache))
+	user := solana.NewWallet().PublicKey()
+	token := solana.NewWallet().PublicKey()
+	addSwaps(t, rpcServer, 1700000000,
+		stableSwap(user, token, 100_000_000, 100_000_000, true),
+		stableSwap(user, token, 110_000_000, 100_000_000, false),
+	)
+
+	// 未命中：从RPC获取并写入Redis，finalized交易使用配置的TTL
+	_, _, err := svc.GetTransactions(context.Background(), user.String(), 10)
+	if err != nil {
+		t.Fatalf("获取交易失败: %v", err)
+	}
+	assert.Equal(t, rpcServer.callCount("getTransaction"), 2)
+	assert.Equal(t, len(mr.Keys()), 2)
+	assert.Equal(t, mr.TTL(mr.Keys()[0]), time.Hour)
+
+	// 命中：模拟重启后的新实例共享同一Redis，不再请求RPC，缓存的交易可以正常解析
+	okxServer := newFakeOKX(t, "1.5")
+	restarted, err := services.NewPnlService(rpcServer.server.URL, jupiterPID.String(), services.OKXClient{
+		BaseUrl:              okxServer.server.URL,
+		MarketHistoricalPath: okxHistoricalPath,
+		MarketCurrentPath:    okxCurrentPath,
+	}, services.WithTransactionCache(cache))
+	if err != nil {
+		t.Fatalf("创建服务失败: %v", err)
+	}
+	results := calculatePnL(t, restarted, user, token)
+	assert.Equal(t, rpcServer.callCount("getTransaction"), 2)
+	assert.Equal(t, len(results), 1)
+	assert.Equal(t, results[0].ProfitLossValue, 10.0)
+
+	_, ok := cache.Get(context.Background(), "finalized:unknown")
+	assert.Equal(t, ok, false)
+
+	assert.Equal(t, cache.Clear(context.Background()), 2)
+	assert.Equal(t, len(mr.Keys()), 0)
+}
+
+func Test_MemoryCache_LRU(t *testing.T) {
+	ctx := context.Background()
+	cache := services.NewMemoryCache(3)
+
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Set(ctx, key, &services.Transaction{Signature: key}, 0)
+	}
+	// 访问a后b成为最久未使用的条目
+	_, ok := cache.Get(ctx, "a")
+	assert.Equal(t, ok, true)
+	for _, key := range []string{"d", "e"} {
+		cache.Set(ctx, key, &services.Transaction{Signature: key}, 0)
+	}
+
+	assert.Equal(t, cache.Len(), 3)
+	for key, want := range map[string]bool{"a": true, "b": false, "c": false, "d": true, "e": true} {
+		_, ok := cache.Get(ctx, key)
+		assert.Equal(t, ok, want)
+	}
+
+	// 过期条目视为未命中
+	cache.Set(ctx, "f", &services.Transaction{Signature: "f"}, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	_, ok = cache.Get(ctx, "f")
+	assert.Equal(t, ok, false)
+	assert.Equal(t, cache.Len(), 2)
+}
@@ -252,6 +252,61 @@ func (i Int) Positive() bool {
 	return i.Sign() > 0
 }
 
+// IsNegative 是否负数；底层为nil时按0处理
+func (i Int) IsNegative() bool {
+	return i.Sign() < 0
+}
+
+// Sign 负数返回-1，0返回0，正数返回1；覆盖big.Int.Sign，底层为nil时按0处理
+func (i Int) Sign() int {
+	return i.Big().Sign()
+}
+
 func (i Int) Copy() Int {
 	return Int{new(big.Int).Set(i.Int)}
 }
+
+// Add 返回i+x，不修改i和x；底层为nil时按0处理
+func (i Int) Add(x Int) Int {
+	return Int{new(big.Int).Add(i.Big(), x.Big())}
+}
+
+// Sub 返回i-x，不修改i和x；底层为nil时按0处理
+func (i Int) Sub(x Int) Int {
+	return Int{new(big.Int).Sub(i.Big(), x.Big())}
+}
+
+// Mul 返回i*x，不修改i和x；底层为nil时按0处理
+func (i Int) Mul(x Int) Int {
+	return Int{new(big.Int).Mul(i.Big(), x.Big())}
+}
+
+// Div 返回i/x（向零截断，同big.Int.Quo），不修改i和x；底层为nil时按0处理，x为0时与big.Int一样panic
+func (i Int) Div(x Int) Int {
+	return Int{new(big.Int).Quo(i.Big(), x.Big())}
+}
+
+// Neg 返回-i，不修改i；底层为nil时按0处理
+func (i Int) Neg() Int {
+	return Int{new(big.Int).Neg(i.Big())}
+}
+
+// Cmp 比较i和x：i<x返回-1，i==x返回0，i>x返回1；底层为nil时按0处理
+func (i Int) Cmp(x Int) int {
+	return i.Big().Cmp(x.Big())
+}
+
+// Equal 判断i==x；底层为nil时按0处理
+func (i Int) Equal(x Int) bool {
+	return i.Cmp(x) == 0
+}
+
+// LessThan 判断i<x；底层为nil时按0处理
+func (i Int) LessThan(x Int) bool {
+	return i.Cmp(x) < 0
+}
+
+// GreaterThan 判断i>x；底层为nil时按0处理
+func (i Int) GreaterThan(x Int) bool {
+	return i.Cmp(x) > 0
+}